@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"pole/internal/conf"
+	"pole/internal/pb"
+	poleRaft "pole/internal/raft"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	backupTables     []string
+	backupRepository string
+	backupAddr       string
+
+	backupCmd = &cobra.Command{
+		Use:   "backup",
+		Short: "Snapshot indexes into a repository",
+		Long: `Snapshot indexes into a repository through the grpc api, the repository is
+a directory uri like file:///backups/pole and keeps the segments shared by
+its snapshots once`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return execBrie("BACKUP", "TO")
+		},
+	}
+
+	restoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Restore indexes from a repository",
+		Long: `Restore indexes from the newest snapshot of a repository holding them, or
+from the snapshot given as the fragment of the uri like file:///backups/pole#3`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return execBrie("RESTORE", "FROM")
+		},
+	}
+)
+
+// execBrie sends a BACKUP or RESTORE of the tables, of every table without
+// --index.
+func execBrie(stmt, direction string) error {
+	target := "DATABASE *"
+	if len(backupTables) > 0 {
+		target = "TABLE `" + strings.Join(backupTables, "`, `") + "`"
+	}
+	sql := fmt.Sprintf("%s %s %s '%s'", stmt, target, direction, strings.ReplaceAll(backupRepository, "'", "''"))
+
+	addr := backupAddr
+	if addr == "" {
+		addr = conf.GetGrpcAddr()
+	}
+	client, err := poleRaft.GetClientConn(addr)
+	if err != nil {
+		return err
+	}
+	resp, err := pb.NewPoleClient(client).Exec(context.Background(), &pb.ExecRequest{Sql: sql})
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Message)
+	return nil
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{backupCmd, restoreCmd} {
+		cmd.Flags().StringSliceVar(&backupTables, "index", nil, "indexes to snapshot or restore (default all)")
+		cmd.Flags().StringVar(&backupRepository, "repository", "", "uri of the snapshot repository")
+		cmd.Flags().StringVar(&backupAddr, "addr", "", "grpc address of a pole node (default grpc_addr of the config)")
+		_ = cmd.MarkFlagRequired("repository")
+		poleCmd.AddCommand(cmd)
+	}
+}
@@ -40,17 +40,20 @@ var (
 		RunE: func(cmd *cobra.Command, args []string) error {
 
 			meta := meta.NewMeta()
-			ctx := context.Background()
-			raft, err := poleRaft.NewRaft(ctx, raftId, raftAddress, raftDataDir, join, raftBootstrap, meta)
+			conf := conf.GetConfig()
+			// the writes in the raft log are applied from the first entry
+			// raft replays
+			poled, err := poled2.NewPoled(conf, meta)
 			if err != nil {
 				return err
 			}
-			conf := conf.GetConfig()
 
-			poled, err := poled2.NewPoled(conf, meta, raft.Raft)
+			ctx := context.Background()
+			raft, err := poleRaft.NewRaft(ctx, raftId, raftAddress, raftDataDir, join, raftBootstrap, meta)
 			if err != nil {
 				return err
 			}
+			poled.SetRaft(raft.Raft)
 
 			grpcService := server.NewNodeService(raft.Raft)
 
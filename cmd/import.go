@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pole/internal/conf"
+	"pole/internal/pb"
+	poled2 "pole/internal/poled"
+	poleRaft "pole/internal/raft"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	importIndex     string
+	importFile      string
+	importFormat    string
+	importBatchSize int
+	importAddr      string
+
+	importCmd = &cobra.Command{
+		Use:   "import",
+		Short: "Bulk import NDJSON or CSV documents into an index",
+		Long: `Bulk import NDJSON or CSV documents into an index through the grpc api,
+the documents are read from --file or stdin and the id column gives their ids`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var r io.Reader = os.Stdin
+			if importFile != "-" {
+				file, err := os.Open(importFile)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				r = file
+			}
+
+			format := importFormat
+			if format == "" {
+				format = string(poled2.BulkFormatNDJSON)
+				if strings.EqualFold(filepath.Ext(importFile), ".csv") {
+					format = string(poled2.BulkFormatCSV)
+				}
+			}
+
+			addr := importAddr
+			if addr == "" {
+				addr = conf.GetGrpcAddr()
+			}
+			client, err := poleRaft.GetClientConn(addr)
+			if err != nil {
+				return err
+			}
+			stream, err := pb.NewPoleClient(client).Bulk(context.Background())
+			if err != nil {
+				return err
+			}
+			req := &pb.BulkRequest{Index: importIndex, Format: format, BatchSize: int32(importBatchSize)}
+			if err := poled2.SendBulk(stream, req, r); err != nil {
+				return err
+			}
+			resp, err := stream.CloseAndRecv()
+			if err != nil {
+				return err
+			}
+
+			rs := poled2.NewBulkResp(resp)
+			fmt.Printf("indexed %d documents in %dms, %d failed\n", rs.Indexed, rs.Took, rs.Failed)
+			for _, failure := range rs.Failures {
+				fmt.Printf("line %d id %q: %s\n", failure.Line, failure.ID, failure.Error)
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	importCmd.Flags().StringVar(&importIndex, "index", "", "index to import into")
+	importCmd.Flags().StringVar(&importFile, "file", "-", "file to import, - reads stdin")
+	importCmd.Flags().StringVar(&importFormat, "format", "", "ndjson or csv (default from the file extension)")
+	importCmd.Flags().IntVar(&importBatchSize, "batch-size", poled2.DefaultBulkBatchSize, "documents per index batch")
+	importCmd.Flags().StringVar(&importAddr, "addr", "", "grpc address of a pole node (default grpc_addr of the config)")
+	_ = importCmd.MarkFlagRequired("index")
+
+	poleCmd.AddCommand(importCmd)
+}
@@ -1,12 +1,18 @@
 package conf
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 const (
 	defaultHttpAddr  = ":5000"
 	defaultGrpcAddr  = ":5001"
 	defaultIndexPath = "file:///tmp/pole"
 	defaultDataPath  = "./"
+	// defaultMaxStaleness bounds the age of the readers serving bounded
+	// staleness selects.
+	defaultMaxStaleness = 5 * time.Second
 )
 
 var confOnce sync.Once
@@ -19,13 +25,34 @@ type RaftConfig struct {
 	DataDir   string `mapstructure:"data_dir"`
 }
 
+type TranslogConfig struct {
+	// Durability is request to fsync the translog before each batch is
+	// applied or async to fsync it every SyncInterval.
+	Durability   string        `mapstructure:"durability"`
+	SyncInterval time.Duration `mapstructure:"sync_interval"`
+}
+
 type Config struct {
+	// IndexUri is shared by the nodes of a cluster, the shards hosted by
+	// other nodes are read from it.
 	IndexUri string     `mapstructure:"index_uri"`
 	HttpAddr string     `mapstructure:"http_addr"`
 	GrpcAddr string     `mapstructure:"grpc_addr"`
 	DataPath string     `mapstructure:"data_path"`
 	Raft     RaftConfig `mapstructure:"raft"`
 	Join     string     `mapstructure:"join"`
+	// MaxStaleness is the default bound of bounded staleness selects.
+	MaxStaleness time.Duration `mapstructure:"max_staleness"`
+	// RaftWrites appends inserts, updates and deletes to the raft log, they
+	// are acknowledged once a quorum has them and every node applies them.
+	RaftWrites bool           `mapstructure:"raft_writes"`
+	Translog   TranslogConfig `mapstructure:"translog"`
+	// RefreshInterval is how often the written indexes are made visible to
+	// selects, a negative interval only refreshes on demand.
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	// SegmentCacheSize caps the bytes of the oss and s3 segments cached
+	// under data_path, 0 disables the cache.
+	SegmentCacheSize int64 `mapstructure:"segment_cache_size"`
 }
 
 func GetConfig() *Config {
@@ -70,3 +97,15 @@ func GetDataPath() string {
 func SetJoin(join string) {
 	conf.Join = join
 }
+
+func GetMaxStaleness() time.Duration {
+	rs := conf.MaxStaleness
+	if rs <= 0 {
+		rs = defaultMaxStaleness
+	}
+	return rs
+}
+
+func GetRaftWrites() bool {
+	return conf.RaftWrites
+}
@@ -19,8 +19,12 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type PoleClient interface {
 	Exec(ctx context.Context, in *ExecRequest, opts ...grpc.CallOption) (*ExecResponse, error)
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+	QueryStream(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (Pole_QueryStreamClient, error)
+	Bulk(ctx context.Context, opts ...grpc.CallOption) (Pole_BulkClient, error)
 	Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error)
 	Unlock(ctx context.Context, in *UnlockRequest, opts ...grpc.CallOption) (*UnlockResponse, error)
+	Load(ctx context.Context, in *LoadRequest, opts ...grpc.CallOption) (*LoadResponse, error)
 }
 
 type poleClient struct {
@@ -40,6 +44,81 @@ func (c *poleClient) Exec(ctx context.Context, in *ExecRequest, opts ...grpc.Cal
 	return out, nil
 }
 
+func (c *poleClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, "/Pole/Query", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *poleClient) QueryStream(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (Pole_QueryStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Pole_ServiceDesc.Streams[0], "/Pole/QueryStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &poleQueryStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Pole_QueryStreamClient interface {
+	Recv() (*QueryStreamResponse, error)
+	grpc.ClientStream
+}
+
+type poleQueryStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *poleQueryStreamClient) Recv() (*QueryStreamResponse, error) {
+	m := new(QueryStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *poleClient) Bulk(ctx context.Context, opts ...grpc.CallOption) (Pole_BulkClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Pole_ServiceDesc.Streams[1], "/Pole/Bulk", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &poleBulkClient{stream}
+	return x, nil
+}
+
+type Pole_BulkClient interface {
+	Send(*BulkRequest) error
+	CloseAndRecv() (*BulkResponse, error)
+	grpc.ClientStream
+}
+
+type poleBulkClient struct {
+	grpc.ClientStream
+}
+
+func (x *poleBulkClient) Send(m *BulkRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *poleBulkClient) CloseAndRecv() (*BulkResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(BulkResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *poleClient) Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error) {
 	out := new(LockResponse)
 	err := c.cc.Invoke(ctx, "/Pole/Lock", in, out, opts...)
@@ -58,13 +137,26 @@ func (c *poleClient) Unlock(ctx context.Context, in *UnlockRequest, opts ...grpc
 	return out, nil
 }
 
+func (c *poleClient) Load(ctx context.Context, in *LoadRequest, opts ...grpc.CallOption) (*LoadResponse, error) {
+	out := new(LoadResponse)
+	err := c.cc.Invoke(ctx, "/Pole/Load", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PoleServer is the server API for Pole service.
 // All implementations must embed UnimplementedPoleServer
 // for forward compatibility
 type PoleServer interface {
 	Exec(context.Context, *ExecRequest) (*ExecResponse, error)
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
+	QueryStream(*QueryRequest, Pole_QueryStreamServer) error
+	Bulk(Pole_BulkServer) error
 	Lock(context.Context, *LockRequest) (*LockResponse, error)
 	Unlock(context.Context, *UnlockRequest) (*UnlockResponse, error)
+	Load(context.Context, *LoadRequest) (*LoadResponse, error)
 	mustEmbedUnimplementedPoleServer()
 }
 
@@ -75,12 +167,24 @@ type UnimplementedPoleServer struct {
 func (UnimplementedPoleServer) Exec(context.Context, *ExecRequest) (*ExecResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Exec not implemented")
 }
+func (UnimplementedPoleServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedPoleServer) QueryStream(*QueryRequest, Pole_QueryStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method QueryStream not implemented")
+}
+func (UnimplementedPoleServer) Bulk(Pole_BulkServer) error {
+	return status.Errorf(codes.Unimplemented, "method Bulk not implemented")
+}
 func (UnimplementedPoleServer) Lock(context.Context, *LockRequest) (*LockResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Lock not implemented")
 }
 func (UnimplementedPoleServer) Unlock(context.Context, *UnlockRequest) (*UnlockResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Unlock not implemented")
 }
+func (UnimplementedPoleServer) Load(context.Context, *LoadRequest) (*LoadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Load not implemented")
+}
 func (UnimplementedPoleServer) mustEmbedUnimplementedPoleServer() {}
 
 // UnsafePoleServer may be embedded to opt out of forward compatibility for this service.
@@ -112,6 +216,71 @@ func _Pole_Exec_Handler(srv interface{}, ctx context.Context, dec func(interface
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Pole_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PoleServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Pole/Query",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PoleServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pole_QueryStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PoleServer).QueryStream(m, &poleQueryStreamServer{stream})
+}
+
+type Pole_QueryStreamServer interface {
+	Send(*QueryStreamResponse) error
+	grpc.ServerStream
+}
+
+type poleQueryStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *poleQueryStreamServer) Send(m *QueryStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Pole_Bulk_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PoleServer).Bulk(&poleBulkServer{stream})
+}
+
+type Pole_BulkServer interface {
+	SendAndClose(*BulkResponse) error
+	Recv() (*BulkRequest, error)
+	grpc.ServerStream
+}
+
+type poleBulkServer struct {
+	grpc.ServerStream
+}
+
+func (x *poleBulkServer) SendAndClose(m *BulkResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *poleBulkServer) Recv() (*BulkRequest, error) {
+	m := new(BulkRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _Pole_Lock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(LockRequest)
 	if err := dec(in); err != nil {
@@ -148,6 +317,24 @@ func _Pole_Unlock_Handler(srv interface{}, ctx context.Context, dec func(interfa
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Pole_Load_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PoleServer).Load(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Pole/Load",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PoleServer).Load(ctx, req.(*LoadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Pole_ServiceDesc is the grpc.ServiceDesc for Pole service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -159,6 +346,10 @@ var Pole_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Exec",
 			Handler:    _Pole_Exec_Handler,
 		},
+		{
+			MethodName: "Query",
+			Handler:    _Pole_Query_Handler,
+		},
 		{
 			MethodName: "Lock",
 			Handler:    _Pole_Lock_Handler,
@@ -167,7 +358,22 @@ var Pole_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Unlock",
 			Handler:    _Pole_Unlock_Handler,
 		},
+		{
+			MethodName: "Load",
+			Handler:    _Pole_Load_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "QueryStream",
+			Handler:       _Pole_QueryStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Bulk",
+			Handler:       _Pole_Bulk_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/pb/pole.proto",
 }
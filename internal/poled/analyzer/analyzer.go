@@ -0,0 +1,143 @@
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/blugelabs/bluge/analysis"
+	"github.com/blugelabs/bluge/analysis/analyzer"
+	"github.com/blugelabs/bluge/analysis/lang/ar"
+	"github.com/blugelabs/bluge/analysis/lang/cjk"
+	"github.com/blugelabs/bluge/analysis/lang/ckb"
+	"github.com/blugelabs/bluge/analysis/lang/da"
+	"github.com/blugelabs/bluge/analysis/lang/de"
+	"github.com/blugelabs/bluge/analysis/lang/en"
+	"github.com/blugelabs/bluge/analysis/lang/es"
+	"github.com/blugelabs/bluge/analysis/lang/fa"
+	"github.com/blugelabs/bluge/analysis/lang/fi"
+	"github.com/blugelabs/bluge/analysis/lang/fr"
+	"github.com/blugelabs/bluge/analysis/lang/hi"
+	"github.com/blugelabs/bluge/analysis/lang/hu"
+	"github.com/blugelabs/bluge/analysis/lang/it"
+	"github.com/blugelabs/bluge/analysis/lang/nl"
+	"github.com/blugelabs/bluge/analysis/lang/no"
+	"github.com/blugelabs/bluge/analysis/lang/pt"
+	"github.com/blugelabs/bluge/analysis/lang/ro"
+	"github.com/blugelabs/bluge/analysis/lang/ru"
+	"github.com/blugelabs/bluge/analysis/lang/sv"
+	"github.com/blugelabs/bluge/analysis/lang/tr"
+	"github.com/blugelabs/bluge/analysis/token"
+	"github.com/blugelabs/bluge/analysis/tokenizer"
+)
+
+var ErrAnalyzerNotFound = errors.New("analyzer not found")
+
+const (
+	Standard   = "standard"
+	Keyword    = "keyword"
+	Simple     = "simple"
+	Whitespace = "whitespace"
+	Web        = "web"
+	CJK        = "cjk"
+	Chinese    = "chinese"
+)
+
+var constructors = map[string]func() *analysis.Analyzer{
+	Standard:   analyzer.NewStandardAnalyzer,
+	Keyword:    analyzer.NewKeywordAnalyzer,
+	Simple:     analyzer.NewSimpleAnalyzer,
+	Whitespace: newWhitespaceAnalyzer,
+	Web:        analyzer.NewWebAnalyzer,
+	CJK:        cjk.Analyzer,
+	Chinese:    newChineseAnalyzer,
+	"ar":       ar.Analyzer,
+	"ckb":      ckb.Analyzer,
+	"da":       da.Analyzer,
+	"de":       de.Analyzer,
+	"en":       en.NewAnalyzer,
+	"es":       es.Analyzer,
+	"fa":       fa.Analyzer,
+	"fi":       fi.Analyzer,
+	"fr":       fr.Analyzer,
+	"hi":       hi.Analyzer,
+	"hu":       hu.Analyzer,
+	"it":       it.Analyzer,
+	"nl":       nl.Analyzer,
+	"no":       no.Analyzer,
+	"pt":       pt.Analyzer,
+	"ro":       ro.Analyzer,
+	"ru":       ru.Analyzer,
+	"sv":       sv.Analyzer,
+	"tr":       tr.Analyzer,
+}
+
+var aliases = map[string]string{
+	"english": "en",
+	"german":  "de",
+	"french":  "fr",
+	"spanish": "es",
+	"zh":      Chinese,
+	"gse":     Chinese,
+	"jieba":   Chinese,
+}
+
+var (
+	mu        sync.RWMutex
+	analyzers = make(map[string]*analysis.Analyzer)
+)
+
+// Get returns the analyzer registered under name, building it on first use.
+// An empty name resolves to the standard analyzer.
+func Get(name string) (*analysis.Analyzer, error) {
+	name = Normalize(name)
+
+	mu.RLock()
+	rs, ok := analyzers[name]
+	mu.RUnlock()
+	if ok {
+		return rs, nil
+	}
+
+	constructor, ok := constructors[name]
+	if !ok {
+		return nil, fmt.Errorf("%w:%s", ErrAnalyzerNotFound, name)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if rs, ok := analyzers[name]; ok {
+		return rs, nil
+	}
+	rs = constructor()
+	analyzers[name] = rs
+	return rs, nil
+}
+
+// Exists reports whether name refers to a known analyzer.
+func Exists(name string) bool {
+	_, ok := constructors[Normalize(name)]
+	return ok
+}
+
+// Normalize lowercases name and resolves aliases.
+func Normalize(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return Standard
+	}
+	if rs, ok := aliases[name]; ok {
+		return rs
+	}
+	return name
+}
+
+func newWhitespaceAnalyzer() *analysis.Analyzer {
+	return &analysis.Analyzer{
+		Tokenizer: tokenizer.NewWhitespaceTokenizer(),
+		TokenFilters: []analysis.TokenFilter{
+			token.NewLowerCaseFilter(),
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package analyzer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		err   error
+	}{
+		{
+			name:  "default",
+			input: "Hello World",
+			want:  []string{"hello", "world"},
+		},
+		{
+			name:  "keyword",
+			input: "Hello World",
+			want:  []string{"Hello World"},
+		},
+		{
+			name:  "en",
+			input: "running dogs",
+			want:  []string{"run", "dog"},
+		},
+		{
+			name:  "chinese",
+			input: "中华人民共和国成立了",
+			want:  []string{"中华人民共和国", "成立", "了"},
+		},
+		{
+			name: "unknown",
+			err:  ErrAnalyzerNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := tt.name
+			if name == "default" {
+				name = ""
+			}
+			a, err := Get(name)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Get() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			tokens := a.Analyze([]byte(tt.input))
+			if len(tokens) != len(tt.want) {
+				t.Fatalf("Analyze() = %v, want %v", tokens, tt.want)
+			}
+			for i, token := range tokens {
+				if string(token.Term) != tt.want[i] {
+					t.Errorf("Analyze()[%d] = %s, want %s", i, token.Term, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package analyzer
+
+import (
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"pole/internal/util/log"
+
+	"github.com/blugelabs/bluge/analysis"
+	"github.com/blugelabs/bluge/analysis/lang/cjk"
+	"github.com/blugelabs/bluge/analysis/token"
+	"github.com/go-ego/gse"
+)
+
+var (
+	segmenterOnce sync.Once
+	segmenter     gse.Segmenter
+)
+
+func getSegmenter() *gse.Segmenter {
+	segmenterOnce.Do(func() {
+		if err := segmenter.LoadDictEmbed(); err != nil {
+			log.WithField("module", "analyzer").Error("load chinese dictionary failed: ", err)
+		}
+	})
+	return &segmenter
+}
+
+// ChineseTokenizer splits text into words using the dictionary based
+// segmenter shipped with gse, keeping byte offsets for highlighting.
+type ChineseTokenizer struct {
+	segmenter *gse.Segmenter
+}
+
+func NewChineseTokenizer() *ChineseTokenizer {
+	return &ChineseTokenizer{segmenter: getSegmenter()}
+}
+
+func (t *ChineseTokenizer) Tokenize(input []byte) analysis.TokenStream {
+	segments := t.segmenter.Segment(input)
+	rv := make(analysis.TokenStream, 0, len(segments))
+	for _, segment := range segments {
+		term := input[segment.Start():segment.End()]
+		typ, ok := tokenType(term)
+		if !ok {
+			continue
+		}
+		rv = append(rv, &analysis.Token{
+			Start:        segment.Start(),
+			End:          segment.End(),
+			Term:         term,
+			PositionIncr: 1,
+			Type:         typ,
+		})
+	}
+	return rv
+}
+
+// tokenType classifies a segment and drops whitespace and punctuation.
+func tokenType(term []byte) (analysis.TokenType, bool) {
+	r, _ := utf8.DecodeRune(term)
+	switch {
+	case unicode.Is(unicode.Han, r):
+		return analysis.Ideographic, true
+	case unicode.IsNumber(r):
+		return analysis.Numeric, true
+	case unicode.IsLetter(r):
+		return analysis.AlphaNumeric, true
+	}
+	return 0, false
+}
+
+func newChineseAnalyzer() *analysis.Analyzer {
+	return &analysis.Analyzer{
+		Tokenizer: NewChineseTokenizer(),
+		TokenFilters: []analysis.TokenFilter{
+			cjk.NewWidthFilter(),
+			token.NewLowerCaseFilter(),
+		},
+	}
+}
@@ -0,0 +1,537 @@
+package poled
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pole/internal/poled/directory"
+	"pole/internal/poled/meta"
+	sqlParser "pole/internal/poled/sql"
+	"pole/internal/util/log"
+
+	blugeIndex "github.com/blugelabs/bluge/index"
+	segment "github.com/blugelabs/bluge_segment_api"
+)
+
+const (
+	// a repository keeps the segments by crc64 under blobs/ and a manifest
+	// per snapshot under snapshots/
+	repositoryBlobs     = "blobs"
+	repositorySnapshots = "snapshots"
+	manifestKind        = ".json"
+)
+
+var (
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	ErrSnapshotCorrupt  = errors.New("snapshot corrupt")
+	ErrRestoreShards    = errors.New("snapshot shard count differs from the index")
+)
+
+// backupManifest is a point-in-time snapshot of tables, the segments of its
+// shards are shared with the other snapshots of the repository.
+type backupManifest struct {
+	Id      uint64                 `json:"id"`
+	Created time.Time              `json:"created"`
+	Tables  map[string]backupTable `json:"tables"`
+}
+
+type backupTable struct {
+	Mapping meta.Mapping  `json:"mapping"`
+	Shards  []backupShard `json:"shards"`
+}
+
+// backupShard is the last persisted bluge snapshot of a shard, an empty
+// shard has none.
+type backupShard struct {
+	Epoch    uint64                `json:"epoch"`
+	Snapshot []byte                `json:"snapshot,omitempty"`
+	Segments map[uint64]backupBlob `json:"segments,omitempty"`
+}
+
+type backupBlob struct {
+	Id   uint64 `json:"id"`
+	Size int64  `json:"size"`
+}
+
+type backupResp struct {
+	Snapshot uint64   `json:"snapshot"`
+	Tables   []string `json:"tables"`
+	// Segments counts the segments of the snapshot, Copied the ones not in
+	// the repository yet.
+	Segments int   `json:"segments"`
+	Copied   int   `json:"copied"`
+	Bytes    int64 `json:"bytes"`
+}
+
+type backupResult struct {
+	resp backupResp
+}
+
+func (r *backupResult) Error() error {
+	return nil
+}
+
+func (r *backupResult) Resp() interface{} {
+	return &r.resp
+}
+
+func (r *backupResult) Code() int {
+	return http.StatusOK
+}
+
+func (r *backupResult) String() string {
+	data, _ := json.Marshal(r.resp)
+	return string(data)
+}
+
+// repository stores snapshots in any directory backend, the fragment of its
+// uri selects a snapshot to restore.
+type repository struct {
+	blobs     blugeIndex.Directory
+	snapshots blugeIndex.Directory
+	// existing holds the blobs in the repository.
+	existing map[uint64]bool
+	snapshot uint64
+}
+
+func openRepository(uri string, lock directory.Lock) (*repository, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	rs := &repository{existing: make(map[uint64]bool)}
+	if u.Fragment != "" {
+		if rs.snapshot, err = strconv.ParseUint(u.Fragment, 10, 64); err != nil {
+			return nil, fmt.Errorf("%w:%s", ErrSnapshotNotFound, u.Fragment)
+		}
+		u.Fragment = ""
+	}
+	if rs.blobs, err = directory.NewDirectoryWithUri(u.String(), directory.WithIdx(repositoryBlobs), directory.WithLock(lock)); err != nil {
+		return nil, err
+	}
+	if rs.snapshots, err = directory.NewDirectoryWithUri(u.String(), directory.WithIdx(repositorySnapshots), directory.WithLock(lock)); err != nil {
+		return nil, err
+	}
+	for _, dir := range []blugeIndex.Directory{rs.blobs, rs.snapshots} {
+		if err := dir.Setup(false); err != nil {
+			return nil, err
+		}
+	}
+	blobs, err := rs.blobs.List(blugeIndex.ItemKindSegment)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range blobs {
+		rs.existing[id] = true
+	}
+	return rs, nil
+}
+
+// putBlob stores a segment unless the repository has it already.
+func (r *repository) putBlob(data []byte) (backupBlob, bool, error) {
+	rs := backupBlob{Id: crc64.Checksum(data, crc64.MakeTable(crc64.ECMA)), Size: int64(len(data))}
+	if r.existing[rs.Id] {
+		return rs, false, nil
+	}
+	if err := r.blobs.Persist(blugeIndex.ItemKindSegment, rs.Id, bytesWriterTo(data), nil); err != nil {
+		return rs, false, err
+	}
+	r.existing[rs.Id] = true
+	return rs, true, nil
+}
+
+func (r *repository) loadBlob(blob backupBlob) (*segment.Data, func(), error) {
+	data, closer, err := r.blobs.Load(blugeIndex.ItemKindSegment, blob.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() {
+		if closer != nil {
+			_ = closer.Close()
+		}
+	}
+	if int64(data.Len()) != blob.Size {
+		release()
+		return nil, nil, fmt.Errorf("%w:blob %x has %d bytes, want %d", ErrSnapshotCorrupt, blob.Id, data.Len(), blob.Size)
+	}
+	return data, release, nil
+}
+
+// save stores the manifest as the newest snapshot.
+func (r *repository) save(manifest *backupManifest) error {
+	ids, err := r.snapshots.List(manifestKind)
+	if err != nil {
+		return err
+	}
+	manifest.Id = 1
+	for _, id := range ids {
+		if id >= manifest.Id {
+			manifest.Id = id + 1
+		}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return r.snapshots.Persist(manifestKind, manifest.Id, bytesWriterTo(data), nil)
+}
+
+// load returns the snapshot of the uri fragment, else the newest one with
+// the tables.
+func (r *repository) load(tables []string) (*backupManifest, error) {
+	ids := []uint64{r.snapshot}
+	if r.snapshot == 0 {
+		var err error
+		if ids, err = r.snapshots.List(manifestKind); err != nil {
+			return nil, err
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	}
+	for _, id := range ids {
+		data, closer, err := r.snapshots.Load(manifestKind, id)
+		if err != nil {
+			return nil, fmt.Errorf("%w:%d", ErrSnapshotNotFound, id)
+		}
+		var buf bytes.Buffer
+		_, err = data.WriteTo(&buf)
+		if closer != nil {
+			_ = closer.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifest := &backupManifest{}
+		if err := json.Unmarshal(buf.Bytes(), manifest); err != nil {
+			return nil, fmt.Errorf("%w:%s", ErrSnapshotCorrupt, err)
+		}
+		found := true
+		for _, table := range tables {
+			if _, ok := manifest.Tables[table]; !ok {
+				found = false
+			}
+		}
+		if found {
+			return manifest, nil
+		}
+	}
+	return nil, fmt.Errorf("%w:%s", ErrSnapshotNotFound, strings.Join(tables, ","))
+}
+
+type bytesWriterTo []byte
+
+func (b bytesWriterTo) WriteTo(w io.Writer, _ chan struct{}) (int64, error) {
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+type dataWriterTo struct {
+	*segment.Data
+}
+
+func (d dataWriterTo) WriteTo(w io.Writer, _ chan struct{}) (int64, error) {
+	return d.Data.WriteTo(w)
+}
+
+// shardBackup is the directory a bluge snapshot backs up to, it keeps the
+// segments in the repository and the snapshot in the manifest.
+type shardBackup struct {
+	repo  *repository
+	shard *backupShard
+	resp  *backupResp
+}
+
+func (d *shardBackup) Persist(kind string, id uint64, w blugeIndex.WriterTo, closeCh chan struct{}) error {
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf, closeCh); err != nil {
+		return err
+	}
+	if kind == blugeIndex.ItemKindSnapshot {
+		d.shard.Epoch = id
+		d.shard.Snapshot = buf.Bytes()
+		return nil
+	}
+	blob, copied, err := d.repo.putBlob(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	d.shard.Segments[id] = blob
+	d.resp.Segments++
+	if copied {
+		d.resp.Copied++
+		d.resp.Bytes += blob.Size
+	}
+	return nil
+}
+
+func (d *shardBackup) Setup(readOnly bool) error { return nil }
+
+func (d *shardBackup) List(kind string) ([]uint64, error) { return nil, nil }
+
+func (d *shardBackup) Load(kind string, id uint64) (*segment.Data, io.Closer, error) {
+	return nil, nil, fmt.Errorf("%w:%d%s", ErrSnapshotNotFound, id, kind)
+}
+
+func (d *shardBackup) Remove(kind string, id uint64) error { return nil }
+
+func (d *shardBackup) Stats() (numItems uint64, numBytes uint64) { return 0, 0 }
+
+func (d *shardBackup) Sync() error { return nil }
+
+func (d *shardBackup) Lock() error { return nil }
+
+func (d *shardBackup) Unlock() error { return nil }
+
+func (p *Poled) shardDirectory(uri, name string) (blugeIndex.Directory, error) {
+	return directory.NewDirectoryWithUri(uri, directory.WithIdx(name), directory.WithLock(p), directory.WithCache(p.cache))
+}
+
+// backupTables lists the tables of a BACKUP or RESTORE, every index when
+// the statement names none.
+func (p *Poled) backupTables(stmt *sqlParser.SqlVistor) []string {
+	if len(stmt.BackupTables) > 0 {
+		return stmt.BackupTables
+	}
+	var rs []string
+	for idx := range p.meta.All() {
+		rs = append(rs, idx)
+	}
+	sort.Strings(rs)
+	return rs
+}
+
+// execBackup snapshots the last persisted state of every shard of the tables
+// as read from index_uri, the writes not persisted yet are left to the
+// translog.
+func (p *Poled) execBackup(stmt *sqlParser.SqlVistor) result {
+	lg := log.WithField("module", "backup").WithField("repository", stmt.Repository)
+	repo, err := openRepository(stmt.Repository, p)
+	if err != nil {
+		lg.Error(err)
+		return newGeneralResult(err)
+	}
+
+	rs := &backupResult{}
+	manifest := &backupManifest{Created: time.Now(), Tables: make(map[string]backupTable)}
+	for _, idx := range p.backupTables(stmt) {
+		mapping, exists := p.meta.Get(idx)
+		if !exists {
+			return newGeneralResult(fmt.Errorf("%w:%s", ErrIndexNotFound, idx))
+		}
+		table := backupTable{Mapping: mapping}
+		for _, name := range mapping.ShardNames(idx) {
+			shard := backupShard{Segments: make(map[uint64]backupBlob)}
+			if err := p.backupShard(name, &shardBackup{repo: repo, shard: &shard, resp: &rs.resp}); err != nil {
+				lg.Error(err)
+				return newGeneralResult(err)
+			}
+			table.Shards = append(table.Shards, shard)
+		}
+		manifest.Tables[idx] = table
+		rs.resp.Tables = append(rs.resp.Tables, idx)
+	}
+
+	if err := repo.save(manifest); err != nil {
+		lg.Error(err)
+		return newGeneralResult(err)
+	}
+	rs.resp.Snapshot = manifest.Id
+	lg.Info("snapshot ", manifest.Id, " copied ", rs.resp.Copied, " of ", rs.resp.Segments, " segments")
+	return rs
+}
+
+func (p *Poled) backupShard(name string, backup *shardBackup) error {
+	dir, err := p.shardDirectory(p.conf.IndexUri, name)
+	if err != nil {
+		return err
+	}
+	if err := dir.Setup(true); err != nil {
+		return err
+	}
+	// a shard never persisted has nothing to back up
+	if epochs, err := dir.List(blugeIndex.ItemKindSnapshot); err != nil || len(epochs) == 0 {
+		return err
+	}
+	snapshot, err := blugeIndex.OpenReader(blugeIndex.DefaultConfigWithDirectory(func() blugeIndex.Directory {
+		return dir
+	}))
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+	return snapshot.Backup(backup, nil)
+}
+
+// execRestore replaces the shards of the tables with their snapshot, a table
+// missing here is created with the mapping of the snapshot. The leader
+// restores the shards it hosts and routes the others and the copies to their
+// nodes with the snapshot id in the uri fragment.
+func (p *Poled) execRestore(stmt *sqlParser.SqlVistor, options *execOptions) result {
+	lg := log.WithField("module", "restore").WithField("repository", stmt.Repository)
+	repo, err := openRepository(stmt.Repository, p)
+	if err != nil {
+		lg.Error(err)
+		return newGeneralResult(err)
+	}
+	manifest, err := repo.load(stmt.BackupTables)
+	if err != nil {
+		lg.Error(err)
+		return newGeneralResult(err)
+	}
+
+	if len(options.shards) > 0 {
+		table := manifest.Tables[stmt.TableName]
+		if err := p.restoreShards(repo, stmt.TableName, table, options.shards, options.replica); err != nil {
+			lg.Error(err)
+			return newGeneralResult(err)
+		}
+		return newGeneralResult(nil)
+	}
+
+	tables := stmt.BackupTables
+	if len(tables) == 0 {
+		for idx := range manifest.Tables {
+			tables = append(tables, idx)
+		}
+		sort.Strings(tables)
+	}
+	for _, idx := range tables {
+		if err := p.restoreTable(repo, manifest, idx, stmt.Repository); err != nil {
+			lg.Error(err)
+			return newGeneralResult(err)
+		}
+	}
+	return &backupResult{resp: backupResp{Snapshot: manifest.Id, Tables: tables}}
+}
+
+func (p *Poled) restoreTable(repo *repository, manifest *backupManifest, idx, repository string) error {
+	table := manifest.Tables[idx]
+	fields := table.Mapping.Clone()
+	mapping, exists := p.meta.Get(idx)
+	if exists {
+		if mapping.ShardCount() != fields.ShardCount() {
+			return fmt.Errorf("%w:%s has %d shards, the snapshot %d", ErrRestoreShards, idx, mapping.ShardCount(), fields.ShardCount())
+		}
+		// the shards stay on their nodes, those of a node which left the
+		// cluster are restored on another one
+		reassigned := p.meta.ReassignUnavailable(mapping)
+		fields.Nodes, fields.ReplicaNodes = reassigned.Nodes, reassigned.ReplicaNodes
+	} else if fields.Shards > 1 || fields.Replicas > 0 {
+		nodes := p.meta.NodeIds()
+		fields.Nodes = meta.AssignShards(fields.ShardCount(), nodes)
+		fields.ReplicaNodes = meta.AssignReplicas(fields.Nodes, fields.Replicas, nodes)
+	} else {
+		fields.Nodes, fields.ReplicaNodes = nil, nil
+	}
+
+	newCmd := meta.NewAlterLogDataCmd
+	if !exists {
+		newCmd = meta.NewAddLogDataCmd
+	}
+	cmd, err := newCmd(idx, fields)
+	if err != nil {
+		return err
+	}
+	if err := p.raft.Apply(cmd, time.Second).Error(); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(repository)
+	if err != nil {
+		return err
+	}
+	u.Fragment = strconv.FormatUint(manifest.Id, 10)
+	sql := fmt.Sprintf("RESTORE TABLE `%s` FROM '%s'", idx, strings.ReplaceAll(u.String(), "'", "''"))
+	for addr, hosted := range p.remoteShards(fields) {
+		if rs := p.execByRpc(addr, newExecRequest(sql, hosted, false)); rs.Error() != nil {
+			return rs.Error()
+		}
+	}
+	var local []int
+	for shard := 0; shard < fields.ShardCount(); shard++ {
+		if _, ok := p.shardHost(fields, shard); ok {
+			local = append(local, shard)
+		}
+	}
+	if err := p.restoreShards(repo, idx, table, local, false); err != nil {
+		return err
+	}
+
+	stmt, err := sqlParser.Parse(sql)
+	if err != nil {
+		return err
+	}
+	return p.replicate(stmt, fields, allShards(fields), RefreshNone)
+}
+
+// restoreShards releases the shards, or the copies kept here, and replaces
+// their files with the snapshot.
+func (p *Poled) restoreShards(repo *repository, idx string, table backupTable, shards []int, replica bool) error {
+	uri := p.conf.IndexUri
+	if replica {
+		uri = replicaUri(p.conf.DataPath)
+	}
+	p.releaseShards(idx, table.Mapping, shards)
+	for _, shard := range shards {
+		if shard >= len(table.Shards) {
+			return fmt.Errorf("%w:%s shard %d", ErrRestoreShards, idx, shard)
+		}
+		name := table.Mapping.ShardName(idx, shard)
+		if err := p.restoreShard(repo, uri, name, table.Shards[shard]); err != nil {
+			return err
+		}
+	}
+	// a select may have opened the old files meanwhile
+	p.releaseShards(idx, table.Mapping, []int{})
+	return nil
+}
+
+func (p *Poled) restoreShard(repo *repository, uri, name string, shard backupShard) error {
+	dir, err := p.shardDirectory(uri, name)
+	if err != nil {
+		return err
+	}
+	if err := dir.Setup(false); err != nil {
+		return err
+	}
+	// the snapshots go first so a partial restore is never loaded
+	for _, kind := range []string{blugeIndex.ItemKindSnapshot, blugeIndex.ItemKindSegment} {
+		ids, err := dir.List(kind)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := dir.Remove(kind, id); err != nil {
+				return err
+			}
+		}
+	}
+	if shard.Snapshot == nil {
+		return nil
+	}
+
+	for epoch, blob := range shard.Segments {
+		data, release, err := repo.loadBlob(blob)
+		if err != nil {
+			return err
+		}
+		err = dir.Persist(blugeIndex.ItemKindSegment, epoch, dataWriterTo{data}, nil)
+		release()
+		if err != nil {
+			return err
+		}
+	}
+	if err := dir.Persist(blugeIndex.ItemKindSnapshot, shard.Epoch, bytesWriterTo(shard.Snapshot), nil); err != nil {
+		return err
+	}
+	return dir.Sync()
+}
@@ -0,0 +1,556 @@
+package poled
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"pole/internal/pb"
+	poleIndex "pole/internal/poled/index"
+	"pole/internal/poled/meta"
+	poleRaft "pole/internal/raft"
+	"pole/internal/util/log"
+
+	"github.com/blugelabs/bluge/index"
+)
+
+type BulkFormat string
+
+const (
+	BulkFormatNDJSON BulkFormat = "ndjson"
+	BulkFormatCSV    BulkFormat = "csv"
+)
+
+const (
+	DefaultBulkBatchSize = 1000
+	// maxBulkFailures bounds the failures reported back, they are all counted.
+	maxBulkFailures = 1000
+	bulkChunkSize   = 1 << 20
+)
+
+var (
+	ErrBulkFormat = errors.New("bulk format must be ndjson or csv")
+	ErrBulkHeader = errors.New("csv header is missing")
+	// ErrBulkDocument reports a NDJSON line or CSV record that can not be read.
+	ErrBulkDocument = errors.New("invalid document")
+)
+
+// BulkFailure reports a document that could not be indexed, Line is the line
+// of the NDJSON document or the record number of the CSV row.
+type BulkFailure struct {
+	Line  int64  `json:"line"`
+	ID    string `json:"_id,omitempty"`
+	Error string `json:"error"`
+}
+
+type BulkResp struct {
+	Took     int64         `json:"took"`
+	Indexed  int64         `json:"indexed"`
+	Failed   int64         `json:"failed"`
+	Failures []BulkFailure `json:"failures,omitempty"`
+}
+
+func (r *BulkResp) Error() error {
+	return nil
+}
+
+func (r *BulkResp) Resp() interface{} {
+	return r
+}
+
+func (r *BulkResp) Code() int {
+	return http.StatusOK
+}
+
+func (r *BulkResp) fail(line int64, id string, err error) {
+	r.Failed++
+	if len(r.Failures) < maxBulkFailures {
+		r.Failures = append(r.Failures, BulkFailure{Line: line, ID: id, Error: err.Error()})
+	}
+}
+
+// bulkRow is a decoded document, err is set when the row itself is invalid
+// and decoding can go on with the next one.
+type bulkRow struct {
+	line   int64
+	id     string
+	values map[string]interface{}
+	err    error
+}
+
+type bulkDecoder interface {
+	// Next returns io.EOF once all rows are read.
+	Next() (*bulkRow, error)
+}
+
+func newBulkDecoder(format BulkFormat, r io.Reader) (bulkDecoder, error) {
+	switch BulkFormat(strings.ToLower(string(format))) {
+	case BulkFormatNDJSON, "":
+		return &ndjsonDecoder{reader: bufio.NewReaderSize(r, bulkChunkSize)}, nil
+	case BulkFormatCSV:
+		reader := csv.NewReader(r)
+		reader.ReuseRecord = true
+		header, err := reader.Read()
+		if err == io.EOF {
+			return nil, ErrBulkHeader
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &csvDecoder{reader: reader, header: append([]string(nil), header...)}, nil
+	}
+	return nil, fmt.Errorf("%w:%s", ErrBulkFormat, format)
+}
+
+type ndjsonDecoder struct {
+	reader *bufio.Reader
+	line   int64
+}
+
+func (d *ndjsonDecoder) Next() (*bulkRow, error) {
+	for {
+		data, err := d.reader.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(data) == 0) {
+			return nil, err
+		}
+		d.line++
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+
+		row := &bulkRow{line: d.line}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&row.values); err != nil {
+			row.err = fmt.Errorf("%w:%s", ErrBulkDocument, err)
+			return row, nil
+		}
+		row.id = takeIdentifier(row.values)
+		return row, nil
+	}
+}
+
+type csvDecoder struct {
+	reader *csv.Reader
+	header []string
+}
+
+func (d *csvDecoder) Next() (*bulkRow, error) {
+	record, err := d.reader.Read()
+	var parseErr *csv.ParseError
+	if err != nil && !errors.As(err, &parseErr) {
+		return nil, err
+	}
+	if err != nil {
+		return &bulkRow{line: int64(parseErr.StartLine), err: fmt.Errorf("%w:%s", ErrBulkDocument, err)}, nil
+	}
+	line, _ := d.reader.FieldPos(0)
+	row := &bulkRow{line: int64(line)}
+
+	row.values = make(map[string]interface{}, len(d.header))
+	for i, name := range d.header {
+		if record[i] != "" {
+			row.values[name] = record[i]
+		}
+	}
+	row.id = takeIdentifier(row.values)
+	return row, nil
+}
+
+// takeIdentifier removes the id column from the values and returns it.
+func takeIdentifier(values map[string]interface{}) string {
+	var rs string
+	for _, name := range []string{"id", meta.IdentifierField} {
+		if v, ok := values[name]; ok {
+			if v != nil {
+				rs = fmt.Sprintf("%v", v)
+			}
+			delete(values, name)
+		}
+	}
+	return rs
+}
+
+// Bulk indexes the NDJSON or CSV documents read from r into idx, documents
+// are written batchSize at a time and the invalid ones are reported without
+// stopping the import. The documents of shards hosted by other nodes are
+// streamed to them, as are the documents of the copies of the shards hosted
+// here.
+func (p *Poled) Bulk(idx string, format BulkFormat, r io.Reader, batchSize int, opts ...ExecOption) (*BulkResp, error) {
+	options := newExecOptions(opts)
+	if len(options.shards) == 0 && !p.isLearder() {
+		return p.bulkByRpc(idx, format, r, batchSize, options.refresh)
+	}
+
+	lg := log.WithField("module", "bulk").WithField("index", idx)
+	start := time.Now()
+	mapping, exists := p.meta.Get(idx)
+	if !exists {
+		lg.Error(ErrIndexNotFound)
+		return nil, ErrIndexNotFound
+	}
+	decoder, err := newBulkDecoder(format, r)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBulkBatchSize
+	}
+	writers, readers := p.writers, p.readers
+	if options.replica {
+		writers, readers = p.replicaWriters, p.replicaReaders
+	}
+
+	rs := &BulkResp{}
+	shards := make(map[int]*bulkShard)
+	var forwarders []*bulkForwarder
+	remote := make(map[int]*bulkForwarder)
+	copies := make(map[int][]*bulkForwarder)
+	local := options.shards
+	if len(local) == 0 {
+		for addr, hosted := range p.remoteShards(mapping) {
+			forwarder := newBulkForwarder(addr, idx, batchSize, hosted, false, options.refresh)
+			forwarders = append(forwarders, forwarder)
+			for _, shard := range hosted {
+				remote[shard] = forwarder
+			}
+		}
+		for _, shard := range allShards(mapping) {
+			if _, ok := remote[shard]; !ok {
+				local = append(local, shard)
+			}
+		}
+	}
+	if !options.replica {
+		for addr, hosted := range p.replicaHosts(mapping, local) {
+			// a node never keeps a copy of a shard it hosts
+			if addr == "" {
+				continue
+			}
+			forwarder := newBulkForwarder(addr, idx, batchSize, hosted, true, options.refresh)
+			forwarders = append(forwarders, forwarder)
+			for _, shard := range hosted {
+				copies[shard] = append(copies[shard], forwarder)
+			}
+		}
+	}
+	defer func() {
+		for _, forwarder := range forwarders {
+			forwarder.abort()
+		}
+		for shard := range shards {
+			readers.MarkDirty(mapping.ShardName(idx, shard))
+		}
+	}()
+
+	for {
+		row, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row.err != nil {
+			rs.fail(row.line, row.id, row.err)
+			continue
+		}
+		doc, err := mapping.MakeDocument(row.id, row.values)
+		if err != nil {
+			rs.fail(row.line, row.id, err)
+			continue
+		}
+
+		shard := mapping.Shard(row.id)
+		if forwarder, ok := remote[shard]; ok {
+			if err := forwarder.add(row); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(options.shards) > 0 && !containsShard(options.shards, shard) {
+			rs.fail(row.line, row.id, fmt.Errorf("%w:%d", ErrShardNotHosted, shard))
+			continue
+		}
+
+		target, ok := shards[shard]
+		if !ok {
+			writer, exists := writers.Get(mapping.ShardName(idx, shard))
+			if !exists {
+				lg.Error(ErrWriterNotFound)
+				return nil, ErrWriterNotFound
+			}
+			target = &bulkShard{writer: writer, batch: index.NewBatch()}
+			shards[shard] = target
+		}
+		target.batch.Update(doc.ID(), doc)
+		target.docs = append(target.docs, translogDoc{ID: row.id, Values: row.values})
+		target.pending++
+		if target.pending >= int64(batchSize) {
+			if err := target.flush(rs, false); err != nil {
+				lg.Error(err)
+				return nil, err
+			}
+		}
+		for _, forwarder := range copies[shard] {
+			if err := forwarder.add(row); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// the leader reads the shards it routed here from the index storage, it
+	// refreshes them once the documents are persisted
+	routedHere := len(options.shards) > 0 && !options.replica && options.refresh != RefreshNone
+	for _, target := range shards {
+		if err := target.flush(rs, routedHere); err != nil {
+			lg.Error(err)
+			return nil, err
+		}
+	}
+	for _, forwarder := range forwarders {
+		if err := forwarder.close(rs); err != nil {
+			lg.Error("failed to bulk on ", forwarder.addr, ", err: ", err)
+			return nil, err
+		}
+	}
+
+	written := make([]int, 0, len(shards)+len(remote))
+	for shard := range shards {
+		written = append(written, shard)
+	}
+	for shard := range remote {
+		written = append(written, shard)
+	}
+	if err := p.refreshShards(readers, idx, mapping, written, options.refresh); err != nil {
+		lg.Error(err)
+		return nil, err
+	}
+
+	rs.Took = time.Since(start).Milliseconds()
+	lg.Info("bulk indexed: ", rs.Indexed, " failed: ", rs.Failed)
+	return rs, nil
+}
+
+// bulkShard batches the documents of a shard hosted here.
+type bulkShard struct {
+	writer  *poleIndex.Writer
+	batch   *index.Batch
+	docs    []translogDoc
+	pending int64
+}
+
+// flush writes the pending documents, once persisted when persisted is set.
+func (s *bulkShard) flush(rs *BulkResp, persisted bool) error {
+	if s.pending == 0 {
+		return nil
+	}
+	record, err := json.Marshal(&translogRecord{Docs: s.docs})
+	if err != nil {
+		return err
+	}
+	logBatch := s.writer.LogBatch
+	if persisted {
+		logBatch = s.writer.LogBatchPersisted
+	}
+	if err := logBatch(record, s.batch); err != nil {
+		return fmt.Errorf("%w:%s", ErrBatchFailed, err)
+	}
+	rs.Indexed += s.pending
+	s.batch.Reset()
+	s.docs = s.docs[:0]
+	s.pending = 0
+	return nil
+}
+
+// bulkForwarder streams the documents of the shards hosted by another node,
+// or of the copies it keeps, to it as NDJSON. lines keeps the line of each
+// forwarded document so the failures reported by the node point into the
+// original payload.
+type bulkForwarder struct {
+	addr      string
+	replica   bool
+	req       *pb.BulkRequest
+	stream    pb.Pole_BulkClient
+	cancel    context.CancelFunc
+	buf       bytes.Buffer
+	lines     []int64
+	pending   int
+	batchSize int
+}
+
+func newBulkForwarder(addr, idx string, batchSize int, shards []int, replica bool, refresh RefreshPolicy) *bulkForwarder {
+	req := &pb.BulkRequest{Index: idx, Format: string(BulkFormatNDJSON), BatchSize: int32(batchSize), Replica: replica, Refresh: string(refresh)}
+	for _, shard := range shards {
+		req.Shards = append(req.Shards, int32(shard))
+	}
+	return &bulkForwarder{addr: addr, replica: replica, req: req, batchSize: batchSize}
+}
+
+func (f *bulkForwarder) open() error {
+	client, err := poleRaft.GetClientConn(f.addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := pb.NewPoleClient(client).Bulk(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	f.stream, f.cancel = stream, cancel
+	return nil
+}
+
+func (f *bulkForwarder) add(row *bulkRow) error {
+	if f.stream == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	values := make(map[string]interface{}, len(row.values)+1)
+	for name, value := range row.values {
+		values[name] = value
+	}
+	values[meta.IdentifierField] = row.id
+	if err := json.NewEncoder(&f.buf).Encode(values); err != nil {
+		return err
+	}
+	f.lines = append(f.lines, row.line)
+	f.pending++
+	if f.pending >= f.batchSize {
+		return f.flush()
+	}
+	return nil
+}
+
+func (f *bulkForwarder) flush() error {
+	if f.pending == 0 {
+		return nil
+	}
+	req := f.req
+	if req == nil {
+		req = &pb.BulkRequest{}
+	}
+	req.Data = f.buf.Bytes()
+	if err := f.stream.Send(req); err != nil {
+		return err
+	}
+	f.req = nil
+	f.buf.Reset()
+	f.pending = 0
+	return nil
+}
+
+// close sends the remaining documents and adds the result of the node to rs.
+func (f *bulkForwarder) close(rs *BulkResp) error {
+	if f.stream == nil {
+		return nil
+	}
+	if err := f.flush(); err != nil {
+		return err
+	}
+	resp, err := f.stream.CloseAndRecv()
+	f.stream = nil
+	f.cancel()
+	if err != nil {
+		return err
+	}
+	if f.replica {
+		if resp.Failed > 0 {
+			return fmt.Errorf("%w: %d documents failed on %s", ErrReplicaFailed, resp.Failed, f.addr)
+		}
+		return nil
+	}
+	rs.Indexed += resp.Indexed
+	for _, failure := range resp.Failures {
+		line := failure.Line
+		if line > 0 && line <= int64(len(f.lines)) {
+			line = f.lines[line-1]
+		}
+		rs.fail(line, failure.Id, errors.New(failure.Error))
+	}
+	// failures beyond those reported by the node are only counted
+	rs.Failed += resp.Failed - int64(len(resp.Failures))
+	return nil
+}
+
+// abort cancels a stream left open by a failed import so the node does not
+// index a partial payload.
+func (f *bulkForwarder) abort() {
+	if f.stream != nil {
+		f.cancel()
+		f.stream = nil
+	}
+}
+
+// bulkByRpc streams the payload to the leader, only the leader writes.
+func (p *Poled) bulkByRpc(idx string, format BulkFormat, r io.Reader, batchSize int, refresh RefreshPolicy) (*BulkResp, error) {
+	lg := log.WithField("module", "bulkByRpc").WithField("leaderGrpcAddr", p.meta.Leader())
+
+	client, err := poleRaft.GetClientConn(p.meta.Leader())
+	if err != nil {
+		return nil, err
+	}
+	stream, err := pb.NewPoleClient(client).Bulk(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	req := &pb.BulkRequest{Index: idx, Format: string(format), BatchSize: int32(batchSize), Refresh: string(refresh)}
+	if err := SendBulk(stream, req, r); err != nil {
+		lg.Error("failed to send bulk, err: ", err)
+		return nil, err
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		lg.Error("failed to bulk, err: ", err)
+		return nil, err
+	}
+	return NewBulkResp(resp), nil
+}
+
+// SendBulk sends the payload read from r in chunks, req carrying the index
+// options goes with the first chunk.
+func SendBulk(stream pb.Pole_BulkClient, req *pb.BulkRequest, r io.Reader) error {
+	buf := make([]byte, bulkChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || req != nil {
+			if req == nil {
+				req = &pb.BulkRequest{}
+			}
+			req.Data = buf[:n]
+			if err := stream.Send(req); err != nil {
+				return err
+			}
+			req = nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func NewBulkResp(resp *pb.BulkResponse) *BulkResp {
+	rs := &BulkResp{
+		Took:    resp.Took,
+		Indexed: resp.Indexed,
+		Failed:  resp.Failed,
+	}
+	for _, failure := range resp.Failures {
+		rs.Failures = append(rs.Failures, BulkFailure{Line: failure.Line, ID: failure.Id, Error: failure.Error})
+	}
+	return rs
+}
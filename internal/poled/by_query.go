@@ -0,0 +1,288 @@
+package poled
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"pole/internal/poled/meta"
+	sqlParser "pole/internal/poled/sql"
+	"pole/internal/util/log"
+
+	"github.com/blugelabs/bluge"
+)
+
+const (
+	// byQueryBatchSize is the number of documents updated or deleted per
+	// batch by a write by query.
+	byQueryBatchSize = 1000
+	// maxFinishedTasks bounds the finished tasks kept for their progress.
+	maxFinishedTasks = 100
+)
+
+var (
+	ErrTaskExists    = errors.New("task is already running")
+	ErrTaskNotFound  = errors.New("task not found")
+	ErrTaskCancelled = errors.New("task cancelled")
+)
+
+// WithTask names an update or delete by query, its progress is then read
+// with Poled.Task and it is stopped with Poled.CancelTask.
+func WithTask(id string) ExecOption {
+	return func(op *execOptions) {
+		op.task = id
+	}
+}
+
+// ByQueryResp reports the progress of an update or delete by query.
+type ByQueryResp struct {
+	Took      int64  `json:"took"`
+	Task      string `json:"task,omitempty"`
+	Matched   int64  `json:"matched"`
+	Updated   int64  `json:"updated,omitempty"`
+	Deleted   int64  `json:"deleted,omitempty"`
+	Batches   int64  `json:"batches"`
+	Cancelled bool   `json:"cancelled,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type byQueryResult struct {
+	resp ByQueryResp
+	err  error
+}
+
+func (r *byQueryResult) Error() error {
+	return r.err
+}
+
+func (r *byQueryResult) Resp() interface{} {
+	rs := r.resp
+	if r.err != nil {
+		rs.Error = r.err.Error()
+	}
+	return &rs
+}
+
+func (r *byQueryResult) Code() int {
+	if r.err != nil {
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
+
+// Task is a named update or delete by query run on the leader.
+type Task struct {
+	ID       string      `json:"id"`
+	Index    string      `json:"index"`
+	Action   string      `json:"action"`
+	Started  time.Time   `json:"started"`
+	Done     bool        `json:"done"`
+	Progress ByQueryResp `json:"progress"`
+}
+
+type task struct {
+	Task
+	cancel context.CancelFunc
+}
+
+// tasks holds the running tasks and the last finished ones.
+type tasks struct {
+	sync.Mutex
+	all      map[string]*task
+	finished []string
+}
+
+func newTasks() *tasks {
+	return &tasks{all: make(map[string]*task)}
+}
+
+func (t *tasks) start(id, idx, action string, cancel context.CancelFunc) (*task, error) {
+	t.Lock()
+	defer t.Unlock()
+	if running, ok := t.all[id]; ok && !running.Done {
+		return nil, fmt.Errorf("%w:%s", ErrTaskExists, id)
+	}
+	rs := &task{Task: Task{ID: id, Index: idx, Action: action, Started: time.Now()}, cancel: cancel}
+	t.all[id] = rs
+	return rs, nil
+}
+
+func (t *tasks) update(task *task, progress ByQueryResp) {
+	if task == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	task.Progress = progress
+}
+
+func (t *tasks) finish(task *task, rs *byQueryResult) {
+	if task == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	task.Progress = *rs.Resp().(*ByQueryResp)
+	task.Done = true
+	t.finished = append(t.finished, task.ID)
+	for len(t.finished) > maxFinishedTasks {
+		if old, ok := t.all[t.finished[0]]; ok && old.Done {
+			delete(t.all, old.ID)
+		}
+		t.finished = t.finished[1:]
+	}
+}
+
+// Tasks lists the running and the last finished tasks of this node, the
+// oldest first.
+func (p *Poled) Tasks() []Task {
+	p.tasks.Lock()
+	defer p.tasks.Unlock()
+	rs := make([]Task, 0, len(p.tasks.all))
+	for _, task := range p.tasks.all {
+		rs = append(rs, task.Task)
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Started.Before(rs[j].Started) })
+	return rs
+}
+
+func (p *Poled) Task(id string) (Task, bool) {
+	p.tasks.Lock()
+	defer p.tasks.Unlock()
+	task, ok := p.tasks.all[id]
+	if !ok {
+		return Task{}, false
+	}
+	return task.Task, true
+}
+
+// CancelTask stops a running task after its current batch, the batches
+// applied are kept.
+func (p *Poled) CancelTask(id string) error {
+	p.tasks.Lock()
+	defer p.tasks.Unlock()
+	task, ok := p.tasks.all[id]
+	if !ok || task.Done {
+		return fmt.Errorf("%w:%s", ErrTaskNotFound, id)
+	}
+	task.cancel()
+	return nil
+}
+
+// execByQuery applies an update or delete to the documents matching its
+// WHERE clause. They are read from the leader's readers and written by id in
+// batches, each batch is a statement of its own so it is logged, routed and
+// replicated as any write by id.
+func (p *Poled) execByQuery(stmt *sqlParser.SqlVistor, options *execOptions) result {
+	start := time.Now()
+	idx := stmt.TableName
+	lg := log.WithField("module", fmt.Sprintf("%s_by_query", stmt.ActionType)).WithField("index", idx)
+	mapping, exists := p.meta.Get(idx)
+	if !exists {
+		lg.Error(ErrIndexNotFound)
+		return newGeneralResult(ErrIndexNotFound)
+	}
+	query, err := stmt.BuildQuery(mapping)
+	if err != nil {
+		return newGeneralResult(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var task *task
+	if options.task != "" {
+		if task, err = p.tasks.start(options.task, idx, string(stmt.ActionType), cancel); err != nil {
+			return newGeneralResult(err)
+		}
+		lg = lg.WithField("task", options.task)
+	}
+
+	rs := &byQueryResult{resp: ByQueryResp{Task: options.task}}
+	rs.err = p.writeByQuery(ctx, stmt, mapping, query, options, func(ids int) {
+		rs.resp.Batches++
+		if stmt.ActionType == sqlParser.StmtTypeDelete {
+			rs.resp.Deleted += int64(ids)
+		} else {
+			rs.resp.Updated += int64(ids)
+		}
+		rs.resp.Took = time.Since(start).Milliseconds()
+		p.tasks.update(task, rs.resp)
+		lg.Info("batch ", rs.resp.Batches, ": ", rs.resp.Updated+rs.resp.Deleted, " of ", rs.resp.Matched, " matched")
+	}, &rs.resp.Matched)
+	if errors.Is(rs.err, context.Canceled) {
+		rs.err = ErrTaskCancelled
+		rs.resp.Cancelled = true
+	}
+	if rs.err != nil {
+		lg.Error(rs.err)
+	}
+	rs.resp.Took = time.Since(start).Milliseconds()
+	p.tasks.finish(task, rs)
+	return rs
+}
+
+// writeByQuery reads the ids of the matching documents shard by shard and
+// applies the statement to them in batches, progress is called after each.
+func (p *Poled) writeByQuery(ctx context.Context, stmt *sqlParser.SqlVistor, mapping meta.Mapping, query bluge.Query, options *execOptions, progress func(int), matched *int64) error {
+	readers, err := p.shardReaders(stmt.TableName, mapping, &execOptions{consistency: ReadConsistencyLeader})
+	if err != nil {
+		return err
+	}
+	defer releaseReaders(readers)
+
+	ids := make([]string, 0, byQueryBatchSize)
+	flush := func() error {
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch, err := stmt.WithIds(ids)
+		if err != nil {
+			return err
+		}
+		if err := p.exec(batch, options).Error(); err != nil {
+			return err
+		}
+		progress(len(ids))
+		ids = ids[:0]
+		return nil
+	}
+
+	limit := int64(stmt.Limit())
+	for _, reader := range readers {
+		iter, err := reader.Search(ctx, bluge.NewAllMatches(query))
+		if err != nil {
+			return err
+		}
+		next, err := iter.Next()
+		for err == nil && next != nil && (limit == 0 || *matched < limit) {
+			var id string
+			if err := next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == meta.IdentifierField {
+					id = string(value)
+					return false
+				}
+				return true
+			}); err != nil {
+				return err
+			}
+			*matched++
+			if ids = append(ids, id); len(ids) == byQueryBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			next, err = iter.Next()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return flush()
+}
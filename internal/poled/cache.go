@@ -0,0 +1,26 @@
+package poled
+
+import (
+	"path/filepath"
+
+	"pole/internal/poled/directory"
+)
+
+const segmentCacheDir = "segment_cache"
+
+// newSegmentCache opens the cache of the remote segments under the data path,
+// it is nil when the config disables it.
+func newSegmentCache(dataPath string, maxBytes int64) (*directory.SegmentCache, error) {
+	if maxBytes <= 0 {
+		return nil, nil
+	}
+	return directory.NewSegmentCache(filepath.Join(dataPath, segmentCacheDir), maxBytes)
+}
+
+// CacheStats reports the hits and misses of the segment cache.
+func (p *Poled) CacheStats() directory.CacheStats {
+	if p.cache == nil {
+		return directory.CacheStats{}
+	}
+	return p.cache.Stats()
+}
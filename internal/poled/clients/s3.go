@@ -0,0 +1,82 @@
+package clients
+
+import (
+	"net/url"
+	"os"
+	"pole/internal/poled/errors"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	defaultS3Endpoint = "s3.amazonaws.com"
+	defaultS3Region   = "us-east-1"
+)
+
+// NewS3ClientWithUri connects to AWS S3 or a S3 compatible storage like MinIO
+// or COS, the query parameters of the uri override the S3_* and AWS_* env
+// vars. An endpoint with the http scheme is reached without tls.
+func NewS3ClientWithUri(uri string) (*minio.Client, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" {
+		return nil, errors.ErrInvalidUri
+	}
+	query := u.Query()
+	param := func(name string, envs ...string) string {
+		if str := query.Get(name); str != "" {
+			return str
+		}
+		for _, env := range envs {
+			if str := os.Getenv(env); str != "" {
+				return str
+			}
+		}
+		return ""
+	}
+
+	endpoint := param("endpoint", "S3_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = defaultS3Endpoint
+	}
+	secure := true
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		secure = endpoint[:i] != "http"
+		endpoint = strings.TrimSuffix(endpoint[i+len("://"):], "/")
+	}
+
+	region := param("region", "S3_REGION", "AWS_REGION")
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	lookup := minio.BucketLookupAuto
+	if str := param("path_style", "S3_PATH_STYLE"); str != "" {
+		pathStyle, err := strconv.ParseBool(str)
+		if err != nil {
+			return nil, errors.ErrInvalidUri
+		}
+		lookup = minio.BucketLookupDNS
+		if pathStyle {
+			lookup = minio.BucketLookupPath
+		}
+	}
+
+	creds := credentials.NewStaticV4(
+		param("access_key_id", "S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+		param("secret_access_key", "S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"),
+		param("session_token", "S3_SESSION_TOKEN", "AWS_SESSION_TOKEN"),
+	)
+
+	return minio.New(endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       secure,
+		Region:       region,
+		BucketLookup: lookup,
+	})
+}
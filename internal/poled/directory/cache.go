@@ -0,0 +1,260 @@
+package directory
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/blevesearch/mmap-go"
+	"github.com/blugelabs/bluge/index"
+	segment "github.com/blugelabs/bluge_segment_api"
+)
+
+// CacheStats reports the use of a SegmentCache.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Items     int    `json:"items"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"max_bytes"`
+}
+
+// SegmentCache keeps the files of remote directories on the local disk, the
+// least recently used ones are evicted once it holds more than maxBytes.
+type SegmentCache struct {
+	sync.Mutex
+	dir      string
+	maxBytes int64
+	size     int64
+	// lru holds the keys of the cached files, the most recently used first.
+	lru     *list.List
+	entries map[string]*list.Element
+
+	hits, misses, evictions uint64
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// NewSegmentCache opens the cache in dir, the files cached by the last run
+// are kept from the most recently modified.
+func NewSegmentCache(dir string, maxBytes int64) (*SegmentCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	rs := &SegmentCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) == ".tmp" {
+			_ = os.RemoveAll(filepath.Join(dir, file.Name()))
+			continue
+		}
+		rs.entries[file.Name()] = rs.lru.PushBack(&cacheEntry{key: file.Name(), size: file.Size()})
+		rs.size += file.Size()
+	}
+	rs.Lock()
+	rs.evict()
+	rs.Unlock()
+	return rs, nil
+}
+
+func (c *SegmentCache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// load maps the cached file of key.
+func (c *SegmentCache) load(key string) (*segment.Data, io.Closer, bool) {
+	c.Lock()
+	elem, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.Unlock()
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, nil, false
+	}
+
+	// an evicted file stays readable while it is mapped
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		c.remove(key)
+		atomic.AddUint64(&c.misses, 1)
+		return nil, nil, false
+	}
+	if elem.Value.(*cacheEntry).size == 0 {
+		_ = f.Close()
+		atomic.AddUint64(&c.hits, 1)
+		return segment.NewDataBytes(nil), nil, true
+	}
+	mm, err := mmap.Map(f, mmap.RDONLY, 0)
+	if err != nil {
+		_ = f.Close()
+		atomic.AddUint64(&c.misses, 1)
+		return nil, nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	return segment.NewDataBytes(mm), closerFunc(func() error {
+		err := mm.Unmap()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}), true
+}
+
+// put caches data under key, data larger than the cache is not kept.
+func (c *SegmentCache) put(key string, data []byte) error {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return nil
+	}
+	tmp := c.path(key) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path(key)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.size -= elem.Value.(*cacheEntry).size
+		c.lru.Remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: size})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+func (c *SegmentCache) evict() {
+	for c.size > c.maxBytes {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+		entry := elem.Value.(*cacheEntry)
+		c.lru.Remove(elem)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+		_ = os.Remove(c.path(entry.key))
+		atomic.AddUint64(&c.evictions, 1)
+	}
+}
+
+func (c *SegmentCache) remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.size -= elem.Value.(*cacheEntry).size
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+	_ = os.Remove(c.path(key))
+}
+
+func (c *SegmentCache) Stats() CacheStats {
+	c.Lock()
+	defer c.Unlock()
+	return CacheStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Items:     len(c.entries),
+		Bytes:     c.size,
+		MaxBytes:  c.maxBytes,
+	}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+type bytesWriterTo []byte
+
+func (b bytesWriterTo) WriteTo(w io.Writer, _ chan struct{}) (int64, error) {
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// cachedDirectory serves the files of a remote directory from a SegmentCache,
+// they never change once persisted so they are keyed by kind and epoch.
+type cachedDirectory struct {
+	index.Directory
+	cache *SegmentCache
+	owner string
+}
+
+// withSegmentCache puts the cache of the args in front of a remote directory.
+func withSegmentCache(d index.Directory, args *IndexConfigArgs) index.Directory {
+	if args.Cache == nil {
+		return d
+	}
+	owner := sha1.Sum([]byte(args.Uri + "#" + args.Idx))
+	return &cachedDirectory{Directory: d, cache: args.Cache, owner: hex.EncodeToString(owner[:])}
+}
+
+func (d *cachedDirectory) key(kind string, id uint64) string {
+	return fmt.Sprintf("%s_%012x%s", d.owner, id, kind)
+}
+
+func (d *cachedDirectory) Load(kind string, id uint64) (*segment.Data, io.Closer, error) {
+	key := d.key(kind, id)
+	if data, closer, ok := d.cache.load(key); ok {
+		return data, closer, nil
+	}
+	data, closer, err := d.Directory.Load(kind, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := data.WriteTo(&buf); err == nil {
+		_ = d.cache.put(key, buf.Bytes())
+	}
+	return data, closer, nil
+}
+
+// Persist caches the file too, the writer loads it right after.
+func (d *cachedDirectory) Persist(kind string, id uint64, w index.WriterTo, closeCh chan struct{}) error {
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf, closeCh); err != nil {
+		return err
+	}
+	if err := d.Directory.Persist(kind, id, bytesWriterTo(buf.Bytes()), closeCh); err != nil {
+		return err
+	}
+	_ = d.cache.put(d.key(kind, id), buf.Bytes())
+	return nil
+}
+
+func (d *cachedDirectory) Remove(kind string, id uint64) error {
+	d.cache.remove(d.key(kind, id))
+	return d.Directory.Remove(kind, id)
+}
@@ -5,8 +5,10 @@ import (
 	"net/url"
 	"pole/internal/poled/errors"
 	"pole/internal/util/log"
+	"sync"
 
 	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/index"
 )
 
 type uint64Slice []uint64
@@ -21,6 +23,8 @@ const (
 	SchemeTypeUnknown SchemeType = iota
 	SchemeTypeFile
 	SchemeTypeOss
+	SchemeTypeS3
+	SchemeTypeMem
 )
 
 var (
@@ -28,13 +32,35 @@ var (
 		SchemeTypeUnknown: "unknown",
 		SchemeTypeFile:    "file",
 		SchemeTypeOss:     "oss",
+		SchemeTypeS3:      "s3",
+		SchemeTypeMem:     "mem",
 	}
 	SchemeType_value = map[string]SchemeType{
 		"file": SchemeTypeFile,
 		"oss":  SchemeTypeOss,
+		"s3":   SchemeTypeS3,
+		"mem":  SchemeTypeMem,
 	}
 )
 
+// Factory opens the directory of the index of the args, the uri of the args
+// has the scheme the factory is registered for. It returns nil when the uri
+// is invalid.
+type Factory func(args *IndexConfigArgs) index.Directory
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes the directories of factory available to the uris with the
+// scheme, registering a scheme again replaces its factory.
+func Register(scheme string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[scheme] = factory
+}
+
 type Lock interface {
 	Lock(index string) error
 	Unlock(index string) error
@@ -45,6 +71,8 @@ type IndexConfigArgs struct {
 	Uri    string
 	Lock   Lock
 	Logger *log.ZapLogger
+	// Cache keeps the files of the oss and s3 directories on the local disk.
+	Cache *SegmentCache
 }
 
 type option func(op *IndexConfigArgs)
@@ -61,11 +89,42 @@ func WithIdx(idx string) option {
 	}
 }
 
+// WithCache puts the cache in front of the remote directories, a nil cache
+// is ignored.
+func WithCache(cache *SegmentCache) option {
+	return func(op *IndexConfigArgs) {
+		op.Cache = cache
+	}
+}
+
 func NewIndexConfigWithUri(uri string, options ...option) (bluge.Config, error) {
-	var rs bluge.Config
+	args, factory, err := newIndexConfigArgs(uri, options)
+	if err != nil {
+		return bluge.Config{}, err
+	}
+	return bluge.DefaultConfigWithDirectory(func() index.Directory {
+		return factory(args)
+	}), nil
+}
+
+// NewDirectoryWithUri opens the directory of an index by itself, to copy its
+// files without a bluge reader or writer.
+func NewDirectoryWithUri(uri string, options ...option) (index.Directory, error) {
+	args, factory, err := newIndexConfigArgs(uri, options)
+	if err != nil {
+		return nil, err
+	}
+	rs := factory(args)
+	if rs == nil {
+		return nil, fmt.Errorf("%w:%s", errors.ErrInvalidUri, uri)
+	}
+	return rs, nil
+}
+
+func newIndexConfigArgs(uri string, options []option) (*IndexConfigArgs, Factory, error) {
 	url, err := url.Parse(uri)
 	if err != nil {
-		return rs, fmt.Errorf("%w:%s", errors.ErrInvalidUri, err.Error())
+		return nil, nil, fmt.Errorf("%w:%s", errors.ErrInvalidUri, err.Error())
 	}
 
 	args := &IndexConfigArgs{
@@ -77,11 +136,11 @@ func NewIndexConfigWithUri(uri string, options ...option) (bluge.Config, error)
 		op(args)
 	}
 
-	switch SchemeType_value[url.Scheme] {
-	case SchemeTypeFile:
-		return FileIndexConfig(args), nil
-	case SchemeTypeOss:
-		return OssIndexConfig(args), nil
+	factoriesMu.RLock()
+	factory, ok := factories[url.Scheme]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("%w:%s", errors.ErrUnSupportedSchemeType, url.Scheme)
 	}
-	return rs, errors.ErrUnSupportedSchemeType
+	return args, factory, nil
 }
@@ -2,6 +2,7 @@ package directory
 
 import (
 	"net/url"
+	"path"
 	"pole/internal/poled/errors"
 	"pole/internal/util/log"
 
@@ -9,13 +10,21 @@ import (
 	"github.com/blugelabs/bluge/index"
 )
 
+func init() {
+	Register(SchemeType_name[SchemeTypeFile], func(args *IndexConfigArgs) index.Directory {
+		return NewFileDirectoryWithUri(args.Uri, args.Idx, args.Logger)
+	})
+}
+
 func FileIndexConfig(opts *IndexConfigArgs) bluge.Config {
 	return bluge.DefaultConfigWithDirectory(func() index.Directory {
-		return NewFileDirectoryWithUri(opts.Uri, opts.Logger)
+		return NewFileDirectoryWithUri(opts.Uri, opts.Idx, opts.Logger)
 	})
 }
 
-func NewFileDirectoryWithUri(uri string, logger *log.ZapLogger) index.Directory {
+// NewFileDirectoryWithUri opens the directory of idx under the path of the
+// uri, every index and shard gets its own directory like OssDirectory does.
+func NewFileDirectoryWithUri(uri, idx string, logger *log.ZapLogger) index.Directory {
 	logger = logger.WithField("uri", uri)
 	u, err := url.Parse(uri)
 	if err != nil {
@@ -27,5 +36,5 @@ func NewFileDirectoryWithUri(uri string, logger *log.ZapLogger) index.Directory
 		logger.Error(err.Error())
 		return nil
 	}
-	return index.NewFileSystemDirectory(u.Path)
+	return index.NewFileSystemDirectory(path.Join(u.Path, idx))
 }
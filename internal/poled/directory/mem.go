@@ -0,0 +1,151 @@
+package directory
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"pole/internal/poled/errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/xid"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/index"
+	segment "github.com/blugelabs/bluge_segment_api"
+)
+
+func init() {
+	Register(SchemeType_name[SchemeTypeMem], newMemDirectory)
+}
+
+// memStores holds the files of the mem:// directories by path, the writers
+// and readers of a path share them for the life of the process.
+var memStores sync.Map
+
+type memStore struct {
+	sync.RWMutex
+	files map[string][]byte
+}
+
+func MemIndexConfig(args *IndexConfigArgs) bluge.Config {
+	return bluge.DefaultConfigWithDirectory(func() index.Directory {
+		return newMemDirectory(args)
+	})
+}
+
+// newMemDirectory keeps the interface nil when the uri is invalid.
+func newMemDirectory(args *IndexConfigArgs) index.Directory {
+	rs := NewMemDirectoryWithUri(args)
+	if rs == nil {
+		return nil
+	}
+	return rs
+}
+
+// MemDirectory keeps the segments of an index in memory, it makes tests
+// hermetic.
+type MemDirectory struct {
+	store   *memStore
+	idx     string
+	lock    Lock
+	lockUri string
+}
+
+func NewMemDirectoryWithUri(opts *IndexConfigArgs) *MemDirectory {
+	u, err := url.Parse(opts.Uri)
+	if err != nil {
+		opts.Logger.Error(err.Error())
+		return nil
+	}
+	if u.Scheme != SchemeType_name[SchemeTypeMem] {
+		opts.Logger.Error(errors.ErrInvalidUri.Error())
+		return nil
+	}
+	name := path.Join(u.Host, u.Path, opts.Idx)
+	store, _ := memStores.LoadOrStore(name, &memStore{files: make(map[string][]byte)})
+	return &MemDirectory{
+		store:   store.(*memStore),
+		idx:     name,
+		lock:    opts.Lock,
+		lockUri: xid.New().String(),
+	}
+}
+
+func (d *MemDirectory) fileName(kind string, id uint64) string {
+	return fmt.Sprintf("%012x", id) + kind
+}
+
+func (d *MemDirectory) Setup(readOnly bool) error {
+	return nil
+}
+
+func (d *MemDirectory) List(kind string) ([]uint64, error) {
+	d.store.RLock()
+	defer d.store.RUnlock()
+	var rv uint64Slice
+	for name := range d.store.files {
+		if !strings.HasSuffix(name, kind) {
+			continue
+		}
+		var epoch uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, kind), "%x", &epoch); err != nil {
+			return nil, err
+		}
+		rv = append(rv, epoch)
+	}
+	sort.Sort(sort.Reverse(rv))
+	return rv, nil
+}
+
+func (d *MemDirectory) Load(kind string, id uint64) (*segment.Data, io.Closer, error) {
+	d.store.RLock()
+	defer d.store.RUnlock()
+	data, ok := d.store.files[d.fileName(kind, id)]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: %s not found", d.idx, d.fileName(kind, id))
+	}
+	return segment.NewDataBytes(data), nil, nil
+}
+
+func (d *MemDirectory) Persist(kind string, id uint64, w index.WriterTo, closeCh chan struct{}) error {
+	var buf strings.Builder
+	if _, err := w.WriteTo(&buf, closeCh); err != nil {
+		return err
+	}
+	d.store.Lock()
+	defer d.store.Unlock()
+	d.store.files[d.fileName(kind, id)] = []byte(buf.String())
+	return nil
+}
+
+func (d *MemDirectory) Remove(kind string, id uint64) error {
+	d.store.Lock()
+	defer d.store.Unlock()
+	delete(d.store.files, d.fileName(kind, id))
+	return nil
+}
+
+func (d *MemDirectory) Stats() (numItems uint64, numBytes uint64) {
+	d.store.RLock()
+	defer d.store.RUnlock()
+	for _, data := range d.store.files {
+		numItems++
+		numBytes += uint64(len(data))
+	}
+	return numItems, numBytes
+}
+
+func (d *MemDirectory) Sync() error {
+	return nil
+}
+
+func (d *MemDirectory) Lock() error {
+	return d.lock.Lock(d.lockUri)
+}
+
+func (d *MemDirectory) Unlock() error {
+	return d.lock.Unlock(d.lockUri)
+}
@@ -1,10 +1,10 @@
 package directory
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"fmt"
+	"hash/crc64"
 	"io"
 	"io/ioutil"
 	"net/url"
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"pole/internal/poled/errors"
 	"pole/internal/util/log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -27,12 +28,33 @@ import (
 	"go.uber.org/zap"
 )
 
+func init() {
+	Register(SchemeType_name[SchemeTypeOss], newOssDirectory)
+}
+
 func OssIndexConfig(args *IndexConfigArgs) bluge.Config {
 	return bluge.DefaultConfigWithDirectory(func() index.Directory {
-		return NewOssDirectoryWithUri(args)
+		return newOssDirectory(args)
 	})
 }
 
+// newOssDirectory keeps the interface nil when the uri is invalid.
+func newOssDirectory(args *IndexConfigArgs) index.Directory {
+	rs := NewOssDirectoryWithUri(args)
+	if rs == nil {
+		return nil
+	}
+	return withSegmentCache(rs, args)
+}
+
+const (
+	// ossPartSize is the size of the parts of a multipart upload, it bounds
+	// the memory held by Persist.
+	ossPartSize     = 8 << 20
+	ossRetries      = 3
+	ossRetryBackoff = 200 * time.Millisecond
+)
+
 type OssDirectory struct {
 	bucket         string
 	bucketCli      *oss.Bucket
@@ -134,6 +156,8 @@ func (d *OssDirectory) List(kind string) ([]uint64, error) {
 		}
 		rv = append(rv, epoch)
 	}
+	// bluge loads the first snapshot listed, the newest
+	sort.Sort(sort.Reverse(rv))
 	return rv, nil
 }
 
@@ -165,28 +189,118 @@ func (d *OssDirectory) Load(kind string, id uint64) (*segment.Data, io.Closer, e
 	return segment.NewDataBytes(data), nil, nil
 }
 
+// Persist streams the segment in parts of ossPartSize, each part is retried
+// on failure. A failed upload is aborted and the object removed, an uploaded
+// object whose crc64 differs from the segment is removed too.
 func (d *OssDirectory) Persist(kind string, id uint64, w index.WriterTo, closeCh chan struct{}) error {
-	var buf bytes.Buffer
-	size, err := w.WriteTo(&buf, closeCh)
+	path := d.fullFilePath(kind, id)
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := w.WriteTo(writer, closeCh)
+		_ = writer.CloseWithError(err)
+	}()
+	defer reader.Close()
+
+	hash := crc64.New(crc64.MakeTable(crc64.ECMA))
+	size, err := d.upload(path, io.TeeReader(reader, hash))
 	if err != nil {
-		d.logger.Error(err.Error())
+		d.logger.Error(err.Error(), zap.String("bucket", d.bucket), zap.String("path", path), zap.Int64("size", size))
+		d.removeFailed(path)
 		return err
 	}
+	if err := d.verify(path, size, hash.Sum64()); err != nil {
+		d.logger.Error(err.Error(), zap.String("bucket", d.bucket), zap.String("path", path), zap.Int64("size", size))
+		d.removeFailed(path)
+		return err
+	}
+	return nil
+}
 
-	reader := bufio.NewReader(&buf)
+// upload puts a segment smaller than a part in one request and the others
+// through a multipart upload.
+func (d *OssDirectory) upload(path string, r io.Reader) (int64, error) {
+	part := make([]byte, ossPartSize)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return int64(n), d.retry(func() error {
+			return d.bucketCli.PutObject(path, bytes.NewReader(part[:n]), oss.ContentType("application/octet-stream"))
+		})
+	}
+	if err != nil {
+		return 0, err
+	}
 
-	path := d.fullFilePath(kind, id)
-	err = d.bucketCli.PutObject(path, reader, oss.ContentType("application/octet-stream"))
+	imur, err := d.bucketCli.InitiateMultipartUpload(path, oss.ContentType("application/octet-stream"))
 	if err != nil {
-		d.logger.Error(err.Error(), zap.String("bucket", d.bucket), zap.String("path", path), zap.Int64("size", size))
+		return 0, err
+	}
+	var parts []oss.UploadPart
+	size := int64(0)
+	for number := 1; n > 0; number++ {
+		var uploaded oss.UploadPart
+		err := d.retry(func() (err error) {
+			uploaded, err = d.bucketCli.UploadPart(imur, bytes.NewReader(part[:n]), int64(n), number)
+			return err
+		})
+		if err != nil {
+			_ = d.bucketCli.AbortMultipartUpload(imur)
+			return size, err
+		}
+		parts = append(parts, uploaded)
+		size += int64(n)
 
-		// TODO: Remove the failed file.
+		n, err = io.ReadFull(r, part)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			_ = d.bucketCli.AbortMultipartUpload(imur)
+			return size, err
+		}
+	}
+	err = d.retry(func() error {
+		_, err := d.bucketCli.CompleteMultipartUpload(imur, parts)
 		return err
+	})
+	if err != nil {
+		_ = d.bucketCli.AbortMultipartUpload(imur)
+	}
+	return size, err
+}
+
+func (d *OssDirectory) retry(fn func() error) error {
+	var err error
+	for attempt := 0; attempt < ossRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * ossRetryBackoff)
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+		d.logger.Warn("attempt ", attempt+1, ": ", err)
 	}
+	return err
+}
 
+// verify compares the size and crc64 oss reports for the object with the ones
+// of the segment.
+func (d *OssDirectory) verify(path string, size int64, crc uint64) error {
+	header, err := d.bucketCli.GetObjectDetailedMeta(path)
+	if err != nil {
+		return err
+	}
+	if length := header.Get(oss.HTTPHeaderContentLength); length != "" && length != strconv.FormatInt(size, 10) {
+		return fmt.Errorf("%w:size %s, want %d", errors.ErrChecksumMismatch, length, size)
+	}
+	if str := header.Get(oss.HTTPHeaderOssCRC64); str != "" && str != strconv.FormatUint(crc, 10) {
+		return fmt.Errorf("%w:crc64 %s, want %d", errors.ErrChecksumMismatch, str, crc)
+	}
 	return nil
 }
 
+func (d *OssDirectory) removeFailed(path string) {
+	if err := d.bucketCli.DeleteObject(path); err != nil {
+		d.logger.Error(err.Error(), zap.String("bucket", d.bucket), zap.String("path", path))
+	}
+}
+
 func (d *OssDirectory) Remove(kind string, id uint64) error {
 	path := d.fullFilePath(kind, id)
 	if err := d.bucketCli.DeleteObject(path); err != nil {
@@ -0,0 +1,236 @@
+package directory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"pole/internal/poled/errors"
+	"pole/internal/util/log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"pole/internal/poled/clients"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/index"
+	segment "github.com/blugelabs/bluge_segment_api"
+	"github.com/minio/minio-go/v7"
+	"go.uber.org/zap"
+)
+
+func init() {
+	Register(SchemeType_name[SchemeTypeS3], newS3Directory)
+}
+
+func S3IndexConfig(args *IndexConfigArgs) bluge.Config {
+	return bluge.DefaultConfigWithDirectory(func() index.Directory {
+		return newS3Directory(args)
+	})
+}
+
+// newS3Directory keeps the interface nil when the uri is invalid.
+func newS3Directory(args *IndexConfigArgs) index.Directory {
+	rs := NewS3DirectoryWithUri(args)
+	if rs == nil {
+		return nil
+	}
+	return withSegmentCache(rs, args)
+}
+
+// S3Directory keeps the segments of an index in a bucket of AWS S3 or of a S3
+// compatible storage like MinIO or COS.
+type S3Directory struct {
+	bucket         string
+	path           string
+	client         *minio.Client
+	ctx            context.Context
+	requestTimeout time.Duration
+	lock           Lock
+	lockUri        string
+	logger         *log.ZapLogger
+}
+
+func NewS3DirectoryWithUri(opts *IndexConfigArgs) *S3Directory {
+	directoryLogger := opts.Logger.WithField("schemeType", "s3")
+
+	client, err := clients.NewS3ClientWithUri(opts.Uri)
+	if err != nil {
+		opts.Logger.Error(err.Error(), zap.String("uri", opts.Uri))
+		return nil
+	}
+
+	u, err := url.Parse(opts.Uri)
+	if err != nil {
+		opts.Logger.Error(err.Error(), zap.String("uri", opts.Uri))
+		return nil
+	}
+	if u.Scheme != SchemeType_name[SchemeTypeS3] {
+		err := errors.ErrInvalidUri
+		opts.Logger.Error(err.Error(), zap.String("uri", opts.Uri))
+		return nil
+	}
+
+	return &S3Directory{
+		client:         client,
+		bucket:         u.Host,
+		path:           strings.Trim(path.Join(u.Path, opts.Idx), "/"),
+		ctx:            context.Background(),
+		requestTimeout: 30 * time.Second,
+		lock:           opts.Lock,
+		lockUri:        xid.New().String(),
+		logger:         directoryLogger,
+	}
+}
+
+func (d *S3Directory) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(d.ctx, d.requestTimeout)
+}
+
+func (d *S3Directory) Setup(readOnly bool) error {
+	ctx, cancel := d.context()
+	defer cancel()
+	exists, err := d.client.BucketExists(ctx, d.bucket)
+	if err != nil {
+		d.logger.Error(err.Error(), zap.String("bucket", d.bucket))
+		return err
+	}
+	if exists || readOnly {
+		return nil
+	}
+	if err := d.client.MakeBucket(ctx, d.bucket, minio.MakeBucketOptions{}); err != nil {
+		d.logger.Error(err.Error(), zap.String("bucket", d.bucket))
+		return err
+	}
+	return nil
+}
+
+// prefix ends with a slash so an index does not list the shards of another
+// index sharing its name as prefix.
+func (d *S3Directory) prefix() string {
+	if d.path == "" {
+		return ""
+	}
+	return d.path + "/"
+}
+
+func (d *S3Directory) List(kind string) ([]uint64, error) {
+	ctx, cancel := d.context()
+	defer cancel()
+	var rv uint64Slice
+	for object := range d.client.ListObjects(ctx, d.bucket, minio.ListObjectsOptions{Prefix: d.prefix()}) {
+		if object.Err != nil {
+			d.logger.Error(object.Err.Error(), zap.String("bucket", d.bucket))
+			return nil, object.Err
+		}
+		if filepath.Ext(object.Key) != kind {
+			continue
+		}
+
+		base := path.Base(object.Key)
+		base = base[:len(base)-len(kind)]
+
+		epoch, err := strconv.ParseUint(base, 16, 64)
+		if err != nil {
+			d.logger.Error(err.Error(), zap.String("base", base))
+			return nil, err
+		}
+		rv = append(rv, epoch)
+	}
+	// bluge loads the first snapshot listed, the newest
+	sort.Sort(sort.Reverse(rv))
+	return rv, nil
+}
+
+func (d *S3Directory) fileName(kind string, id uint64) string {
+	return fmt.Sprintf("%012x", id) + kind
+}
+
+func (d *S3Directory) fullFilePath(kind string, id uint64) string {
+	return d.prefix() + d.fileName(kind, id)
+}
+
+func (d *S3Directory) Load(kind string, id uint64) (*segment.Data, io.Closer, error) {
+	path := d.fullFilePath(kind, id)
+	ctx, cancel := d.context()
+	defer cancel()
+	object, err := d.client.GetObject(ctx, d.bucket, path, minio.GetObjectOptions{})
+	if err != nil {
+		d.logger.Error(err.Error(), zap.String("bucket", d.bucket), zap.String("path", path))
+		return nil, nil, err
+	}
+	defer object.Close()
+
+	data, err := ioutil.ReadAll(object)
+	if err != nil {
+		d.logger.Error(err.Error(), zap.String("bucket", d.bucket), zap.String("path", path))
+		return nil, nil, err
+	}
+
+	return segment.NewDataBytes(data), nil, nil
+}
+
+func (d *S3Directory) Persist(kind string, id uint64, w index.WriterTo, closeCh chan struct{}) error {
+	var buf bytes.Buffer
+	size, err := w.WriteTo(&buf, closeCh)
+	if err != nil {
+		d.logger.Error(err.Error())
+		return err
+	}
+
+	path := d.fullFilePath(kind, id)
+	ctx, cancel := d.context()
+	defer cancel()
+	_, err = d.client.PutObject(ctx, d.bucket, path, &buf, size, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		d.logger.Error(err.Error(), zap.String("bucket", d.bucket), zap.String("path", path), zap.Int64("size", size))
+		return err
+	}
+
+	return nil
+}
+
+func (d *S3Directory) Remove(kind string, id uint64) error {
+	path := d.fullFilePath(kind, id)
+	ctx, cancel := d.context()
+	defer cancel()
+	if err := d.client.RemoveObject(ctx, d.bucket, path, minio.RemoveObjectOptions{}); err != nil {
+		d.logger.Error(err.Error(), zap.String("bucket", d.bucket), zap.String("path", path))
+		return err
+	}
+	return nil
+}
+
+func (d *S3Directory) Stats() (numItems uint64, numBytes uint64) {
+	ctx, cancel := d.context()
+	defer cancel()
+	for object := range d.client.ListObjects(ctx, d.bucket, minio.ListObjectsOptions{Prefix: d.prefix()}) {
+		if object.Err != nil {
+			d.logger.Error(object.Err.Error(), zap.String("bucket", d.bucket))
+			break
+		}
+		numItems++
+		numBytes += uint64(object.Size)
+	}
+	return numItems, numBytes
+}
+
+func (d *S3Directory) Sync() error {
+	return nil
+}
+
+func (d *S3Directory) Lock() error {
+	return d.lock.Lock(d.lockUri)
+}
+
+func (d *S3Directory) Unlock() error {
+	return d.lock.Unlock(d.lockUri)
+}
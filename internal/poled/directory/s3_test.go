@@ -0,0 +1,242 @@
+package directory
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/blugelabs/bluge"
+)
+
+type nopLock struct{}
+
+func (nopLock) Lock(string) error   { return nil }
+func (nopLock) Unlock(string) error { return nil }
+
+// fakeS3 serves the path style requests S3Directory sends, enough to run a
+// bluge index without MinIO.
+type fakeS3 struct {
+	sync.Mutex
+	buckets map[string]map[string][]byte
+}
+
+type fakeS3Object struct {
+	Key  string `xml:"Key"`
+	Size int    `xml:"Size"`
+	ETag string `xml:"ETag"`
+}
+
+type fakeS3List struct {
+	XMLName     xml.Name       `xml:"ListBucketResult"`
+	Name        string         `xml:"Name"`
+	Prefix      string         `xml:"Prefix"`
+	KeyCount    int            `xml:"KeyCount"`
+	IsTruncated bool           `xml:"IsTruncated"`
+	Contents    []fakeS3Object `xml:"Contents"`
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	objects, exists := s.buckets[bucket]
+
+	switch {
+	case key == "" && r.Method == http.MethodPut:
+		s.buckets[bucket] = make(map[string][]byte)
+	case !exists:
+		s.notFound(w, "NoSuchBucket")
+	case key == "" && r.Method == http.MethodGet:
+		prefix := r.URL.Query().Get("prefix")
+		rs := fakeS3List{Name: bucket, Prefix: prefix}
+		for name, data := range objects {
+			if strings.HasPrefix(name, prefix) {
+				rs.Contents = append(rs.Contents, fakeS3Object{Key: name, Size: len(data), ETag: `"etag"`})
+			}
+		}
+		sort.Slice(rs.Contents, func(i, j int) bool { return rs.Contents[i].Key < rs.Contents[j].Key })
+		rs.KeyCount = len(rs.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(rs)
+	case key == "":
+	case r.Method == http.MethodPut:
+		data, err := readS3Body(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		objects[key] = data
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		data, ok := objects[key]
+		if !ok {
+			s.notFound(w, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(data)
+		}
+	}
+}
+
+func (s *fakeS3) notFound(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = io.WriteString(w, "<Error><Code>"+code+"</Code></Error>")
+}
+
+// readS3Body reads the body of a put, the client streams it in signed
+// chunks over plain http.
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return ioutil.ReadAll(r.Body)
+	}
+	var rs []byte
+	reader := bufio.NewReader(r.Body)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(header), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return rs, nil
+		}
+		rs = append(rs, chunk[:size]...)
+	}
+}
+
+// s3TestUri returns the uri of POLE_TEST_S3_URI to run against a real
+// storage like MinIO, or of a fake S3 server.
+func s3TestUri(t *testing.T) string {
+	if uri := os.Getenv("POLE_TEST_S3_URI"); uri != "" {
+		return uri
+	}
+	server := httptest.NewServer(&fakeS3{buckets: make(map[string]map[string][]byte)})
+	t.Cleanup(server.Close)
+	return "s3://pole/indexes?endpoint=" + server.URL + "&path_style=true&access_key_id=test&secret_access_key=test"
+}
+
+func TestS3Directory(t *testing.T) {
+	uri := s3TestUri(t)
+	config := func(idx string) bluge.Config {
+		rs, err := NewIndexConfigWithUri(uri, WithLock(nopLock{}), WithIdx(idx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+
+	for _, idx := range []string{"test", "test/1"} {
+		writer, err := bluge.OpenWriter(config(idx))
+		if err != nil {
+			t.Fatalf("OpenWriter(%s) err = %v", idx, err)
+		}
+		batch := bluge.NewBatch()
+		for _, id := range []string{"1", "2", "3"} {
+			batch.Update(bluge.Identifier(id), bluge.NewDocument(id).AddField(bluge.NewTextField("name", "hello "+idx)))
+		}
+		if err := writer.Batch(batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader, err := bluge.OpenReader(config("test"))
+	if err != nil {
+		t.Fatalf("OpenReader() err = %v", err)
+	}
+	defer reader.Close()
+	count, err := reader.Count()
+	if err != nil || count != 3 {
+		t.Errorf("Count() = %d, %v, want 3", count, err)
+	}
+	iter, err := reader.Search(context.Background(), bluge.NewTopNSearch(10, bluge.NewMatchQuery("hello").SetField("name")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	match, err := iter.Next()
+	if err != nil || match == nil {
+		t.Errorf("Search() = %v, %v, want a hit", match, err)
+	}
+}
+
+func TestSegmentCache(t *testing.T) {
+	uri := s3TestUri(t)
+	cache, err := NewSegmentCache(t.TempDir(), 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := func(cache *SegmentCache) bluge.Config {
+		rs, err := NewIndexConfigWithUri(uri, WithLock(nopLock{}), WithIdx("cache"), WithCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+
+	writer, err := bluge.OpenWriter(config(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Update(bluge.Identifier("1"), bluge.NewDocument("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		reader, err := bluge.OpenReader(config(cache))
+		if err != nil {
+			t.Fatalf("OpenReader() err = %v", err)
+		}
+		if count, err := reader.Count(); err != nil || count != 1 {
+			t.Errorf("Count() = %d, %v, want 1", count, err)
+		}
+		_ = reader.Close()
+	}
+	stats := cache.Stats()
+	if stats.Misses == 0 || stats.Hits != stats.Misses || stats.Items == 0 {
+		t.Errorf("Stats() = %+v, want the second reader served by the cache", stats)
+	}
+
+	// a cache reopened over the same files keeps them, past its size they are
+	// evicted
+	reopened, err := NewSegmentCache(cache.dir, stats.Bytes-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Stats(); got.Items != stats.Items-1 || got.Evictions != 1 {
+		t.Errorf("reopened Stats() = %+v, want one of %d items evicted", got, stats.Items)
+	}
+}
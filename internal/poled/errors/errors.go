@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrInvalidUri            = errors.New("invalid URI specified")
 	ErrUnSupportedSchemeType = errors.New("unsupported scheme type")
+	ErrChecksumMismatch      = errors.New("checksum mismatch")
 )
@@ -4,19 +4,29 @@ import (
 	"pole/internal/poled/directory"
 	"pole/internal/util/log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/blugelabs/bluge"
 	"golang.org/x/sync/singleflight"
 )
 
+const defaultRefreshInterval = time.Second
+
 var sg singleflight.Group
 
 type Reader struct {
 	*bluge.Reader
+	// Opened is when the reader was opened, the documents written after it
+	// are not visible.
+	Opened time.Time
+	// refs counts the searches holding the reader plus one while it is the
+	// current reader of its index.
+	refs int32
 }
 
-func NewReader(idx, uri string, lock directory.Lock) (*Reader, error) {
-	conf, err := directory.NewIndexConfigWithUri(uri, directory.WithLock(lock), directory.WithIdx(idx))
+func NewReader(idx, uri string, lock directory.Lock, cache *directory.SegmentCache) (*Reader, error) {
+	conf, err := directory.NewIndexConfigWithUri(uri, directory.WithLock(lock), directory.WithIdx(idx), directory.WithCache(cache))
 	if err != nil {
 		return nil, err
 	}
@@ -24,9 +34,23 @@ func NewReader(idx, uri string, lock directory.Lock) (*Reader, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newReader(reader), nil
+}
+
+func newReader(reader *bluge.Reader) *Reader {
 	return &Reader{
 		Reader: reader,
-	}, nil
+		Opened: time.Now(),
+		refs:   1,
+	}
+}
+
+// Release gives back a reader returned by Readers, it is closed once it is
+// replaced and no search holds it anymore.
+func (r *Reader) Release() {
+	if atomic.AddInt32(&r.refs, -1) == 0 {
+		_ = r.Reader.Close()
+	}
 }
 
 type Readers struct {
@@ -34,19 +58,72 @@ type Readers struct {
 	indexUri string
 	sync.RWMutex
 	lock directory.Lock
+	// writers opens the readers of the indexes written here from their live
+	// writer, so the refreshed reader sees the unpersisted segments.
+	writers  *Writers
+	cache    *directory.SegmentCache
+	interval time.Duration
+	// dirty holds the indexes written since their last refresh with the
+	// writes waiting for it.
+	dirty map[string][]chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+type ReadersOption func(r *Readers)
+
+func WithWriters(writers *Writers) ReadersOption {
+	return func(r *Readers) {
+		r.writers = writers
+	}
+}
+
+// WithReaderCache loads the segments of remote indexes through the cache.
+func WithReaderCache(cache *directory.SegmentCache) ReadersOption {
+	return func(r *Readers) {
+		r.cache = cache
+	}
+}
+
+// WithRefreshInterval sets how often the written indexes are refreshed, it
+// defaults to a second and a negative interval only refreshes on demand.
+func WithRefreshInterval(interval time.Duration) ReadersOption {
+	return func(r *Readers) {
+		r.interval = interval
+	}
 }
 
-func NewReaders(indexUri string, lock directory.Lock) *Readers {
-	return &Readers{
+func NewReaders(indexUri string, lock directory.Lock, opts ...ReadersOption) *Readers {
+	rs := &Readers{
 		Readers:  make(map[string]*Reader),
 		indexUri: indexUri,
 		lock:     lock,
+		dirty:    make(map[string][]chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, op := range opts {
+		op(rs)
+	}
+	if rs.interval == 0 {
+		rs.interval = defaultRefreshInterval
+	}
+	if rs.interval > 0 {
+		go rs.refreshLoop()
+	} else {
+		close(rs.done)
 	}
+	return rs
 }
 
+// Get returns the current reader of the index, it must be released once the
+// search is done.
 func (r *Readers) Get(idx string) (*Reader, bool) {
 	r.RLock()
 	reader, ok := r.Readers[idx]
+	if ok {
+		atomic.AddInt32(&reader.refs, 1)
+	}
 	r.RUnlock()
 	if ok {
 		return reader, ok
@@ -54,8 +131,13 @@ func (r *Readers) Get(idx string) (*Reader, bool) {
 
 	lg := log.WithField("module", "get reader")
 
-	rs, err, _ := sg.Do(idx, func() (interface{}, error) {
-		return NewReader(idx, r.indexUri, r.lock)
+	_, err, _ := sg.Do(r.indexUri+idx, func() (interface{}, error) {
+		reader, err := r.open(idx)
+		if err != nil {
+			return nil, err
+		}
+		r.replace(idx, reader)
+		return reader, nil
 	})
 
 	if err != nil {
@@ -63,40 +145,164 @@ func (r *Readers) Get(idx string) (*Reader, bool) {
 		return nil, false
 	}
 
-	if rs == nil {
-		return nil, false
+	// the reader is taken from the map, it may have been refreshed already
+	r.RLock()
+	defer r.RUnlock()
+	reader, ok = r.Readers[idx]
+	if ok {
+		atomic.AddInt32(&reader.refs, 1)
 	}
+	return reader, ok
+}
 
-	reader, ok = rs.(*Reader)
-	if !ok {
+// GetWithin returns a reader opened less than staleness ago, an older one is
+// refreshed. There is no bound when staleness is not positive.
+func (r *Readers) GetWithin(idx string, staleness time.Duration) (*Reader, bool) {
+	reader, ok := r.Get(idx)
+	if !ok || staleness <= 0 || time.Since(reader.Opened) < staleness {
+		return reader, ok
+	}
+	reader.Release()
+	if err := r.Refresh(idx); err != nil {
+		log.WithField("module", "get reader").Error(err)
 		return nil, false
 	}
+	return r.Get(idx)
+}
 
-	r.Add(idx, reader)
-
-	return reader, true
+func (r *Readers) open(idx string) (*Reader, error) {
+	if r.writers != nil {
+		if writer, ok := r.writers.Lookup(idx); ok {
+			reader, err := writer.Reader()
+			if err != nil {
+				return nil, err
+			}
+			return newReader(reader), nil
+		}
+	}
+	return NewReader(idx, r.indexUri, r.lock, r.cache)
 }
 
-func (r *Readers) Add(idx string, reader *Reader) {
+// replace makes reader the current reader of the index and releases the one
+// it replaces.
+func (r *Readers) replace(idx string, reader *Reader) {
 	r.Lock()
-	defer r.Unlock()
+	old := r.Readers[idx]
 	r.Readers[idx] = reader
+	r.Unlock()
+	if old != nil {
+		old.Release()
+	}
 }
 
-func (r *Readers) Delete(idx string) {
+// MarkDirty schedules a refresh of the index after a write.
+func (r *Readers) MarkDirty(idx string) {
 	r.Lock()
 	defer r.Unlock()
-	delete(r.Readers, idx)
+	if _, ok := r.dirty[idx]; !ok {
+		r.dirty[idx] = nil
+	}
 }
 
-func (r *Readers) Clear(idx string) {
-	reader, ok := r.Get(idx)
-	if !ok {
-		return
+// WaitFor returns a channel closed once the index is refreshed, a write made
+// before the call is then visible.
+func (r *Readers) WaitFor(idx string) <-chan struct{} {
+	ch := make(chan struct{})
+	r.Lock()
+	r.dirty[idx] = append(r.dirty[idx], ch)
+	r.Unlock()
+	if r.interval < 0 {
+		go func() {
+			if err := r.Refresh(idx); err != nil {
+				log.WithField("module", "refresh").WithField("index", idx).Error(err)
+			}
+		}()
 	}
+	return ch
+}
+
+// Refresh opens a new reader of the index, the old one is closed once the
+// searches holding it are done. An index without reader is opened on the
+// next Get.
+func (r *Readers) Refresh(idx string) error {
+	r.Lock()
+	waiters := r.dirty[idx]
+	delete(r.dirty, idx)
+	_, loaded := r.Readers[idx]
+	r.Unlock()
+	defer func() {
+		for _, ch := range waiters {
+			close(ch)
+		}
+	}()
+	if !loaded {
+		return nil
+	}
+
+	reader, err := r.open(idx)
+	if err != nil {
+		return err
+	}
+	r.replace(idx, reader)
+	return nil
+}
 
-	r.Delete(idx)
+func (r *Readers) refreshLoop() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.RLock()
+			dirty := make([]string, 0, len(r.dirty))
+			for idx := range r.dirty {
+				dirty = append(dirty, idx)
+			}
+			r.RUnlock()
+			for _, idx := range dirty {
+				if err := r.Refresh(idx); err != nil {
+					log.WithField("module", "refresh").WithField("index", idx).Error(err)
+				}
+			}
+		}
+	}
+}
 
-	_ = reader.Close()
+// Delete releases the reader of a dropped index.
+func (r *Readers) Delete(idx string) {
+	r.Lock()
+	reader, ok := r.Readers[idx]
+	delete(r.Readers, idx)
+	waiters := r.dirty[idx]
+	delete(r.dirty, idx)
+	r.Unlock()
+	for _, ch := range waiters {
+		close(ch)
+	}
+	if ok {
+		reader.Release()
+	}
+}
 
+// Close stops refreshing and releases the readers.
+func (r *Readers) Close() {
+	select {
+	case <-r.stop:
+		return
+	default:
+		close(r.stop)
+	}
+	<-r.done
+	r.RLock()
+	indexes := make([]string, 0, len(r.Readers))
+	for idx := range r.Readers {
+		indexes = append(indexes, idx)
+	}
+	r.RUnlock()
+	for _, idx := range indexes {
+		r.Delete(idx)
+	}
 }
@@ -0,0 +1,381 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pole/internal/util/log"
+
+	"github.com/blugelabs/bluge/index"
+)
+
+type Durability string
+
+const (
+	// DurabilityRequest fsyncs the translog before each batch is applied.
+	DurabilityRequest Durability = "request"
+	// DurabilityAsync fsyncs the translog every sync interval, the batches
+	// applied since the last fsync may be lost on a crash.
+	DurabilityAsync Durability = "async"
+
+	defaultTranslogSyncInterval = 5 * time.Second
+	translogExt                 = ".tlog"
+	appliedExt                  = ".applied"
+	// translogHeaderSize is the size of the length and the checksum heading
+	// every record.
+	translogHeaderSize = 8
+)
+
+var ErrDurability = errors.New("translog durability must be request or async")
+
+func ParseDurability(durability string) (Durability, error) {
+	switch rs := Durability(strings.ToLower(durability)); rs {
+	case "":
+		return DurabilityRequest, nil
+	case DurabilityRequest, DurabilityAsync:
+		return rs, nil
+	}
+	return "", fmt.Errorf("%w:%s", ErrDurability, durability)
+}
+
+// TranslogReplayer builds the batch of a record again when the translog of
+// idx is replayed.
+type TranslogReplayer func(idx string, data []byte) (*index.Batch, error)
+
+type translogConfig struct {
+	dir          string
+	durability   Durability
+	syncInterval time.Duration
+	replay       TranslogReplayer
+}
+
+func (c *translogConfig) path(idx string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(idx)+translogExt)
+}
+
+// appliedPath is where the index of the last raft log entry persisted is
+// kept, next to the translog.
+func (c *translogConfig) appliedPath(idx string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(idx)+appliedExt)
+}
+
+// readApplied returns the index kept at path, 0 when there is none.
+func readApplied(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// writeApplied replaces the index kept at path, a crash leaves the previous
+// one.
+func writeApplied(path string, applied uint64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(strconv.FormatUint(applied, 10))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// translogFile is the file records are appended to.
+type translogFile interface {
+	io.Writer
+	io.ReaderAt
+	io.Seeker
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// Translog records the batches of a writer before they are applied, a record
+// is kept until bluge reports its batch persisted to the directory.
+type Translog struct {
+	sync.Mutex
+	path       string
+	file       translogFile
+	durability Durability
+	seq        uint64
+	// start is the sequence number before the first record in the file,
+	// sizes holds the size of each record from there on.
+	start uint64
+	sizes []int64
+	size  int64
+	// pending holds the records whose batches are not persisted yet.
+	pending map[uint64]struct{}
+	dirty   bool
+	closed  bool
+	// broken is set when a failed append could not be cut off, the records
+	// appended after it would not be replayed.
+	broken error
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// OpenTranslog opens the translog at path and returns the records left by
+// the last run, a torn record at the end is cut off.
+func OpenTranslog(path string, durability Durability, syncInterval time.Duration) (*Translog, [][]byte, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	records, sizes, size, err := readTranslog(file)
+	if err == nil {
+		err = file.Truncate(size)
+	}
+	if err == nil {
+		_, err = file.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+
+	rs := &Translog{
+		path:       path,
+		file:       file,
+		durability: durability,
+		sizes:      sizes,
+		size:       size,
+		pending:    make(map[uint64]struct{}),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for range records {
+		rs.seq++
+		rs.pending[rs.seq] = struct{}{}
+	}
+	if syncInterval <= 0 {
+		syncInterval = defaultTranslogSyncInterval
+	}
+	go rs.syncLoop(syncInterval)
+	return rs, records, nil
+}
+
+// readTranslog reads the records up to the first one that is not complete,
+// size is where it starts and sizes the size of each record read.
+func readTranslog(file *os.File) ([][]byte, []int64, int64, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, 0, err
+	}
+	reader := bufio.NewReader(file)
+	var rs [][]byte
+	var sizes []int64
+	var size int64
+	header := make([]byte, translogHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return rs, sizes, size, nil
+		}
+		data := make([]byte, binary.LittleEndian.Uint32(header))
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return rs, sizes, size, nil
+		}
+		if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[4:]) {
+			return rs, sizes, size, nil
+		}
+		rs = append(rs, data)
+		sizes = append(sizes, int64(translogHeaderSize+len(data)))
+		size += int64(translogHeaderSize + len(data))
+	}
+}
+
+// Append records data and returns its sequence number, with the request
+// durability it is on disk once Append returns.
+func (t *Translog) Append(data []byte) (uint64, error) {
+	t.Lock()
+	defer t.Unlock()
+	record := make([]byte, translogHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(record, uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(data))
+	copy(record[translogHeaderSize:], data)
+	if t.broken != nil {
+		return 0, t.broken
+	}
+	if _, err := t.file.Write(record); err != nil {
+		t.rollback()
+		return 0, err
+	}
+	t.dirty = true
+	if t.durability == DurabilityRequest {
+		if err := t.sync(); err != nil {
+			t.rollback()
+			return 0, err
+		}
+	}
+	t.sizes = append(t.sizes, int64(len(record)))
+	t.size += int64(len(record))
+	t.seq++
+	t.pending[t.seq] = struct{}{}
+	return t.seq, nil
+}
+
+// rollback cuts off what a failed append wrote after the last record.
+func (t *Translog) rollback() {
+	err := t.file.Truncate(t.size)
+	if err == nil {
+		_, err = t.file.Seek(t.size, io.SeekStart)
+	}
+	if err != nil {
+		log.WithField("module", "translog").Error(err)
+		t.broken = err
+	}
+}
+
+// Release drops the record once its batch is persisted or will never be
+// applied, the records before the lowest one pending are truncated.
+func (t *Translog) Release(seq uint64) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.pending, seq)
+	t.truncate()
+}
+
+// persistedCallback is set on the batch of the record.
+func (t *Translog) persistedCallback(seq uint64) func(error) {
+	return func(err error) {
+		if err == nil {
+			t.Release(seq)
+		}
+	}
+}
+
+// truncate cuts the records released before the lowest one pending. The file
+// is emptied once nothing is pending, otherwise the records left are copied
+// to a new file when the released ones take at least half of it, so a record
+// whose batch takes long to persist does not keep the translog growing.
+func (t *Translog) truncate() {
+	if t.closed {
+		return
+	}
+	lowest := t.seq + 1
+	for seq := range t.pending {
+		if seq < lowest {
+			lowest = seq
+		}
+	}
+	released := int(lowest - 1 - t.start)
+	if released == 0 {
+		return
+	}
+	var prefix int64
+	for _, size := range t.sizes[:released] {
+		prefix += size
+	}
+	if len(t.pending) > 0 && prefix*2 < t.size {
+		return
+	}
+	if err := t.cut(prefix); err != nil {
+		log.WithField("module", "translog").Error(err)
+		return
+	}
+	t.start += uint64(released)
+	t.sizes = t.sizes[released:]
+	t.size -= prefix
+	t.dirty = false
+}
+
+// cut removes the first prefix bytes of the file.
+func (t *Translog) cut(prefix int64) error {
+	if prefix == t.size {
+		if err := t.file.Truncate(0); err != nil {
+			return err
+		}
+		_, err := t.file.Seek(0, io.SeekStart)
+		return err
+	}
+	tmp := t.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, io.NewSectionReader(t.file, prefix, t.size-prefix))
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, t.path)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	_ = t.file.Close()
+	t.file = file
+	return nil
+}
+
+func (t *Translog) sync() error {
+	if !t.dirty {
+		return nil
+	}
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+func (t *Translog) syncLoop(interval time.Duration) {
+	defer close(t.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.Lock()
+			if err := t.sync(); err != nil {
+				log.WithField("module", "translog").Error(err)
+			}
+			t.Unlock()
+		}
+	}
+}
+
+// Close syncs the records still pending, they are replayed when the writer
+// is opened again.
+func (t *Translog) Close() error {
+	close(t.stop)
+	<-t.done
+	t.Lock()
+	defer t.Unlock()
+	t.closed = true
+	if err := t.sync(); err != nil {
+		_ = t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
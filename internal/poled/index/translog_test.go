@@ -0,0 +1,140 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTranslogTruncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.tlog")
+	size := func() int64 {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+	tl, _, err := OpenTranslog(path, DurabilityRequest, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seqs []uint64
+	for _, data := range []string{"a", "b", "c"} {
+		seq, err := tl.Append([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, seq)
+	}
+	record := size() / 3
+
+	// the records released before the pending one are cut once they take
+	// half of the file
+	tl.Release(seqs[0])
+	if got := size(); got != 3*record {
+		t.Errorf("size after releasing a = %d, want %d", got, 3*record)
+	}
+	tl.Release(seqs[1])
+	if got := size(); got != record {
+		t.Errorf("size after releasing b = %d, want %d", got, record)
+	}
+	seq, err := tl.Append([]byte("d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Release(seq)
+	if got := size(); got != 2*record {
+		t.Errorf("size after releasing d = %d, want %d", got, 2*record)
+	}
+	if err := tl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tl, records, err := OpenTranslog(path, DurabilityRequest, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	if len(records) != 2 || string(records[0]) != "c" || string(records[1]) != "d" {
+		t.Errorf("records replayed = %q, want [c d]", records)
+	}
+	tl.Release(1)
+	tl.Release(2)
+	if got := size(); got != 0 {
+		t.Errorf("size once nothing is pending = %d, want 0", got)
+	}
+}
+
+var errFailingFile = errors.New("failing file")
+
+// failingFile writes half of a record and fails, or fails to sync.
+type failingFile struct {
+	*os.File
+	failWrite, failSync bool
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	if !f.failWrite {
+		return f.File.Write(p)
+	}
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errFailingFile
+}
+
+func (f *failingFile) Sync() error {
+	if f.failSync {
+		return errFailingFile
+	}
+	return f.File.Sync()
+}
+
+func TestTranslogAppendFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.tlog")
+	tl, _, err := OpenTranslog(path, DurabilityRequest, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tl.Append([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	file := &failingFile{File: tl.file.(*os.File), failWrite: true}
+	tl.file = file
+	if _, err := tl.Append([]byte("torn")); !errors.Is(err, errFailingFile) {
+		t.Fatalf("Append() with a failing write err = %v, want %v", err, errFailingFile)
+	}
+	file.failWrite, file.failSync = false, true
+	if _, err := tl.Append([]byte("unsynced")); !errors.Is(err, errFailingFile) {
+		t.Fatalf("Append() with a failing sync err = %v, want %v", err, errFailingFile)
+	}
+	file.failSync = false
+	seq, err := tl.Append([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 2 || len(tl.sizes) != 2 {
+		t.Errorf("Append() after the failures = seq %d with %d sizes, want 2 and 2", seq, len(tl.sizes))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != tl.size {
+		t.Errorf("file size = %d, want %d", info.Size(), tl.size)
+	}
+	if err := tl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tl, records, err := OpenTranslog(path, DurabilityRequest, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	if len(records) != 2 || string(records[0]) != "a" || string(records[1]) != "b" {
+		t.Errorf("records replayed = %q, want [a b]", records)
+	}
+}
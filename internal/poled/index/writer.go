@@ -1,11 +1,15 @@
 package index
 
 import (
+	"os"
 	"pole/internal/poled/directory"
 	"pole/internal/util/log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/index"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -13,10 +17,149 @@ var wsg singleflight.Group
 
 type Writer struct {
 	*bluge.Writer
+	translog *Translog
+	// applied is the index of the last raft log entry applied, the last one
+	// persisted is kept at appliedPath.
+	applied     uint64
+	appliedPath string
+	persisted   uint64
+	appliedLock sync.Mutex
 }
 
-func NewWriter(idx, uri string, lock directory.Lock) (*Writer, error) {
-	conf, err := directory.NewIndexConfigWithUri(uri, directory.WithLock(lock), directory.WithIdx(idx))
+// LogBatch records the batch in the translog before applying it, record is
+// what the replayer builds the batch from again.
+func (w *Writer) LogBatch(record []byte, batch *index.Batch) error {
+	if w.translog == nil {
+		return w.Batch(batch)
+	}
+	seq, err := w.translog.Append(record)
+	if err != nil {
+		return err
+	}
+	return w.applyLogged(seq, batch)
+}
+
+// LogBatchPersisted is LogBatch returning once the batch is persisted, a
+// reader opened from the directory then sees it.
+func (w *Writer) LogBatchPersisted(record []byte, batch *index.Batch) error {
+	persisted := make(chan error, 1)
+	notify := func(err error) {
+		persisted <- err
+	}
+	if w.translog == nil {
+		batch.SetPersistedCallback(notify)
+		if err := w.Batch(batch); err != nil {
+			return err
+		}
+		return <-persisted
+	}
+	seq, err := w.translog.Append(record)
+	if err != nil {
+		return err
+	}
+	release := w.translog.persistedCallback(seq)
+	batch.SetPersistedCallback(func(err error) {
+		release(err)
+		notify(err)
+	})
+	if err := w.Batch(batch); err != nil {
+		w.translog.Release(seq)
+		return err
+	}
+	return <-persisted
+}
+
+// LogApplied is LogBatch for the raft log entry at applied, the index is
+// kept once the batch is persisted so the entry is skipped when raft applies
+// the log again.
+func (w *Writer) LogApplied(applied uint64, record []byte, batch *index.Batch) error {
+	if w.translog == nil {
+		if err := w.Batch(batch); err != nil {
+			return err
+		}
+		atomic.StoreUint64(&w.applied, applied)
+		return nil
+	}
+	seq, err := w.translog.Append(record)
+	if err != nil {
+		return err
+	}
+	release := w.translog.persistedCallback(seq)
+	batch.SetPersistedCallback(func(err error) {
+		if err == nil {
+			w.persistApplied(applied)
+		}
+		release(err)
+	})
+	if err := w.Batch(batch); err != nil {
+		w.translog.Release(seq)
+		return err
+	}
+	atomic.StoreUint64(&w.applied, applied)
+	return nil
+}
+
+// Applied returns the index of the last raft log entry applied.
+func (w *Writer) Applied() uint64 {
+	return atomic.LoadUint64(&w.applied)
+}
+
+func (w *Writer) persistApplied(applied uint64) {
+	w.appliedLock.Lock()
+	defer w.appliedLock.Unlock()
+	if applied <= w.persisted || w.appliedPath == "" {
+		return
+	}
+	if err := writeApplied(w.appliedPath, applied); err != nil {
+		log.WithField("module", "applied index").Error(err)
+		return
+	}
+	w.persisted = applied
+}
+
+func (w *Writer) applyLogged(seq uint64, batch *index.Batch) error {
+	batch.SetPersistedCallback(w.translog.persistedCallback(seq))
+	if err := w.Batch(batch); err != nil {
+		w.translog.Release(seq)
+		return err
+	}
+	return nil
+}
+
+// replay applies the records left in the translog by the last run, a record
+// that can not be built again is dropped.
+func (w *Writer) replay(idx string, records [][]byte, replay TranslogReplayer) error {
+	lg := log.WithField("module", "replay translog").WithField("index", idx)
+	for i, record := range records {
+		seq := uint64(i + 1)
+		batch, err := replay(idx, record)
+		if err != nil {
+			lg.Error(err)
+			w.translog.Release(seq)
+			continue
+		}
+		if err := w.applyLogged(seq, batch); err != nil {
+			return err
+		}
+	}
+	if len(records) > 0 {
+		lg.Info("replayed: ", len(records))
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	err := w.Writer.Close()
+	if w.translog != nil {
+		if tlErr := w.translog.Close(); err == nil {
+			err = tlErr
+		}
+	}
+	return err
+}
+
+func NewWriter(idx, uri string, lock directory.Lock, cache *directory.SegmentCache) (*Writer, error) {
+	conf, err := directory.NewIndexConfigWithUri(uri, directory.WithLock(lock), directory.WithIdx(idx), directory.WithCache(cache))
 	if err != nil {
 		return nil, err
 	}
@@ -33,15 +176,63 @@ type Writers struct {
 	Writers  map[string]*Writer
 	indexUri string
 	sync.RWMutex
-	lock directory.Lock
+	lock     directory.Lock
+	cache    *directory.SegmentCache
+	translog *translogConfig
+}
+
+type WritersOption func(w *Writers)
+
+// WithTranslog keeps a translog of every writer under dir, Get replays it
+// when the writer is opened.
+func WithTranslog(dir string, durability Durability, syncInterval time.Duration, replay TranslogReplayer) WritersOption {
+	return func(w *Writers) {
+		w.translog = &translogConfig{dir: dir, durability: durability, syncInterval: syncInterval, replay: replay}
+	}
 }
 
-func NewWriters(indexUri string, lock directory.Lock) *Writers {
-	return &Writers{
+// WithWriterCache keeps the segments persisted to remote indexes in the
+// cache.
+func WithWriterCache(cache *directory.SegmentCache) WritersOption {
+	return func(w *Writers) {
+		w.cache = cache
+	}
+}
+
+func NewWriters(indexUri string, lock directory.Lock, opts ...WritersOption) *Writers {
+	rs := &Writers{
 		indexUri: indexUri,
 		Writers:  make(map[string]*Writer),
 		lock:     lock,
 	}
+	for _, op := range opts {
+		op(rs)
+	}
+	return rs
+}
+
+func (w *Writers) open(idx string) (*Writer, error) {
+	writer, err := NewWriter(idx, w.indexUri, w.lock, w.cache)
+	if err != nil || w.translog == nil {
+		return writer, err
+	}
+	translog, records, err := OpenTranslog(w.translog.path(idx), w.translog.durability, w.translog.syncInterval)
+	if err != nil {
+		_ = writer.Close()
+		return nil, err
+	}
+	writer.translog = translog
+	writer.appliedPath = w.translog.appliedPath(idx)
+	if writer.persisted, err = readApplied(writer.appliedPath); err != nil {
+		_ = writer.Close()
+		return nil, err
+	}
+	writer.applied = writer.persisted
+	if err := writer.replay(idx, records, w.translog.replay); err != nil {
+		_ = writer.Close()
+		return nil, err
+	}
+	return writer, nil
 }
 
 func (w *Writers) Add(idx string, writer *Writer) {
@@ -56,6 +247,32 @@ func (w *Writers) Delete(idx string) {
 	delete(w.Writers, idx)
 }
 
+// Close closes the writer of the index if it is open.
+func (w *Writers) Close(idx string) error {
+	w.Lock()
+	writer, ok := w.Writers[idx]
+	delete(w.Writers, idx)
+	w.Unlock()
+	if !ok {
+		return nil
+	}
+	return writer.Close()
+}
+
+// Drop closes the writer of the index and removes its translog and applied
+// index, the index is gone so there is nothing to replay.
+func (w *Writers) Drop(idx string) error {
+	err := w.Close(idx)
+	if w.translog != nil {
+		for _, path := range []string{w.translog.path(idx), w.translog.appliedPath(idx)} {
+			if rmErr := os.Remove(path); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+				err = rmErr
+			}
+		}
+	}
+	return err
+}
+
 func (w *Writers) Get(idx string) (*Writer, bool) {
 	w.RLock()
 	writer, ok := w.Writers[idx]
@@ -65,8 +282,8 @@ func (w *Writers) Get(idx string) (*Writer, bool) {
 	}
 	lg := log.WithField("module", "get writer")
 
-	rs, err, _ := wsg.Do(idx, func() (interface{}, error) {
-		return NewWriter(idx, w.indexUri, w.lock)
+	rs, err, _ := wsg.Do(w.indexUri+idx, func() (interface{}, error) {
+		return w.open(idx)
 	})
 
 	if err != nil {
@@ -88,6 +305,14 @@ func (w *Writers) Get(idx string) (*Writer, bool) {
 	return writer, true
 }
 
+// Lookup returns the writer of the index if it is open.
+func (w *Writers) Lookup(idx string) (*Writer, bool) {
+	w.RLock()
+	defer w.RUnlock()
+	writer, ok := w.Writers[idx]
+	return writer, ok
+}
+
 func (w *Writers) All() map[string]*Writer {
 	w.RLock()
 	defer w.RUnlock()
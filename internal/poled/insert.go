@@ -0,0 +1,133 @@
+package poled
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	sqlParser "pole/internal/poled/sql"
+)
+
+// RowFailure reports a row of an insert that was not written, Row counts
+// the rows of the statement from 1.
+type RowFailure struct {
+	Row   int    `json:"row"`
+	ID    string `json:"_id,omitempty"`
+	Error string `json:"error"`
+}
+
+// InsertResp counts the rows an insert affected as MySQL does, a row that
+// replaced or updated a duplicate counts twice. Ids are the ids generated
+// for the rows given none, in the order of the rows.
+type InsertResp struct {
+	Affected int64        `json:"affected_rows"`
+	Ids      []string     `json:"ids,omitempty"`
+	Failures []RowFailure `json:"failures,omitempty"`
+}
+
+// insertResult reports the rows an insert wrote and the rows that failed,
+// the insert fails once one of its rows did. The rows written on the way are
+// kept, the shards are written by their nodes one after the other.
+type insertResult struct {
+	resp InsertResp
+	// rows counts the rows of the insert
+	rows int
+	// failed is the error of the first failed row
+	failed error
+	// routed is set for the rows the leader routed here or the copies of
+	// its rows, they are reported to the node failing the insert
+	routed bool
+}
+
+func newInsertResult(rows int) *insertResult {
+	return &insertResult{rows: rows}
+}
+
+func (r *insertResult) Error() error {
+	if r.failed == nil || r.routed {
+		return nil
+	}
+	return fmt.Errorf("%w, %d of %d rows failed", r.failed, len(r.resp.Failures), r.rows)
+}
+
+func (r *insertResult) Resp() interface{} {
+	return &r.resp
+}
+
+func (r *insertResult) Code() int {
+	if r.Error() != nil {
+		return http.StatusConflict
+	}
+	return http.StatusOK
+}
+
+// String is what a node replies to the write routed to it.
+func (r *insertResult) String() string {
+	data, _ := json.Marshal(&r.resp)
+	return string(data)
+}
+
+// add counts the writes of a shard.
+func (r *insertResult) add(writes []sqlParser.Write) {
+	for _, w := range writes {
+		if w.Err == nil {
+			r.resp.Affected += int64(w.Affected)
+			continue
+		}
+		if r.failed == nil {
+			r.failed = w.Err
+		}
+		r.resp.Failures = append(r.resp.Failures, RowFailure{Row: w.Row, ID: w.ID, Error: w.Err.Error()})
+	}
+}
+
+// addRemote counts the writes of the shards routed to another node.
+func (r *insertResult) addRemote(rs result) error {
+	reply, ok := rs.(*rpcResult)
+	if !ok {
+		return nil
+	}
+	remote := InsertResp{}
+	if err := json.Unmarshal(reply.message, &remote); err != nil {
+		return err
+	}
+	r.resp.Affected += remote.Affected
+	r.resp.Failures = append(r.resp.Failures, remote.Failures...)
+	if r.failed == nil && len(remote.Failures) > 0 {
+		r.failed = errors.New(remote.Failures[0].Error)
+	}
+	return nil
+}
+
+func (r *insertResult) sort() {
+	sort.Slice(r.resp.Failures, func(i, j int) bool { return r.resp.Failures[i].Row < r.resp.Failures[j].Row })
+}
+
+// withIds reports the ids generated for an insert, it was written by this
+// node or by the leader.
+func withIds(rs result, ids []string) result {
+	switch r := rs.(type) {
+	case *insertResult:
+		r.resp.Ids = ids
+	case *rpcResult:
+		inserted := &insertResult{}
+		if err := inserted.addRemote(r); err != nil {
+			return rs
+		}
+		inserted.resp.Ids = ids
+		return &rpcResult{message: json.RawMessage(inserted.String())}
+	}
+	return rs
+}
+
+// written reports whether a shard has writes to apply.
+func written(writes []sqlParser.Write) bool {
+	for _, w := range writes {
+		if w.Err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package meta
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 
@@ -14,6 +15,7 @@ import (
 var (
 	ErrAlreadyLocked   = errors.New("already-locked")
 	ErrAlreadyUnlocked = errors.New("already-unlocked")
+	ErrWriteNotApplied = errors.New("logged write not applied")
 )
 
 type raftLogOp int
@@ -24,14 +26,37 @@ const (
 	raftLogLeaderChange
 	raftLogLock
 	raftLogUnlock
+	raftLogOpAlter
+	raftLogOpNodeJoin
+	raftLogOpNodeLeave
+	raftLogOpWrite
 )
 
 type RaftLogData struct {
-	Op             raftLogOp `json:"op"`
-	Index          string    `json:"index"`
-	Mapping        Mapping   `json:"mapping"`
-	LeaderGrpcAddr string    `json:"leaderGrpcAddr"`
-	LockUri        string    `json:"lockUri,omitempty"`
+	Op             raftLogOp    `json:"op"`
+	Index          string       `json:"index"`
+	Mapping        Mapping      `json:"mapping"`
+	LeaderGrpcAddr string       `json:"leaderGrpcAddr"`
+	LockUri        string       `json:"lockUri,omitempty"`
+	NodeId         string       `json:"nodeId,omitempty"`
+	NodeGrpcAddr   string       `json:"nodeGrpcAddr,omitempty"`
+	Writes         []ShardWrite `json:"writes,omitempty"`
+}
+
+// ShardWrite is what a logged write puts in a shard, the documents as the
+// leader resolved them and the ids deleted. Node is the node hosting the
+// shard when it was logged, it applies them to the shard and the nodes
+// keeping its copies to those.
+type ShardWrite struct {
+	Shard   int         `json:"shard"`
+	Node    string      `json:"node"`
+	Docs    []LoggedDoc `json:"docs,omitempty"`
+	Deletes []string    `json:"deletes,omitempty"`
+}
+
+type LoggedDoc struct {
+	ID     string                 `json:"id"`
+	Values map[string]interface{} `json:"values"`
 }
 
 func (l *RaftLogData) String() string {
@@ -47,6 +72,14 @@ func NewAddLogDataCmd(index string, mapping Mapping) ([]byte, error) {
 	})
 }
 
+func NewAlterLogDataCmd(index string, mapping Mapping) ([]byte, error) {
+	return json.Marshal(&RaftLogData{
+		Op:      raftLogOpAlter,
+		Index:   index,
+		Mapping: mapping,
+	})
+}
+
 func NewDeleteLogDataCmd(index string) ([]byte, error) {
 	return json.Marshal(&RaftLogData{
 		Op:    raftLogOpDelete,
@@ -74,6 +107,34 @@ func NewUnLockCmd(lockUri string) ([]byte, error) {
 	})
 }
 
+// NewNodeJoinCmd registers the grpc address of a node which can then host
+// shards.
+func NewNodeJoinCmd(nodeId, grpcAddr string) ([]byte, error) {
+	return json.Marshal(&RaftLogData{
+		Op:           raftLogOpNodeJoin,
+		NodeId:       nodeId,
+		NodeGrpcAddr: grpcAddr,
+	})
+}
+
+func NewNodeLeaveCmd(nodeId string) ([]byte, error) {
+	return json.Marshal(&RaftLogData{
+		Op:     raftLogOpNodeLeave,
+		NodeId: nodeId,
+	})
+}
+
+// NewWriteCmd logs the documents an insert, update or delete writes, every
+// node applies them to the shards it hosts and the copies it keeps. Nothing
+// is read when they are applied, so applying them again changes nothing.
+func NewWriteCmd(index string, writes []ShardWrite) ([]byte, error) {
+	return json.Marshal(&RaftLogData{
+		Op:     raftLogOpWrite,
+		Index:  index,
+		Writes: writes,
+	})
+}
+
 func (m *Meta) Apply(log *raft.Log) interface{} {
 	lg := poleLog.WithField("module", "raftApply")
 	logData := &RaftLogData{}
@@ -87,13 +148,25 @@ func (m *Meta) Apply(log *raft.Log) interface{} {
 		m.Add(logData.Index, logData.Mapping)
 	case raftLogOpDelete:
 		m.Delete(logData.Index)
+	case raftLogOpAlter:
+		rs = m.Update(logData.Index, logData.Mapping)
 	case raftLogLeaderChange:
 		m.UpdateLeader(logData.LeaderGrpcAddr)
 	case raftLogLock:
 		rs = m.DLock(logData.LockUri)
 	case raftLogUnlock:
 		rs = m.DUnlock(logData.LockUri)
-
+	case raftLogOpNodeJoin:
+		m.AddNode(logData.NodeId, logData.NodeGrpcAddr)
+	case raftLogOpNodeLeave:
+		m.RemoveNode(logData.NodeId)
+	case raftLogOpWrite:
+		// a node going on without the entry would diverge from the others,
+		// it stops and applies the entry again when raft replays the log
+		if err := m.applyWrite(log.Index, logData.Index, logData.Writes); err != nil {
+			lg.Error(err)
+			panic(fmt.Errorf("%w: entry %d: %v", ErrWriteNotApplied, log.Index, err))
+		}
 	}
 	lg.Info("appply success")
 	return rs
@@ -101,9 +174,9 @@ func (m *Meta) Apply(log *raft.Log) interface{} {
 
 func (m *Meta) Snapshot() (raft.FSMSnapshot, error) {
 	m.RLock()
-	meta := m.MetaData
+	meta, nodes := m.MetaData, m.Nodes
 	m.RUnlock()
-	return newSnapshot(meta), nil
+	return newSnapshot(meta, nodes), nil
 }
 
 func (m *Meta) Restore(reader io.ReadCloser) error {
@@ -112,13 +185,25 @@ func (m *Meta) Restore(reader io.ReadCloser) error {
 		return err
 	}
 
-	meta := make(map[string]Mapping)
-
-	if err := json.Unmarshal(data, &meta); err != nil {
+	rs := &snapshot{}
+	if err := json.Unmarshal(data, rs); err != nil {
 		return err
 	}
+	// snapshots taken before the node registry only hold the mappings
+	if rs.MetaData == nil {
+		rs.MetaData = make(map[string]Mapping)
+		if err := json.Unmarshal(data, &rs.MetaData); err != nil {
+			return err
+		}
+	}
+	if rs.Nodes == nil {
+		rs.Nodes = make(map[string]string)
+	}
 
-	m.MetaData = meta
+	m.Lock()
+	defer m.Unlock()
+	m.MetaData = rs.MetaData
+	m.Nodes = rs.Nodes
 
 	return nil
 }
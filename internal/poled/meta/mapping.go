@@ -3,34 +3,66 @@ package meta
 import (
 	"errors"
 	"fmt"
+	"sort"
+
+	"pole/internal/poled/analyzer"
 
 	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/analysis"
 )
 
 const (
 	DefaultTextFieldOption        = bluge.Index | bluge.Store | bluge.SearchTermPositions | bluge.HighlightMatches | bluge.Sortable | bluge.Aggregatable
 	DefaultNumericIndexingOptions = bluge.Index | bluge.Store | bluge.Sortable | bluge.Aggregatable
+	DefaultTermFieldOption        = bluge.Index | bluge.Store | bluge.Sortable | bluge.Aggregatable
+	DefaultVectorFieldOption      = bluge.Store
 )
 
-const IdentifierField = "_id"
+const (
+	IdentifierField = "_id"
+	ScoreField      = "_score"
+	// VersionField counts the writes of a document, from 1 when inserted.
+	VersionField = "_version"
+)
 
 var (
 	ErrFieldNotFound         = errors.New("field not found")
 	ErrNotSupportedFieldType = errors.New("no supported filed type")
 	ErrFieldNotSetOption     = errors.New("field not set option")
+	ErrFieldRequired         = errors.New("field is required")
+	ErrIdentifierRequired    = errors.New("id is required")
 )
 
 type FieldType string
 
 const (
-	FieldTypeNumeric FieldType = "numeric"
-	FieldTypeText    FieldType = "text"
-	FieldTypeUnknown FieldType = "unknown"
+	FieldTypeNumeric  FieldType = "numeric"
+	FieldTypeText     FieldType = "text"
+	FieldTypeKeyword  FieldType = "keyword"
+	FieldTypeBoolean  FieldType = "boolean"
+	FieldTypeDatetime FieldType = "datetime"
+	FieldTypeGeoPoint FieldType = "geopoint"
+	FieldTypeVector   FieldType = "vector"
+	FieldTypeUnknown  FieldType = "unknown"
 )
 
+var fieldTypes = map[FieldType]struct{}{
+	FieldTypeNumeric:  {},
+	FieldTypeText:     {},
+	FieldTypeKeyword:  {},
+	FieldTypeBoolean:  {},
+	FieldTypeDatetime: {},
+	FieldTypeGeoPoint: {},
+	FieldTypeVector:   {},
+}
+
 type FiledOptions struct {
-	Type   FieldType `json:"type"`
-	Option Option    `json:"option"`
+	Type     FieldType   `json:"type"`
+	Analyzer string      `json:"analyzer,omitempty"`
+	Required bool        `json:"required,omitempty"`
+	Default  interface{} `json:"default,omitempty"`
+	Dims     int         `json:"dims,omitempty"`
+	Option   Option      `json:"option"`
 }
 
 type Option struct {
@@ -44,6 +76,49 @@ type Option struct {
 
 type Mapping struct {
 	Properties map[string]FiledOptions `json:"properties"`
+	// Shards is the number of bluge indexes the documents are spread over.
+	Shards int `json:"shards,omitempty"`
+	// Nodes holds the id of the node hosting each shard.
+	Nodes []string `json:"nodes,omitempty"`
+	// Replicas is the number of copies of each shard kept by other nodes,
+	// ReplicaNodes holds the ids of the nodes keeping them.
+	Replicas     int        `json:"replicas,omitempty"`
+	ReplicaNodes [][]string `json:"replica_nodes,omitempty"`
+	// Comment holds the table attributes given at creation, columns added
+	// later inherit them.
+	Comment string `json:"comment,omitempty"`
+}
+
+// Clone returns a copy of the mapping that can be modified without affecting
+// the mapping held by the meta data.
+func (m *Mapping) Clone() Mapping {
+	rs := Mapping{
+		Properties: make(map[string]FiledOptions, len(m.Properties)),
+		Shards:     m.Shards,
+		Nodes:      append([]string(nil), m.Nodes...),
+		Replicas:   m.Replicas,
+		Comment:    m.Comment,
+	}
+	for _, nodes := range m.ReplicaNodes {
+		rs.ReplicaNodes = append(rs.ReplicaNodes, append([]string(nil), nodes...))
+	}
+	for name, options := range m.Properties {
+		rs.Properties[name] = options
+	}
+	return rs
+}
+
+// UnstoredFields returns the sorted names of the columns whose values are
+// not stored, a document can not be rebuilt from what is stored of it.
+func (m *Mapping) UnstoredFields() []string {
+	var rs []string
+	for name, options := range m.Properties {
+		if options.Option != (Option{}) && !options.Option.Store {
+			rs = append(rs, name)
+		}
+	}
+	sort.Strings(rs)
+	return rs
 }
 
 func (m *Mapping) MakeField(name string, value interface{}) (bluge.Field, error) {
@@ -57,14 +132,91 @@ func (m *Mapping) MakeField(name string, value interface{}) (bluge.Field, error)
 		return m.MakeNumericField(name, value)
 	case FieldTypeText:
 		return m.MakeTextField(name, value)
-
+	case FieldTypeKeyword:
+		return m.MakeKeywordField(name, value)
+	case FieldTypeBoolean:
+		return m.MakeBooleanField(name, value)
+	case FieldTypeDatetime:
+		return m.MakeDatetimeField(name, value)
+	case FieldTypeGeoPoint:
+		return m.MakeGeoPointField(name, value)
+	case FieldTypeVector:
+		return m.MakeVectorField(name, value)
 	}
 
 	return nil, ErrNotSupportedFieldType
 }
 
+// MakeDefaultFields builds the fields missing from a new document using their
+// default values, it fails when a required field has no default.
+func (m *Mapping) MakeDefaultFields(present map[string]struct{}) ([]bluge.Field, error) {
+	var rs []bluge.Field
+	for name, options := range m.Properties {
+		if _, ok := present[name]; ok || name == "id" {
+			continue
+		}
+		if options.Default == nil {
+			if options.Required {
+				return nil, fmt.Errorf("%w:%s", ErrFieldRequired, name)
+			}
+			continue
+		}
+		field, err := m.MakeField(name, options.Default)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, field)
+	}
+	return rs, nil
+}
+
+// MakeDocument builds the document of a row, values are converted with
+// MakeField and the missing fields get their defaults. A new row has no
+// _version and gets the first one.
+func (m *Mapping) MakeDocument(id string, values map[string]interface{}) (*bluge.Document, error) {
+	if id == "" {
+		return nil, ErrIdentifierRequired
+	}
+	doc := bluge.NewDocument(id)
+	version, err := ParseNumeric(values[VersionField])
+	if err != nil || version < 1 {
+		version = 1
+	}
+	doc.AddField(m.MakeVersionField(int64(version)))
+	present := make(map[string]struct{}, len(values))
+	for name, value := range values {
+		if value == nil || name == VersionField {
+			continue
+		}
+		field, err := m.MakeField(name, value)
+		if err != nil {
+			return nil, err
+		}
+		present[name] = struct{}{}
+		doc.AddField(field)
+	}
+	defaults, err := m.MakeDefaultFields(present)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range defaults {
+		doc.AddField(field)
+	}
+	return doc, nil
+}
+
+func (m *Mapping) MakeVersionField(version int64) bluge.Field {
+	rs := bluge.NewNumericField(VersionField, float64(version))
+	rs.FieldOptions = DefaultNumericIndexingOptions
+	return rs
+}
+
 func (m *Mapping) MakeNumericField(name string, value interface{}) (bluge.Field, error) {
-	filed := bluge.NewNumericField(name, getNumericValue(value))
+	v, err := ParseNumeric(value)
+	if err != nil {
+		return nil, err
+	}
+	filed := bluge.NewNumericField(name, v)
 	fieldOptions, err := m.getFieldOptions(name)
 	if err != nil {
 		fieldOptions = DefaultNumericIndexingOptions
@@ -74,7 +226,11 @@ func (m *Mapping) MakeNumericField(name string, value interface{}) (bluge.Field,
 }
 
 func (m *Mapping) MakeTextField(name string, value interface{}) (bluge.Field, error) {
-	filed := bluge.NewTextField(name, fmt.Sprintf("%v", value))
+	fieldAnalyzer, err := m.Analyzer(name)
+	if err != nil {
+		return nil, err
+	}
+	filed := bluge.NewTextField(name, fmt.Sprintf("%v", value)).WithAnalyzer(fieldAnalyzer)
 	fieldOptions, err := m.getFieldOptions(name)
 	if err != nil {
 		fieldOptions = DefaultTextFieldOption
@@ -83,6 +239,67 @@ func (m *Mapping) MakeTextField(name string, value interface{}) (bluge.Field, er
 	return filed, nil
 }
 
+func (m *Mapping) MakeKeywordField(name string, value interface{}) (bluge.Field, error) {
+	return m.makeTermField(bluge.NewKeywordField(name, fmt.Sprintf("%v", value)), DefaultTermFieldOption), nil
+}
+
+func (m *Mapping) MakeBooleanField(name string, value interface{}) (bluge.Field, error) {
+	v, err := ParseBoolean(value)
+	if err != nil {
+		return nil, err
+	}
+	return m.makeTermField(bluge.NewKeywordField(name, BooleanTerm(v)), DefaultTermFieldOption), nil
+}
+
+func (m *Mapping) MakeDatetimeField(name string, value interface{}) (bluge.Field, error) {
+	v, err := ParseDatetime(value)
+	if err != nil {
+		return nil, err
+	}
+	return m.makeTermField(bluge.NewDateTimeField(name, v), DefaultTermFieldOption), nil
+}
+
+func (m *Mapping) MakeGeoPointField(name string, value interface{}) (bluge.Field, error) {
+	lon, lat, err := ParseGeoPoint(value)
+	if err != nil {
+		return nil, err
+	}
+	return m.makeTermField(bluge.NewGeoPointField(name, lon, lat), DefaultTermFieldOption), nil
+}
+
+func (m *Mapping) MakeVectorField(name string, value interface{}) (bluge.Field, error) {
+	v, err := ParseVector(value, m.Properties[name].Dims)
+	if err != nil {
+		return nil, err
+	}
+	return m.makeTermField(bluge.NewStoredOnlyField(name, EncodeVector(v)), DefaultVectorFieldOption), nil
+}
+
+func (m *Mapping) makeTermField(field *bluge.TermField, defaultOptions bluge.FieldOptions) *bluge.TermField {
+	fieldOptions, err := m.getFieldOptions(field.Name())
+	if err != nil {
+		fieldOptions = defaultOptions
+	}
+	field.FieldOptions = fieldOptions
+	return field
+}
+
+// Analyzer returns the analyzer configured for the field, it is used both
+// at index time and to analyze the search text of match queries.
+func (m *Mapping) Analyzer(name string) (*analysis.Analyzer, error) {
+	return analyzer.Get(m.Properties[name].Analyzer)
+}
+
+// Highlighted reports whether the field keeps what highlighting needs. Fields
+// of mappings written before options existed have none and are indexed with
+// the default text options, which do.
+func (f FiledOptions) Highlighted() bool {
+	if f.Option == (Option{}) {
+		return f.Type == FieldTypeText
+	}
+	return f.Option.Highlight
+}
+
 func (m *Mapping) getFieldOptions(name string) (bluge.FieldOptions, error) {
 	options := m.Properties[name]
 	var rs bluge.FieldOptions
@@ -110,13 +327,3 @@ func (m *Mapping) getFieldOptions(name string) (bluge.FieldOptions, error) {
 	}
 	return rs, err
 }
-
-func getNumericValue(value interface{}) float64 {
-	switch v := value.(type) {
-	case int64:
-		return float64(v)
-	case float64:
-		return v
-	}
-	return 0
-}
@@ -1,21 +1,54 @@
 package meta
 
 import (
+	"errors"
 	"sync"
 )
 
+var (
+	ErrIndexNotFound  = errors.New("index not found")
+	ErrNoWriteApplier = errors.New("no write applier set")
+)
+
 type Meta struct {
 	MetaData       map[string]Mapping  `json:"metaData"`
 	LeaderGrpcAddr string              `json:"leaderGrpcAddr"`
 	DLocked        map[string]struct{} `json:"dlock"`
+	// Nodes maps the raft id of the nodes to their grpc address.
+	Nodes map[string]string `json:"nodes"`
 	sync.RWMutex
+
+	writeApplier WriteApplier
 }
 
+// WriteApplier applies the writes of the raft log entry at applied to the
+// shards of index hosted here and the copies kept here.
+type WriteApplier func(applied uint64, index string, writes []ShardWrite) error
+
 func NewMeta() *Meta {
 	return &Meta{
 		MetaData: make(map[string]Mapping),
 		DLocked:  make(map[string]struct{}),
+		Nodes:    make(map[string]string),
+	}
+}
+
+// SetWriteApplier sets how the logged writes are applied, it is set before
+// raft starts replaying the log.
+func (m *Meta) SetWriteApplier(applier WriteApplier) {
+	m.Lock()
+	defer m.Unlock()
+	m.writeApplier = applier
+}
+
+func (m *Meta) applyWrite(applied uint64, index string, writes []ShardWrite) error {
+	m.RLock()
+	applier := m.writeApplier
+	m.RUnlock()
+	if applier == nil {
+		return ErrNoWriteApplier
 	}
+	return applier(applied, index, writes)
 }
 
 func (m *Meta) Exists(index string) bool {
@@ -49,6 +82,16 @@ func (m *Meta) Add(index string, fields Mapping) {
 	m.MetaData[index] = fields
 }
 
+func (m *Meta) Update(index string, fields Mapping) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.getIndex(index); !ok {
+		return ErrIndexNotFound
+	}
+	m.MetaData[index] = fields
+	return nil
+}
+
 func (m *Meta) All() map[string]Mapping {
 	m.RLock()
 	defer m.RUnlock()
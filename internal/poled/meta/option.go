@@ -0,0 +1,262 @@
+package meta
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"pole/internal/poled/analyzer"
+)
+
+var (
+	ErrInvalidFieldAttribute = errors.New("invalid field attribute")
+	ErrInvalidFieldOption    = errors.New("invalid field option")
+	ErrInvalidDefaultValue   = errors.New("invalid default value")
+)
+
+const (
+	attrSep      = ","
+	attrValueSep = "="
+
+	attrType          = "type"
+	attrDims          = "dims"
+	attrAnalyzer      = "analyzer"
+	attrIndex         = "index"
+	attrStore         = "store"
+	attrTermPositions = "term_positions"
+	attrHighlight     = "highlight"
+	attrSortable      = "sortable"
+	attrAggregatable  = "aggregatable"
+	attrReplicas      = "replicas"
+)
+
+var knownAttributes = map[string]bool{
+	attrType:          true,
+	attrDims:          true,
+	attrAnalyzer:      true,
+	attrIndex:         true,
+	attrStore:         true,
+	attrTermPositions: true,
+	attrHighlight:     true,
+	attrSortable:      true,
+	attrAggregatable:  true,
+	attrReplicas:      true,
+}
+
+var textOnlyAttributes = map[string]bool{
+	attrAnalyzer:      true,
+	attrTermPositions: true,
+	attrHighlight:     true,
+}
+
+// tableAttributes configure the index rather than its fields, they are only
+// accepted in the table comment.
+var tableAttributes = map[string]bool{
+	attrReplicas: true,
+}
+
+// NewFiledOptions builds the options of a field of type typ from the table
+// and column comments, both being comma separated lists such as
+// "analyzer=chinese, store=false, sortable". Column attributes override table
+// attributes, and table attributes that only make sense for text are ignored
+// by other field types. A comment describing the column rather than listing
+// attributes is ignored.
+func NewFiledOptions(typ FieldType, tableAttrs, columnAttrs string) (FiledOptions, error) {
+	if t, ok := typeAttribute(columnAttrs); ok {
+		typ = t
+	}
+	rs := FiledOptions{
+		Type:   typ,
+		Option: defaultOption(typ),
+	}
+	explicit := make(map[string]bool)
+	if err := rs.applyAttributes(tableAttrs, explicit, true); err != nil {
+		return rs, err
+	}
+	if err := rs.applyAttributes(columnAttrs, explicit, false); err != nil {
+		return rs, err
+	}
+	// Highlighting is on by default for text, drop it silently when the
+	// data it needs is disabled rather than rejecting the column.
+	if !explicit[attrHighlight] && (!rs.Option.Store || !rs.Option.TermPositions) {
+		rs.Option.Highlight = false
+	}
+	return rs, rs.Validate()
+}
+
+func defaultOption(typ FieldType) Option {
+	switch typ {
+	case FieldTypeText:
+		return Option{Index: true, Store: true, TermPositions: true, Highlight: true, Sortable: true, Aggregatable: true}
+	case FieldTypeVector:
+		return Option{Store: true}
+	}
+	return Option{Index: true, Store: true, Sortable: true, Aggregatable: true}
+}
+
+// typeAttribute looks for an explicit "type=..." in the column comment, it is
+// the only way to declare types that have no SQL counterpart like geopoint.
+func typeAttribute(attrs string) (FieldType, bool) {
+	if !isAttributeList(attrs) {
+		return "", false
+	}
+	for _, attr := range strings.Split(attrs, attrSep) {
+		i := strings.Index(attr, attrValueSep)
+		if i < 0 || strings.ToLower(strings.TrimSpace(attr[:i])) != attrType {
+			continue
+		}
+		return FieldType(strings.ToLower(strings.TrimSpace(attr[i+1:]))), true
+	}
+	return "", false
+}
+
+// TableReplicas reads the number of replicas of every shard from the table
+// comment, "replicas=1" keeps a copy of each shard on one more node.
+func TableReplicas(tableAttrs string) (int, error) {
+	if !isAttributeList(tableAttrs) {
+		return 0, nil
+	}
+	for _, attr := range strings.Split(tableAttrs, attrSep) {
+		i := strings.Index(attr, attrValueSep)
+		if i < 0 || strings.ToLower(strings.TrimSpace(attr[:i])) != attrReplicas {
+			continue
+		}
+		replicas, err := strconv.Atoi(strings.TrimSpace(attr[i+1:]))
+		if err != nil || replicas < 0 {
+			return 0, fmt.Errorf("%w:%s", ErrInvalidReplicas, strings.TrimSpace(attr))
+		}
+		return replicas, nil
+	}
+	return 0, nil
+}
+
+// isAttributeList reports whether the comment lists attributes, it sets one
+// of them to a value or only names flags such as "sortable, store".
+func isAttributeList(attrs string) bool {
+	flags, named := true, false
+	for _, attr := range strings.Split(attrs, attrSep) {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+		if i := strings.Index(attr, attrValueSep); i >= 0 {
+			if knownAttributes[strings.ToLower(strings.TrimSpace(attr[:i]))] {
+				return true
+			}
+			flags = false
+			continue
+		}
+		named = true
+		if !knownAttributes[strings.ToLower(attr)] {
+			flags = false
+		}
+	}
+	return flags && named
+}
+
+func (f *FiledOptions) applyAttributes(attrs string, explicit map[string]bool, table bool) error {
+	if !isAttributeList(attrs) {
+		return nil
+	}
+	for _, attr := range strings.Split(attrs, attrSep) {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+		key, value := attr, ""
+		if i := strings.Index(attr, attrValueSep); i >= 0 {
+			key, value = strings.TrimSpace(attr[:i]), strings.TrimSpace(attr[i+1:])
+		}
+		key = strings.ToLower(key)
+		if table && tableAttributes[key] {
+			continue
+		}
+		if table && f.Type != FieldTypeText && textOnlyAttributes[key] {
+			continue
+		}
+		explicit[key] = true
+
+		switch key {
+		case attrType:
+			continue
+		case attrAnalyzer:
+			f.Analyzer = analyzer.Normalize(value)
+			continue
+		case attrDims:
+			dims, err := strconv.Atoi(value)
+			if err != nil || dims <= 0 {
+				return fmt.Errorf("%w:%s", ErrInvalidFieldAttribute, attr)
+			}
+			f.Dims = dims
+			continue
+		}
+
+		flag := true
+		if value != "" {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%w:%s", ErrInvalidFieldAttribute, attr)
+			}
+			flag = v
+		}
+		switch key {
+		case attrIndex:
+			f.Option.Index = flag
+		case attrStore:
+			f.Option.Store = flag
+		case attrTermPositions:
+			f.Option.TermPositions = flag
+		case attrHighlight:
+			f.Option.Highlight = flag
+		case attrSortable:
+			f.Option.Sortable = flag
+		case attrAggregatable:
+			f.Option.Aggregatable = flag
+		default:
+			return fmt.Errorf("%w:%s", ErrInvalidFieldAttribute, attr)
+		}
+	}
+	return nil
+}
+
+// Validate reports options that can not be honored by the field type.
+func (f *FiledOptions) Validate() error {
+	if _, ok := fieldTypes[f.Type]; !ok {
+		return fmt.Errorf("%w:%s", ErrNotSupportedFieldType, f.Type)
+	}
+	if !f.Option.Index && !f.Option.Store {
+		return fmt.Errorf("%w: field must be indexed or stored", ErrInvalidFieldOption)
+	}
+	if f.Option.Highlight && (!f.Option.Store || !f.Option.TermPositions) {
+		return fmt.Errorf("%w: highlight requires store and term_positions", ErrInvalidFieldOption)
+	}
+
+	if f.Type != FieldTypeText {
+		if f.Analyzer != "" {
+			return fmt.Errorf("%w: analyzer is only supported by text fields", ErrInvalidFieldOption)
+		}
+		if f.Option.TermPositions || f.Option.Highlight {
+			return fmt.Errorf("%w: term_positions and highlight are only supported by text fields", ErrInvalidFieldOption)
+		}
+	}
+
+	if f.Type == FieldTypeVector && (f.Option.Index || f.Option.Sortable || f.Option.Aggregatable) {
+		return fmt.Errorf("%w: vector fields can only be stored", ErrInvalidFieldOption)
+	}
+	if f.Dims > 0 && f.Type != FieldTypeVector {
+		return fmt.Errorf("%w: dims is only supported by vector fields", ErrInvalidFieldOption)
+	}
+
+	if f.Analyzer != "" && !analyzer.Exists(f.Analyzer) {
+		return fmt.Errorf("%w:%s", analyzer.ErrAnalyzerNotFound, f.Analyzer)
+	}
+
+	if f.Default != nil {
+		m := Mapping{Properties: map[string]FiledOptions{"default": *f}}
+		if _, err := m.MakeField("default", f.Default); err != nil {
+			return fmt.Errorf("%w:%v", ErrInvalidDefaultValue, f.Default)
+		}
+	}
+	return nil
+}
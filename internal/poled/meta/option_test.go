@@ -0,0 +1,226 @@
+package meta
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"pole/internal/poled/analyzer"
+)
+
+func TestNewFiledOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     FieldType
+		table   string
+		column  string
+		want    Option
+		wantErr error
+	}{
+		{
+			name: "text-default",
+			typ:  FieldTypeText,
+			want: Option{Index: true, Store: true, TermPositions: true, Highlight: true, Sortable: true, Aggregatable: true},
+		},
+		{
+			name:   "text-no-store",
+			typ:    FieldTypeText,
+			column: "analyzer=chinese, store=false, sortable=false",
+			want:   Option{Index: true, TermPositions: true, Aggregatable: true},
+		},
+		{
+			name:   "numeric-table-analyzer",
+			typ:    FieldTypeNumeric,
+			table:  "analyzer=en, store=false",
+			column: "aggregatable=false",
+			want:   Option{Index: true, Sortable: true},
+		},
+		{
+			name:    "numeric-analyzer",
+			typ:     FieldTypeNumeric,
+			column:  "analyzer=en",
+			wantErr: ErrInvalidFieldOption,
+		},
+		{
+			name:    "highlight-without-store",
+			typ:     FieldTypeText,
+			column:  "store=false, highlight",
+			wantErr: ErrInvalidFieldOption,
+		},
+		{
+			name:    "unknown-attribute",
+			typ:     FieldTypeText,
+			column:  "store=false, compress",
+			wantErr: ErrInvalidFieldAttribute,
+		},
+		{
+			name:   "description",
+			typ:    FieldTypeText,
+			table:  "products of the shop",
+			column: "product title, shown in lists",
+			want:   Option{Index: true, Store: true, TermPositions: true, Highlight: true, Sortable: true, Aggregatable: true},
+		},
+		{
+			name:   "description-with-value",
+			typ:    FieldTypeNumeric,
+			column: "price=net price in cents",
+			want:   Option{Index: true, Store: true, Sortable: true, Aggregatable: true},
+		},
+		{
+			name:   "flags",
+			typ:    FieldTypeNumeric,
+			column: "index, store",
+			want:   Option{Index: true, Store: true, Sortable: true, Aggregatable: true},
+		},
+		{
+			name:    "unknown-analyzer",
+			typ:     FieldTypeText,
+			column:  "analyzer=klingon",
+			wantErr: analyzer.ErrAnalyzerNotFound,
+		},
+		{
+			name:   "geopoint-type",
+			typ:    FieldTypeText,
+			table:  "analyzer=en",
+			column: "type=geopoint",
+			want:   Option{Index: true, Store: true, Sortable: true, Aggregatable: true},
+		},
+		{
+			name:   "vector-dims",
+			typ:    FieldTypeText,
+			column: "type=vector, dims=3",
+			want:   Option{Store: true},
+		},
+		{
+			name:    "vector-index",
+			typ:     FieldTypeText,
+			column:  "type=vector, index",
+			wantErr: ErrInvalidFieldOption,
+		},
+		{
+			name:    "unknown-type",
+			typ:     FieldTypeText,
+			column:  "type=blob",
+			wantErr: ErrNotSupportedFieldType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := NewFiledOptions(tt.typ, tt.table, tt.column)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewFiledOptions() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && rs.Option != tt.want {
+				t.Errorf("NewFiledOptions() = %+v, want %+v", rs.Option, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeTypedField(t *testing.T) {
+	m := Mapping{Properties: map[string]FiledOptions{
+		"online":   {Type: FieldTypeBoolean},
+		"created":  {Type: FieldTypeDatetime},
+		"location": {Type: FieldTypeGeoPoint},
+		"embed":    {Type: FieldTypeVector, Dims: 2},
+	}}
+	tests := []struct {
+		name    string
+		value   interface{}
+		wantErr error
+	}{
+		{name: "online", value: int64(1)},
+		{name: "online", value: "yes", wantErr: ErrInvalidBoolean},
+		{name: "created", value: "2021-06-01 08:00:00"},
+		{name: "created", value: int64(1622534400)},
+		{name: "created", value: "tomorrow", wantErr: ErrInvalidDatetime},
+		{name: "location", value: "39.9,116.4"},
+		{name: "location", value: "POINT(116.4 39.9)"},
+		{name: "location", value: "116.4,239.9", wantErr: ErrInvalidGeoPoint},
+		{name: "embed", value: "[0.5, 1]"},
+		{name: "embed", value: "[0.5]", wantErr: ErrInvalidVector},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := m.MakeField(tt.name, tt.value); !errors.Is(err, tt.wantErr) {
+				t.Errorf("MakeField(%v) error = %v, want %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShards(t *testing.T) {
+	m := NewMeta()
+	m.AddNode("n1", ":5001")
+	m.AddNode("n2", ":5002")
+	m.AddNode("n3", ":5003")
+
+	mapping := Mapping{Shards: 4, Nodes: AssignShards(4, m.NodeIds())}
+	if want := []string{"n1", "n2", "n3", "n1"}; fmt.Sprint(mapping.Nodes) != fmt.Sprint(want) {
+		t.Fatalf("AssignShards() = %v, want %v", mapping.Nodes, want)
+	}
+	if shard := mapping.Shard("42"); shard != mapping.Shard("42") || shard < 0 || shard >= 4 {
+		t.Errorf("Shard() = %d, want a stable shard in [0, 4)", shard)
+	}
+	if name := mapping.ShardName("test", 2); name != "test/2" {
+		t.Errorf("ShardName() = %s, want test/2", name)
+	}
+	if name := (&Mapping{}).ShardName("test", 0); name != "test" {
+		t.Errorf("ShardName() of a single shard = %s, want test", name)
+	}
+
+	m.Add("test", mapping)
+	m.RemoveNode("n1")
+	got, _ := m.Get("test")
+	if fmt.Sprint(got.Nodes) != fmt.Sprint(mapping.Nodes) {
+		t.Errorf("RemoveNode() assignment = %v, want the shards kept on n1 until restored", got.Nodes)
+	}
+	if unavailable := m.UnavailableShards(got); fmt.Sprint(unavailable) != "[0 3]" {
+		t.Errorf("UnavailableShards() = %v, want [0 3]", unavailable)
+	}
+	restored := m.ReassignUnavailable(got)
+	if want := []string{"n2", "n2", "n3", "n3"}; fmt.Sprint(restored.Nodes) != fmt.Sprint(want) {
+		t.Errorf("ReassignUnavailable() assignment = %v, want %v", restored.Nodes, want)
+	}
+	if mapping.Nodes[0] != "n1" || got.Nodes[0] != "n1" {
+		t.Error("RemoveNode() and ReassignUnavailable() must not modify mappings handed out before")
+	}
+	if m.UnavailableShards(restored) != nil {
+		t.Errorf("UnavailableShards() after reassignment = %v, want none", m.UnavailableShards(restored))
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	if replicas, err := TableReplicas("analyzer=standard,replicas=2"); err != nil || replicas != 2 {
+		t.Errorf("TableReplicas() = %d, %v, want 2", replicas, err)
+	}
+	if _, err := TableReplicas("replicas=-1"); !errors.Is(err, ErrInvalidReplicas) {
+		t.Errorf("TableReplicas() err = %v, want %v", err, ErrInvalidReplicas)
+	}
+
+	m := NewMeta()
+	m.AddNode("n1", ":5001")
+	m.AddNode("n2", ":5002")
+	m.AddNode("n3", ":5003")
+
+	nodes := AssignShards(4, m.NodeIds())
+	mapping := Mapping{Shards: 4, Nodes: nodes, Replicas: 1, ReplicaNodes: AssignReplicas(nodes, 1, m.NodeIds())}
+	if want := "[[n2] [n3] [n1] [n2]]"; fmt.Sprint(mapping.ReplicaNodes) != want {
+		t.Fatalf("AssignReplicas() = %v, want %v", mapping.ReplicaNodes, want)
+	}
+	if rs := AssignReplicas(nodes, 5, m.NodeIds()); len(rs[0]) != 2 {
+		t.Errorf("AssignReplicas() = %v, want 2 copies per shard", rs)
+	}
+
+	m.Add("test", mapping)
+	m.RemoveNode("n1")
+	got, _ := m.Get("test")
+	if want := "[[n2] [n3] [] [n2]]"; fmt.Sprint(got.ReplicaNodes) != want {
+		t.Errorf("RemoveNode() replicas = %v, want %v", got.ReplicaNodes, want)
+	}
+	if want := "[[] [n3] [] [n2]]"; fmt.Sprint(m.ReassignUnavailable(got).ReplicaNodes) != want {
+		t.Errorf("ReassignUnavailable() replicas = %v, want %v", m.ReassignUnavailable(got).ReplicaNodes, want)
+	}
+}
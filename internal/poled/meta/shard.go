@@ -0,0 +1,252 @@
+package meta
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const MaxShards = 1024
+
+var (
+	ErrInvalidShards   = errors.New("invalid shard count")
+	ErrInvalidReplicas = errors.New("invalid replica count")
+)
+
+// ShardCount returns the number of shards of the index, mappings created
+// before sharding have a single one.
+func (m *Mapping) ShardCount() int {
+	if m.Shards < 1 {
+		return 1
+	}
+	return m.Shards
+}
+
+// Shard routes a document to its shard by hashing the id.
+func (m *Mapping) Shard(id string) int {
+	shards := m.ShardCount()
+	if shards == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// ShardName returns the name of the bluge index holding the shard, a single
+// shard index keeps the index name so its data stays where it was.
+func (m *Mapping) ShardName(index string, shard int) string {
+	if m.ShardCount() == 1 {
+		return index
+	}
+	return fmt.Sprintf("%s/%d", index, shard)
+}
+
+// ParseShardName returns the index and the shard of a name given by
+// ShardName.
+func ParseShardName(name string) (string, int) {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return name, 0
+	}
+	shard, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return name, 0
+	}
+	return name[:i], shard
+}
+
+func (m *Mapping) ShardNames(index string) []string {
+	rs := make([]string, 0, m.ShardCount())
+	for shard := 0; shard < m.ShardCount(); shard++ {
+		rs = append(rs, m.ShardName(index, shard))
+	}
+	return rs
+}
+
+// ShardNode returns the id of the node hosting the shard, empty when the
+// shard is hosted by the leader.
+func (m *Mapping) ShardNode(shard int) string {
+	if shard < len(m.Nodes) {
+		return m.Nodes[shard]
+	}
+	return ""
+}
+
+// ShardReplicas returns the ids of the nodes keeping a copy of the shard.
+func (m *Mapping) ShardReplicas(shard int) []string {
+	if shard < len(m.ReplicaNodes) {
+		return m.ReplicaNodes[shard]
+	}
+	return nil
+}
+
+// AssignShards spreads the shards over the nodes round robin.
+func AssignShards(shards int, nodes []string) []string {
+	if len(nodes) == 0 {
+		return nil
+	}
+	rs := make([]string, shards)
+	for shard := range rs {
+		rs[shard] = nodes[shard%len(nodes)]
+	}
+	return rs
+}
+
+// AssignReplicas places the copies of each shard on the nodes following the
+// one hosting it, a node never keeps two copies of the same shard.
+func AssignReplicas(assignment []string, replicas int, nodes []string) [][]string {
+	if replicas > len(nodes)-1 {
+		replicas = len(nodes) - 1
+	}
+	if replicas <= 0 || len(assignment) == 0 {
+		return nil
+	}
+	position := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		position[node] = i
+	}
+	rs := make([][]string, len(assignment))
+	for shard, node := range assignment {
+		for i := 1; i <= replicas; i++ {
+			rs[shard] = append(rs[shard], nodes[(position[node]+i)%len(nodes)])
+		}
+	}
+	return rs
+}
+
+// reassignShards moves the shards whose node is not registered any more to
+// the least loaded of the registered nodes, it must give the same result on
+// every node.
+func reassignShards(assignment []string, registered map[string]string, nodes []string) []string {
+	rs := append([]string(nil), assignment...)
+	load := make(map[string]int, len(nodes))
+	for _, node := range rs {
+		load[node]++
+	}
+	for shard, node := range rs {
+		if _, ok := registered[node]; ok || len(nodes) == 0 {
+			continue
+		}
+		target := ""
+		for _, candidate := range nodes {
+			if target == "" || load[candidate] < load[target] {
+				target = candidate
+			}
+		}
+		rs[shard] = target
+		load[target]++
+	}
+	return rs
+}
+
+func (m *Meta) AddNode(id, grpcAddr string) {
+	m.Lock()
+	defer m.Unlock()
+	if m.Nodes == nil {
+		m.Nodes = make(map[string]string)
+	}
+	m.Nodes[id] = grpcAddr
+}
+
+// RemoveNode unregisters the node and drops the copies it kept. The shards
+// it hosted keep it as their node: their documents are on no other node, so
+// they stay unavailable until it joins again or they are restored elsewhere.
+func (m *Meta) RemoveNode(id string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.Nodes, id)
+	for index, mapping := range m.MetaData {
+		if !hostsShards(mapping, id) {
+			continue
+		}
+		mapping = mapping.Clone()
+		for shard, copies := range mapping.ReplicaNodes {
+			kept := copies[:0]
+			for _, node := range copies {
+				if node != id {
+					kept = append(kept, node)
+				}
+			}
+			mapping.ReplicaNodes[shard] = kept
+		}
+		m.MetaData[index] = mapping
+	}
+}
+
+// UnavailableShards returns the shards of the mapping hosted by a node which
+// left the cluster.
+func (m *Meta) UnavailableShards(mapping Mapping) []int {
+	m.RLock()
+	defer m.RUnlock()
+	var rs []int
+	for shard, node := range mapping.Nodes {
+		if _, ok := m.Nodes[node]; node != "" && !ok {
+			rs = append(rs, shard)
+		}
+	}
+	return rs
+}
+
+// ReassignUnavailable hands the unavailable shards of the mapping over to
+// the least loaded nodes, a node taking a shard over drops the copy it kept.
+// It is used when the shards are restored, their documents come back there.
+func (m *Meta) ReassignUnavailable(mapping Mapping) Mapping {
+	m.RLock()
+	defer m.RUnlock()
+	rs := mapping.Clone()
+	rs.Nodes = reassignShards(rs.Nodes, m.Nodes, m.nodeIds())
+	for shard, copies := range rs.ReplicaNodes {
+		kept := copies[:0]
+		for _, node := range copies {
+			if node != rs.ShardNode(shard) {
+				kept = append(kept, node)
+			}
+		}
+		rs.ReplicaNodes[shard] = kept
+	}
+	return rs
+}
+
+func hostsShards(mapping Mapping, id string) bool {
+	for _, node := range mapping.Nodes {
+		if node == id {
+			return true
+		}
+	}
+	for _, copies := range mapping.ReplicaNodes {
+		for _, node := range copies {
+			if node == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NodeAddr returns the grpc address of the node.
+func (m *Meta) NodeAddr(id string) (string, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	rs, ok := m.Nodes[id]
+	return rs, ok
+}
+
+// NodeIds returns the ids of the registered nodes in order.
+func (m *Meta) NodeIds() []string {
+	m.RLock()
+	defer m.RUnlock()
+	return m.nodeIds()
+}
+
+func (m *Meta) nodeIds() []string {
+	rs := make([]string, 0, len(m.Nodes))
+	for id := range m.Nodes {
+		rs = append(rs, id)
+	}
+	sort.Strings(rs)
+	return rs
+}
@@ -8,14 +8,15 @@ import (
 
 type snapshot struct {
 	MetaData map[string]Mapping `json:"metaData"`
+	Nodes    map[string]string  `json:"nodes"`
 }
 
-func newSnapshot(meta map[string]Mapping) *snapshot {
-	return &snapshot{MetaData: meta}
+func newSnapshot(meta map[string]Mapping, nodes map[string]string) *snapshot {
+	return &snapshot{MetaData: meta, Nodes: nodes}
 }
 
 func (s *snapshot) Persist(sink raft.SnapshotSink) error {
-	data, err := json.Marshal(s.MetaData)
+	data, err := json.Marshal(s)
 	if err != nil {
 		return err
 	}
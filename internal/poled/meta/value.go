@@ -0,0 +1,190 @@
+package meta
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidNumeric  = errors.New("invalid numeric value")
+	ErrInvalidBoolean  = errors.New("invalid boolean value")
+	ErrInvalidDatetime = errors.New("invalid datetime value")
+	ErrInvalidGeoPoint = errors.New("invalid geo point value")
+	ErrInvalidVector   = errors.New("invalid vector value")
+)
+
+const (
+	BooleanTrue  = "T"
+	BooleanFalse = "F"
+)
+
+var datetimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// ParseNumeric accepts go numbers and their string forms.
+func ParseNumeric(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	rs, err := strconv.ParseFloat(strings.TrimSpace(fmt.Sprintf("%v", value)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w:%v", ErrInvalidNumeric, value)
+	}
+	return rs, nil
+}
+
+// ParseBoolean accepts go booleans, 0/1 numbers and their string forms.
+func ParseBoolean(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case uint64:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	}
+	rs, err := strconv.ParseBool(strings.TrimSpace(fmt.Sprintf("%v", value)))
+	if err != nil {
+		return false, fmt.Errorf("%w:%v", ErrInvalidBoolean, value)
+	}
+	return rs, nil
+}
+
+// BooleanTerm returns the term a boolean is indexed with.
+func BooleanTerm(v bool) string {
+	if v {
+		return BooleanTrue
+	}
+	return BooleanFalse
+}
+
+// ParseDatetime accepts unix seconds and the usual SQL/RFC3339 layouts,
+// values without zone are read as UTC.
+func ParseDatetime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case int64:
+		return time.Unix(v, 0).UTC(), nil
+	case uint64:
+		return time.Unix(int64(v), 0).UTC(), nil
+	case float64:
+		sec, frac := math.Modf(v)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC(), nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w:%v", ErrInvalidDatetime, value)
+		}
+		return ParseDatetime(f)
+	}
+	str := strings.TrimSpace(fmt.Sprintf("%v", value))
+	for _, layout := range datetimeLayouts {
+		if rs, err := time.Parse(layout, str); err == nil {
+			return rs, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w:%v", ErrInvalidDatetime, value)
+}
+
+// ParseGeoPoint accepts "lat,lon" and "POINT(lon lat)" strings, as well as
+// the decoded JSON forms {"lat": 1, "lon": 2} and [lon, lat].
+func ParseGeoPoint(value interface{}) (lon, lat float64, err error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		value = fmt.Sprintf("%v,%v", v["lat"], v["lon"])
+	case []interface{}:
+		if len(v) == 2 {
+			value = fmt.Sprintf("%v,%v", v[1], v[0])
+		}
+	}
+	str := strings.TrimSpace(fmt.Sprintf("%v", value))
+	var parts []string
+	upper := strings.ToUpper(str)
+	if strings.HasPrefix(upper, "POINT(") && strings.HasSuffix(upper, ")") {
+		parts = strings.Fields(str[len("POINT(") : len(str)-1])
+		if len(parts) == 2 {
+			parts[0], parts[1] = parts[1], parts[0]
+		}
+	} else {
+		parts = strings.Split(str, ",")
+	}
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("%w:%v", ErrInvalidGeoPoint, value)
+	}
+
+	lat, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("%w:%v", ErrInvalidGeoPoint, value)
+	}
+	lon, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("%w:%v", ErrInvalidGeoPoint, value)
+	}
+	return lon, lat, nil
+}
+
+// ParseVector accepts a JSON array of numbers such as "[0.1, 0.2]" or its
+// decoded form, dims is checked when it is positive.
+func ParseVector(value interface{}, dims int) ([]float32, error) {
+	var rs []float32
+	switch v := value.(type) {
+	case []float32:
+		rs = v
+	case []interface{}:
+		rs = make([]float32, len(v))
+		for i := range v {
+			f, err := ParseNumeric(v[i])
+			if err != nil {
+				return nil, fmt.Errorf("%w:%v", ErrInvalidVector, value)
+			}
+			rs[i] = float32(f)
+		}
+	default:
+		if err := json.Unmarshal([]byte(fmt.Sprintf("%v", value)), &rs); err != nil {
+			return nil, fmt.Errorf("%w:%v", ErrInvalidVector, value)
+		}
+	}
+	if dims > 0 && len(rs) != dims {
+		return nil, fmt.Errorf("%w: expect %d dims, got %d", ErrInvalidVector, dims, len(rs))
+	}
+	return rs, nil
+}
+
+// EncodeVector stores every component as a little endian float32.
+func EncodeVector(vector []float32) []byte {
+	rs := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(rs[4*i:], math.Float32bits(v))
+	}
+	return rs
+}
+
+func DecodeVector(value []byte) ([]float32, error) {
+	if len(value)%4 != 0 {
+		return nil, ErrInvalidVector
+	}
+	rs := make([]float32, len(value)/4)
+	for i := range rs {
+		rs[i] = math.Float32frombits(binary.LittleEndian.Uint32(value[4*i:]))
+	}
+	return rs, nil
+}
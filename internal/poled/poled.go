@@ -2,48 +2,94 @@ package poled
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"pole/internal/conf"
 	"pole/internal/pb"
+	"pole/internal/poled/directory"
 	"pole/internal/poled/index"
 	"pole/internal/poled/meta"
 	sqlParser "pole/internal/poled/sql"
 	poleRaft "pole/internal/raft"
 	"pole/internal/util/log"
 
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/search"
 	"github.com/hashicorp/raft"
+	"github.com/pingcap/tidb/parser/mysql"
 	"github.com/pingcap/tidb/parser/types"
 )
 
 type Poled struct {
+	// applied is the index of the last raft log entry of a write applied
+	// here, first for its 64-bit atomic access.
+	applied uint64
 	conf    *conf.Config
 	meta    *meta.Meta
 	readers *index.Readers
 	writers *index.Writers
-	raft    *raft.Raft
+	// replicaReaders and replicaWriters open the copies of shards kept here.
+	replicaReaders *index.Readers
+	replicaWriters *index.Writers
+	// cache keeps the segments of a remote index_uri on the local disk.
+	cache *directory.SegmentCache
+	// tasks tracks the updates and deletes by query run here.
+	tasks *tasks
+	// shardLocks serializes the writes reading the documents of a shard.
+	shardLocks *shardLocks
+	raft       *raft.Raft
 }
 
-func NewPoled(conf *conf.Config, meta *meta.Meta, raft *raft.Raft) (*Poled, error) {
+// NewPoled applies the writes logged through raft from then on, it is
+// created before raft starts replaying the log and given it by SetRaft.
+func NewPoled(conf *conf.Config, meta *meta.Meta) (*Poled, error) {
 
 	rs := &Poled{
-		meta: meta,
-		conf: conf,
-		raft: raft,
+		meta:  meta,
+		conf:  conf,
+		tasks: newTasks(),
+
+		shardLocks: newShardLocks(),
 	}
 
-	rs.readers = index.NewReaders(conf.IndexUri, rs)
-	rs.writers = index.NewWriters(conf.IndexUri, rs)
+	durability, err := index.ParseDurability(conf.Translog.Durability)
+	if err != nil {
+		return nil, err
+	}
+	rs.cache, err = newSegmentCache(conf.DataPath, conf.SegmentCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	rs.writers = index.NewWriters(conf.IndexUri, rs, index.WithWriterCache(rs.cache),
+		index.WithTranslog(translogPath(conf.DataPath, "primary"), durability, conf.Translog.SyncInterval, rs.replayTranslog))
+	rs.readers = index.NewReaders(conf.IndexUri, rs, index.WithReaderCache(rs.cache),
+		index.WithWriters(rs.writers), index.WithRefreshInterval(conf.RefreshInterval))
+	rs.replicaWriters = index.NewWriters(replicaUri(conf.DataPath), rs,
+		index.WithTranslog(translogPath(conf.DataPath, "replica"), durability, conf.Translog.SyncInterval, rs.replayTranslog))
+	rs.replicaReaders = index.NewReaders(replicaUri(conf.DataPath), rs,
+		index.WithWriters(rs.replicaWriters), index.WithRefreshInterval(conf.RefreshInterval))
+	meta.SetWriteApplier(rs.applyWrite)
 	return rs, nil
 }
 
+// SetRaft sets the raft the statements are run through, it is set before
+// the first one.
+func (p *Poled) SetRaft(raft *raft.Raft) {
+	p.raft = raft
+}
+
 func (p *Poled) Close() error {
 	lg := log.WithField("module", "poleClose")
-	if p.isLearder() {
-		for _, writer := range p.writers.All() {
-			err := writer.Close()
-			lg.Error(err)
+	p.readers.Close()
+	p.replicaReaders.Close()
+	// followers hold the writers of the shards they host
+	for _, writers := range []*index.Writers{p.writers, p.replicaWriters} {
+		for _, writer := range writers.All() {
+			if err := writer.Close(); err != nil {
+				lg.Error(err)
+			}
 		}
 	}
 	lg.Info("closed")
@@ -58,19 +104,75 @@ func (p *Poled) isLearder() bool {
 	return p.raft.State() == raft.Leader
 }
 
-func (p *Poled) Exec(sql string) result {
-	stmt, err := sqlParser.Parse(sql)
+// Exec runs the ;-separated statements in order and stops at the first
+// failed one, several statements return a result per statement and a rolled
+// back transaction none.
+func (p *Poled) Exec(sql string, opts ...ExecOption) result {
+	stmts, err := sqlParser.ParseAll(sql)
 	if err != nil {
 		return newGeneralResult(err)
 	}
 
+	options := newExecOptions(opts)
+	if len(stmts) == 1 && stmts[0].ActionType != sqlParser.StmtTypeTransaction {
+		return p.exec(stmts[0], options)
+	}
+	if len(options.shards) > 0 && len(stmts) > 1 {
+		return newGeneralResult(fmt.Errorf("%w: several statements routed to shards", ErrSyntaxNotSupported))
+	}
+	rs := &multiResult{}
+	for _, stmt := range stmts {
+		r := p.exec(stmt, options)
+		rs.add(stmt, r)
+		if r.Error() != nil {
+			break
+		}
+	}
+	return rs
+}
+
+func (p *Poled) exec(stmt *sqlParser.SqlVistor, options *execOptions) result {
 	if stmt.ActionType == sqlParser.StmtTypeSelect {
-		return p.execSelect(stmt)
+		return p.execSelect(stmt, options)
+	}
+	if stmt.Explain {
+		return newGeneralResult(fmt.Errorf("%w: explain only supports select", ErrSyntaxNotSupported))
+	}
+
+	if len(options.shards) > 0 {
+		return p.execShards(stmt, options)
+	}
+
+	if stmt.ActionType == sqlParser.StmtTypeRefresh {
+		return p.execRefresh(stmt, options)
+	}
+
+	// ids are generated by the node receiving the insert, the sql carrying
+	// them is what is forwarded, logged and replicated
+	generated, ids, err := stmt.GenerateIds()
+	if err != nil {
+		return newGeneralResult(err)
+	}
+	if len(ids) > 0 {
+		return withIds(p.exec(generated, options), ids)
+	}
+
+	// the writes of a transaction are atomic per index
+	if parts := stmt.Split(); len(parts) > 1 {
+		for _, part := range parts {
+			if rs := p.exec(part, options); rs.Error() != nil {
+				return rs
+			}
+		}
+		return newGeneralResult(nil)
 	}
 
 	if p.raft.State() != raft.Leader {
-		rs := p.execByRpc(sql)
-		p.readers.Delete(stmt.TableName)
+		req := newExecRequest(stmt.Sql, nil, false)
+		req.Refresh = string(options.refresh)
+		req.Task = options.task
+		rs := p.execByRpc(p.meta.Leader(), req)
+		p.markDirty(stmt.TableName)
 		return rs
 	}
 
@@ -79,147 +181,242 @@ func (p *Poled) Exec(sql string) result {
 		return p.execCreate(stmt)
 	case sqlParser.StmtTypeDrop:
 		return p.execDrop(stmt)
-	case sqlParser.StmtTypeInsert:
-		return p.execInsert(stmt)
-	case sqlParser.StmtTypeDelete:
-		return p.execDelete(stmt)
-	case sqlParser.StmtTypeUpdate:
-		return p.execUpdate(stmt)
+	case sqlParser.StmtTypeInsert, sqlParser.StmtTypeDelete, sqlParser.StmtTypeUpdate, sqlParser.StmtTypeTransaction:
+		if stmt.ByQuery() {
+			return p.execByQuery(stmt, options)
+		}
+		if conf.GetRaftWrites() {
+			return p.logWrite(stmt, options)
+		}
+		return p.execWrite(stmt, options)
+	case sqlParser.StmtTypeAlter:
+		return p.execAlter(stmt)
+	case sqlParser.StmtTypeBackup:
+		return p.execBackup(stmt)
+	case sqlParser.StmtTypeRestore:
+		return p.execRestore(stmt, options)
 	}
 	return newGeneralResult(ErrSyntaxNotSupported)
 }
-func (p *Poled) execSelect(stmt *sqlParser.SqlVistor) result {
-	idx := stmt.TableName
-	lg := log.WithField("module", "exec select").WithField("index", idx)
-	meta, exists := p.meta.Get(idx)
-	if !exists {
-		lg.Error(ErrIndexNotFound)
-		return newGeneralResult(ErrIndexNotFound)
-	}
 
-	reader, exists := p.readers.Get(idx)
-	if !exists {
-		return newGeneralResult(ErrReaderNotFound)
-	}
-	req, err := stmt.BuildRequest(meta)
+func (p *Poled) Query(sql string, opts ...ExecOption) (*selectResp, error) {
+	stmt, err := sqlParser.Parse(sql)
 	if err != nil {
-		return newGeneralResult(err)
+		return nil, err
 	}
-	iter, err := reader.Search(context.Background(), req)
-	if err != nil {
-		return newGeneralResult(err)
+
+	if stmt.ActionType != sqlParser.StmtTypeSelect {
+		return nil, ErrNotSelectStmt
 	}
-	return newSelectResult(iter, meta, stmt.ColNames, stmt.SelectAll)
+
+	rs := p.execSelect(stmt, newExecOptions(opts))
+	if err := rs.Error(); err != nil {
+		return nil, err
+	}
+	return rs.(*selectResp), nil
 }
 
-func (p *Poled) execDelete(stmt *sqlParser.SqlVistor) result {
+func (p *Poled) execSelect(stmt *sqlParser.SqlVistor, options *execOptions) result {
+	if options.consistency == ReadConsistencyLeader && !p.isLearder() {
+		return p.queryByRpc(p.meta.Leader(), stmt.Sql)
+	}
+
 	idx := stmt.TableName
-	lg := log.WithField("module", "delete_index").WithField("index", idx)
-	meta, exists := p.meta.Get(idx)
+	lg := log.WithField("module", "exec select").WithField("index", idx)
+	mapping, exists := p.meta.Get(idx)
 	if !exists {
 		lg.Error(ErrIndexNotFound)
 		return newGeneralResult(ErrIndexNotFound)
 	}
 
-	writer, exists := p.writers.Get(idx)
-	if !exists {
-		lg.Error(ErrWriterNotFound)
-		return newGeneralResult(ErrWriterNotFound)
+	shardReaders, err := p.shardReaders(idx, mapping, options)
+	if err != nil {
+		return newGeneralResult(err)
 	}
-
-	batch, err := stmt.BuildDeleteBatch(meta)
+	defer releaseReaders(shardReaders)
+	readers := make([]*bluge.Reader, 0, len(shardReaders))
+	for _, reader := range shardReaders {
+		readers = append(readers, reader.Reader)
+	}
+	req, err := stmt.BuildRequest(mapping)
 	if err != nil {
-		lg.Error(err)
 		return newGeneralResult(err)
 	}
 
-	if err := writer.Batch(batch); err != nil {
-		lg.Error(ErrBatchFailed)
+	// the shards are searched together, the collector merges their top hits,
+	// totals and aggregations
+	var iter search.DocumentMatchIterator
+	if len(readers) == 1 {
+		iter, err = readers[0].Search(context.Background(), req)
+	} else {
+		iter, err = bluge.MultiSearch(context.Background(), req, readers...)
+	}
+	if err != nil {
 		return newGeneralResult(err)
 	}
-
-	p.readers.Delete(idx)
-
-	return newGeneralResult(nil)
+	return newSelectResult(iter, mapping, stmt)
 }
 
-func (p *Poled) execUpdate(stmt *sqlParser.SqlVistor) result {
+// execWrite applies an insert, update, delete or transaction to the shards
+// hosted here and their copies, and on the leader routes the others to their
+// nodes. The shards option restricts the write to the shards the leader
+// routed here.
+func (p *Poled) execWrite(stmt *sqlParser.SqlVistor, options *execOptions) result {
 	idx := stmt.TableName
-	lg := log.WithField("module", "update_index").WithField("index", idx)
-	meta, exists := p.meta.Get(idx)
+	lg := log.WithField("module", fmt.Sprintf("%s_index", stmt.ActionType)).WithField("index", idx)
+	mapping, exists := p.meta.Get(idx)
 	if !exists {
 		lg.Error(ErrIndexNotFound)
 		return newGeneralResult(ErrIndexNotFound)
 	}
 
-	writer, exists := p.writers.Get(idx)
-	if !exists {
-		lg.Error(ErrWriterNotFound)
-		return newGeneralResult(ErrWriterNotFound)
-	}
-
-	batch, err := stmt.BuildUpdateBatch(meta)
+	// the writes are routed by their ids, an update is merged into the stored
+	// documents by the node writing its shard
+	writes, err := stmt.Writes(mapping, nil)
 	if err != nil {
 		lg.Error(err)
 		return newGeneralResult(err)
 	}
-
-	if err := writer.Batch(batch); err != nil {
-		lg.Error(ErrBatchFailed)
-		return newGeneralResult(err)
+	writers, readers := p.writers, p.readers
+	if options.replica {
+		writers, readers = p.replicaWriters, p.replicaReaders
 	}
-
-	p.readers.Delete(idx)
-
-	return newGeneralResult(nil)
-
-}
-
-func (p *Poled) execInsert(stmt *sqlParser.SqlVistor) result {
-	idx := stmt.TableName
-	lg := log.WithField("module", "insert_index").WithField("index", idx)
-	meta, exists := p.meta.Get(idx)
-	if !exists {
-		lg.Error(ErrIndexNotFound)
-		return newGeneralResult(ErrIndexNotFound)
+	hosted := func(shard int) bool {
+		if len(options.shards) > 0 {
+			return containsShard(options.shards, shard)
+		}
+		_, ok := p.shardHost(mapping, shard)
+		return ok
+	}
+	routed := sqlParser.ShardWrites(mapping, writes)
+	if len(options.shards) == 0 {
+		written := func(shard int) bool {
+			_, ok := routed[shard]
+			return ok
+		}
+		if err := p.checkAvailable(mapping, written); err != nil {
+			lg.Error(err)
+			return newGeneralResult(err)
+		}
 	}
+	merged := routed
+	if stmt.ReadsDocuments() {
+		// the shards written here stay locked until the merged documents are
+		// written, so a write reads what the one before it wrote
+		names := make([]string, 0, len(routed))
+		for shard := range routed {
+			if hosted(shard) {
+				names = append(names, writeLockName(mapping.ShardName(idx, shard), options.replica))
+			}
+		}
+		defer p.shardLocks.lock(names)()
+		stored := newStoredDocuments(writers, idx, mapping, hosted)
+		defer stored.close()
+		writes, err = stmt.Writes(mapping, stored.load)
+		if err != nil {
+			lg.Error(err)
+			return newGeneralResult(err)
+		}
+		merged = sqlParser.ShardWrites(mapping, writes)
+	}
+
+	remote := make(map[string][]int)
+	// the leader reads the shards it routed here from the index storage, it
+	// refreshes them once the write is persisted
+	routedHere := len(options.shards) > 0 && !options.replica && options.refresh != RefreshNone
+	inserted := newInsertResult(len(writes))
+	inserted.routed = len(options.shards) > 0
+	applied := make([]int, 0, len(merged))
+	defer func() {
+		for _, shard := range applied {
+			readers.MarkDirty(mapping.ShardName(idx, shard))
+		}
+	}()
+	for shard := range routed {
+		if len(options.shards) > 0 {
+			if !containsShard(options.shards, shard) {
+				continue
+			}
+		} else if addr, local := p.shardHost(mapping, shard); !local {
+			remote[addr] = append(remote[addr], shard)
+			continue
+		}
+		inserted.add(merged[shard])
+		if !written(merged[shard]) {
+			continue
+		}
 
-	writer, exists := p.writers.Get(idx)
-	if !exists {
-		lg.Error(ErrWriterNotFound)
-		return newGeneralResult(ErrWriterNotFound)
+		writer, exists := writers.Get(mapping.ShardName(idx, shard))
+		if !exists {
+			lg.Error(ErrWriterNotFound)
+			return newGeneralResult(ErrWriterNotFound)
+		}
+		record, err := newWriteRecord(stmt, merged[shard])
+		if err != nil {
+			return newGeneralResult(err)
+		}
+		logBatch := writer.LogBatch
+		if routedHere {
+			logBatch = writer.LogBatchPersisted
+		}
+		if err := logBatch(record, sqlParser.NewBatch(merged[shard])); err != nil {
+			lg.Error(ErrBatchFailed)
+			return newGeneralResult(err)
+		}
+		applied = append(applied, shard)
 	}
 
-	batch, err := stmt.BuildInsertBatch(meta)
-	if err != nil {
+	if !options.replica {
+		if err := p.replicate(stmt, mapping, applied, options.refresh); err != nil {
+			lg.Error(err)
+			return newGeneralResult(err)
+		}
+	}
+	refreshed := applied
+	for addr, hosted := range remote {
+		req := newExecRequest(stmt.Sql, hosted, false)
+		req.Refresh = string(options.refresh)
+		rs := p.execByRpc(addr, req)
+		if rs.Error() != nil {
+			return rs
+		}
+		if err := inserted.addRemote(rs); err != nil {
+			return newGeneralResult(err)
+		}
+		refreshed = append(refreshed[:len(refreshed):len(refreshed)], hosted...)
+	}
+	if err := p.refreshShards(readers, idx, mapping, refreshed, options.refresh); err != nil {
 		lg.Error(err)
 		return newGeneralResult(err)
 	}
-	if err := writer.Batch(batch); err != nil {
-		lg.Error(ErrBatchFailed)
-		return newGeneralResult(err)
+	if stmt.ActionType == sqlParser.StmtTypeInsert {
+		inserted.sort()
+		return inserted
 	}
-
-	p.readers.Delete(idx)
-	lg.Info("insert success:", batch)
 	return newGeneralResult(nil)
 }
 
-func (p *Poled) execByRpc(sql string) result {
-	lg := log.WithField("module", "execByRpc").WithField("state", p.raft.State().String()).WithField("leaderGrpcAddr", p.meta.Leader())
+// execByRpc runs the statement on another node, req carries the shards when
+// a write is routed to the node hosting them or keeping their copies.
+func (p *Poled) execByRpc(addr string, req *pb.ExecRequest) result {
+	lg := log.WithField("module", "execByRpc").WithField("state", p.raft.State().String()).WithField("grpcAddr", addr)
 
-	client, err := poleRaft.GetClientConn(p.meta.Leader())
+	client, err := poleRaft.GetClientConn(addr)
 	if err != nil {
 		return newGeneralResult(err)
 	}
 
 	cc := pb.NewPoleClient(client)
 
-	if _, err := cc.Exec(context.Background(), &pb.ExecRequest{Sql: sql}); err != nil {
+	resp, err := cc.Exec(context.Background(), req)
+	if err != nil {
 		lg.Error("failed to execute ,err: ", err)
 		return newGeneralResult(err)
 	}
 	lg.Info("exec success")
+	if json.Valid([]byte(resp.Message)) {
+		return &rpcResult{message: json.RawMessage(resp.Message)}
+	}
 	return newGeneralResult(nil)
 }
 
@@ -229,16 +426,36 @@ func (p *Poled) execCreate(stmt *sqlParser.SqlVistor) result {
 	if p.meta.Exists(idx) {
 		return newGeneralResult(ErrIndexExist)
 	}
-	fields := meta.Mapping{Properties: map[string]meta.FiledOptions{}}
+	shards, err := stmt.ShardCount()
+	if err != nil {
+		return newGeneralResult(err)
+	}
+	replicas, err := meta.TableReplicas(stmt.TableComment)
+	if err != nil {
+		return newGeneralResult(err)
+	}
+	fields := meta.Mapping{Properties: map[string]meta.FiledOptions{}, Shards: shards, Replicas: replicas, Comment: stmt.TableComment}
+	if shards > 1 || replicas > 0 {
+		nodes := p.meta.NodeIds()
+		fields.Nodes = meta.AssignShards(shards, nodes)
+		fields.ReplicaNodes = meta.AssignReplicas(fields.Nodes, replicas, nodes)
+	}
 	for _, column := range stmt.ColNames {
-		fields.Properties[column.Name] = meta.FiledOptions{
-			Type:   parseFieldType(column.Typ),
-			Option: meta.Option{},
+		options, err := newFieldOptions(column, stmt.TableComment)
+		if err != nil {
+			return newGeneralResult(err)
 		}
+		fields.Properties[column.Name] = options
 	}
 
-	if _, ok := p.writers.Get(idx); !ok {
-		return newGeneralResult(ErrWriterCreateFailed)
+	remote := p.remoteShards(fields)
+	for shard, name := range fields.ShardNames(idx) {
+		if _, local := p.shardHost(fields, shard); !local {
+			continue
+		}
+		if _, ok := p.writers.Get(name); !ok {
+			return newGeneralResult(ErrWriterCreateFailed)
+		}
 	}
 
 	cmd, err := meta.NewAddLogDataCmd(idx, fields)
@@ -246,33 +463,232 @@ func (p *Poled) execCreate(stmt *sqlParser.SqlVistor) result {
 		return newGeneralResult(err)
 	}
 
-	p.raft.Apply(cmd, time.Second)
+	// the mapping is applied before the statement returns, a following
+	// insert finds it
+	if err := p.raft.Apply(cmd, time.Second).Error(); err != nil {
+		return newGeneralResult(err)
+	}
+
+	for addr, hosted := range remote {
+		if rs := p.execByRpc(addr, newExecRequest(stmt.Sql, hosted, false)); rs.Error() != nil {
+			return rs
+		}
+	}
+	if err := p.replicate(stmt, fields, allShards(fields), RefreshNone); err != nil {
+		return newGeneralResult(err)
+	}
 
 	return newGeneralResult(nil)
 }
 
 func (p *Poled) execDrop(stmt *sqlParser.SqlVistor) result {
 	idx := stmt.TableName
-	if !p.meta.Exists(idx) {
+	mapping, exists := p.meta.Get(idx)
+	if !exists {
 		return newGeneralResult(ErrIndexNotFound)
 	}
 
+	// the nodes hosting shards release them before the mapping goes away
+	for addr, hosted := range p.remoteShards(mapping) {
+		if rs := p.execByRpc(addr, newExecRequest(stmt.Sql, hosted, false)); rs.Error() != nil {
+			return rs
+		}
+	}
+	if err := p.replicate(stmt, mapping, allShards(mapping), RefreshNone); err != nil {
+		return newGeneralResult(err)
+	}
+
 	cmd, err := meta.NewDeleteLogDataCmd(idx)
 	if err != nil {
 		return newGeneralResult(err)
 	}
-	p.raft.Apply(cmd, time.Second)
-	p.readers.Delete(idx)
-	p.writers.Delete(idx)
+	if err := p.raft.Apply(cmd, time.Second).Error(); err != nil {
+		return newGeneralResult(err)
+	}
+	p.releaseShards(idx, mapping, nil)
 	return newGeneralResult(nil)
 }
 
-func parseFieldType(columnType types.EvalType) meta.FieldType {
-	if columnType == types.ETString {
+func (p *Poled) execAlter(stmt *sqlParser.SqlVistor) result {
+	idx := stmt.TableName
+	lg := log.WithField("module", "alter_index").WithField("index", idx)
+	mapping, exists := p.meta.Get(idx)
+	if !exists {
+		lg.Error(ErrIndexNotFound)
+		return newGeneralResult(ErrIndexNotFound)
+	}
+
+	// every spec is checked against the fields the documents were indexed
+	// with, so dropping and adding a column back can not change its type
+	fields := mapping.Clone()
+	populated := make(map[string]bool)
+	checkChange := func(name string, options meta.FiledOptions) error {
+		indexed, exists := mapping.Properties[name]
+		if !exists {
+			return nil
+		}
+		if _, checked := populated[name]; !checked {
+			populated[name] = p.fieldPopulated(idx, name)
+		}
+		if !populated[name] {
+			return nil
+		}
+		return checkFieldChange(name, indexed, options)
+	}
+	for _, spec := range stmt.AlterSpecs {
+		if err := spec.Validate(); err != nil {
+			return newGeneralResult(err)
+		}
+		name := spec.Col.Name
+		_, exists := fields.Properties[name]
+		switch spec.Type {
+		case sqlParser.AlterTypeAddColumn:
+			if exists {
+				if spec.IfMissing {
+					continue
+				}
+				return newGeneralResult(fmt.Errorf("%w:%s", ErrFieldExist, name))
+			}
+			options, err := newFieldOptions(spec.Col, mapping.Comment)
+			if err != nil {
+				return newGeneralResult(err)
+			}
+			if err := checkChange(name, options); err != nil {
+				return newGeneralResult(err)
+			}
+			fields.Properties[name] = options
+		case sqlParser.AlterTypeDropColumn:
+			if !exists {
+				if spec.IfExists {
+					continue
+				}
+				return newGeneralResult(fmt.Errorf("%w:%s", meta.ErrFieldNotFound, name))
+			}
+			if name == "id" {
+				return newGeneralResult(ErrDropIdentifier)
+			}
+			delete(fields.Properties, name)
+		case sqlParser.AlterTypeModifyColumn:
+			if !exists {
+				if spec.IfExists {
+					continue
+				}
+				return newGeneralResult(fmt.Errorf("%w:%s", meta.ErrFieldNotFound, name))
+			}
+			options, err := newFieldOptions(spec.Col, mapping.Comment)
+			if err != nil {
+				return newGeneralResult(err)
+			}
+			if err := checkChange(name, options); err != nil {
+				return newGeneralResult(err)
+			}
+			fields.Properties[name] = options
+		}
+	}
+
+	cmd, err := meta.NewAlterLogDataCmd(idx, fields)
+	if err != nil {
+		return newGeneralResult(err)
+	}
+
+	af := p.raft.Apply(cmd, time.Second)
+	if err := af.Error(); err != nil {
+		lg.Error(err)
+		return newGeneralResult(err)
+	}
+	if err, ok := af.Response().(error); ok {
+		return newGeneralResult(err)
+	}
+
+	lg.Info("alter success")
+	return newGeneralResult(nil)
+}
+
+// checkFieldChange rejects changing the type or the analyzer of a populated
+// field, its documents stay indexed with the previous ones.
+func checkFieldChange(name string, from, to meta.FiledOptions) error {
+	if from.Type != to.Type {
+		return fmt.Errorf("%w: %s from %s to %s", ErrIncompatibleFieldType, name, from.Type, to.Type)
+	}
+	if from.Analyzer != to.Analyzer {
+		return fmt.Errorf("%w: %s from %q to %q", ErrIncompatibleAnalyzer, name, from.Analyzer, to.Analyzer)
+	}
+	return nil
+}
+
+// fieldPopulated reports whether any document of the index holds the field.
+func (p *Poled) fieldPopulated(idx, field string) bool {
+	mapping, _ := p.meta.Get(idx)
+	for shard, name := range mapping.ShardNames(idx) {
+		_, local := p.shardHost(mapping, shard)
+		fields, err := p.shardFields(name, local)
+		if err != nil {
+			return true
+		}
+		for _, name := range fields {
+			if name == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shardFields lists the fields of a shard, they are read from the writer when
+// the shard is hosted here.
+func (p *Poled) shardFields(name string, local bool) ([]string, error) {
+	if !local {
+		reader, exists := p.readers.Get(name)
+		if !exists {
+			return nil, ErrReaderNotFound
+		}
+		defer reader.Release()
+		return reader.Fields()
+	}
+	writer, exists := p.writers.Get(name)
+	if !exists {
+		return nil, nil
+	}
+	reader, err := writer.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return reader.Fields()
+}
+
+func newFieldOptions(column sqlParser.Col, tableComment string) (meta.FiledOptions, error) {
+	options, err := meta.NewFiledOptions(parseFieldType(column), tableComment, column.Comment)
+	if err != nil {
+		return options, fmt.Errorf("column %s: %w", column.Name, err)
+	}
+	options.Required = column.NotNull
+	options.Default = column.Default
+	if err := options.Validate(); err != nil {
+		return options, fmt.Errorf("column %s: %w", column.Name, err)
+	}
+	return options, nil
+}
+
+func parseFieldType(column sqlParser.Col) meta.FieldType {
+	switch column.Tp {
+	case mysql.TypeTiny, mysql.TypeBit:
+		if column.Flen == 1 {
+			return meta.FieldTypeBoolean
+		}
+	case mysql.TypeDatetime, mysql.TypeTimestamp, mysql.TypeDate:
+		return meta.FieldTypeDatetime
+	case mysql.TypeString, mysql.TypeEnum, mysql.TypeSet:
+		return meta.FieldTypeKeyword
+	case mysql.TypeJSON:
+		return meta.FieldTypeText
+	}
+
+	if column.Typ == types.ETString {
 		return meta.FieldTypeText
 	}
 
-	if !columnType.IsStringKind() {
+	if !column.Typ.IsStringKind() {
 		return meta.FieldTypeNumeric
 	}
 
@@ -1,30 +1,86 @@
 package poled
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"pole/internal/conf"
+	"pole/internal/pb"
 	"pole/internal/poled/meta"
+	sqlParser "pole/internal/poled/sql"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc"
 )
 
-func mustNewPoled() *Poled {
+// newTestRaft starts a single node raft cluster in memory and waits until it
+// leads.
+func newTestRaft(t *testing.T, fsm *meta.Meta) *raft.Raft {
+	return newTestRaftWithStore(t, fsm, raft.NewInmemStore())
+}
+
+// newTestRaftWithStore keeps the raft log in store, so a test can read it.
+func newTestRaftWithStore(t *testing.T, fsm *meta.Meta, store *raft.InmemStore) *raft.Raft {
+	config := raft.DefaultConfig()
+	config.LocalID = "test"
+	config.HeartbeatTimeout = 50 * time.Millisecond
+	config.ElectionTimeout = 50 * time.Millisecond
+	config.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.CommitTimeout = 5 * time.Millisecond
+	config.LogOutput = ioutil.Discard
+
+	addr, transport := raft.NewInmemTransport("")
+	r, err := raft.NewRaft(config, fsm, store, store, raft.NewInmemSnapshotStore(), transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = r.Shutdown().Error() })
+	if err := r.BootstrapCluster(raft.Configuration{Servers: []raft.Server{{ID: config.LocalID, Address: addr}}}).Error(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-r.LeaderCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("raft leader not elected")
+	}
+	return r
+}
+
+// mustNewPoled runs a poled leading its own raft cluster over a mem:// index
+// uri, nothing is written outside of the test dir.
+func mustNewPoled(t *testing.T) *Poled {
 	conf := conf.GetConfig()
-	conf.IndexUri = "file:///var/www/go/pole/tests/indexes"
-	pd, err := NewPoled(conf, &meta.Meta{}, nil)
+	conf.IndexUri = "mem://" + t.Name()
+	conf.DataPath = t.TempDir()
+	fsm := meta.NewMeta()
+	pd, err := NewPoled(conf, fsm)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
+	pd.SetRaft(newTestRaft(t, fsm))
+	t.Cleanup(func() { _ = pd.Close() })
 	return pd
 }
 
 func TestNewPoled(t *testing.T) {
-	pd := mustNewPoled()
+	pd := mustNewPoled(t)
 	if pd == nil {
 		t.Fatal("init Poled failed")
 	}
 }
 
 func TestExec(t *testing.T) {
-	pd := mustNewPoled()
+	pd := mustNewPoled(t)
 	if pd == nil {
 		t.Fatal("init Poled failed")
 	}
@@ -51,7 +107,7 @@ func TestExec(t *testing.T) {
 		},
 		{
 			name: "insert2",
-			sql:  "insert into test set id=3,name='hello'",
+			sql:  "replace into test set id=3,name='hello'",
 			want: nil,
 		},
 		{
@@ -94,6 +150,21 @@ func TestExec(t *testing.T) {
 			sql:  "select * from test where id in(1,2,3)",
 			want: nil,
 		},
+		{
+			name: "multi",
+			sql:  "insert into test set id=4,name='a'; update test set name='b' where id=4; select * from test where id=4",
+			want: nil,
+		},
+		{
+			name: "transaction",
+			sql:  "begin; insert into test set id=5,name='a'; update test set name='b' where id=5; delete from test where id=4; commit",
+			want: nil,
+		},
+		{
+			name: "rollback",
+			sql:  "begin; delete from test where id=5; rollback",
+			want: nil,
+		},
 		{
 			name: "drop",
 			sql:  "drop table test",
@@ -113,3 +184,751 @@ func TestExec(t *testing.T) {
 		})
 	}
 }
+
+func TestBulkDecoder(t *testing.T) {
+	mapping := meta.Mapping{Properties: map[string]meta.FiledOptions{
+		"name":  {Type: meta.FieldTypeText},
+		"price": {Type: meta.FieldTypeNumeric},
+	}}
+	tests := []struct {
+		name   string
+		format BulkFormat
+		data   string
+		want   []error
+	}{
+		{
+			name:   "ndjson",
+			format: BulkFormatNDJSON,
+			data:   "{\"id\": 1, \"name\": \"hello\", \"price\": 1.5}\n\n{\"_id\": \"b\", \"price\": \"x\"}\n{bad\n{\"name\": \"no id\"}\n{\"id\": 3, \"color\": \"red\"}",
+			want:   []error{nil, meta.ErrInvalidNumeric, ErrBulkDocument, meta.ErrIdentifierRequired, meta.ErrFieldNotFound},
+		},
+		{
+			name:   "csv",
+			format: BulkFormatCSV,
+			data:   "id,name,price\n1,hello,2\n2,\"multi\nline\",\n3,short\n4,world,abc\n",
+			want:   []error{nil, nil, ErrBulkDocument, meta.ErrInvalidNumeric},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder, err := newBulkDecoder(tt.format, strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []error
+			for {
+				row, err := decoder.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if row.err != nil {
+					got = append(got, row.err)
+					continue
+				}
+				_, err = mapping.MakeDocument(row.id, row.values)
+				got = append(got, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d rows %v, want %d", len(got), got, len(tt.want))
+			}
+			for i := range got {
+				if !errors.Is(got[i], tt.want[i]) {
+					t.Errorf("row %d error = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBackup(t *testing.T) {
+	pd := mustNewPoled(t)
+	repository := "mem://" + t.Name() + "/backups"
+	exec := func(sql string) result {
+		rs := pd.Exec(sql, WithRefresh(RefreshImmediate))
+		if rs.Error() != nil {
+			t.Fatalf("Exec(%s) err = %v", sql, rs.Error())
+		}
+		return rs
+	}
+	count := func() int64 {
+		rs, err := pd.Query("select * from test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.Hits.Total
+	}
+	// closing the writer persists the inserts for the backup
+	persist := func() {
+		mapping, _ := pd.meta.Get("test")
+		for _, name := range mapping.ShardNames("test") {
+			_ = pd.writers.Close(name)
+		}
+	}
+
+	exec("create table test (id int(10) not null,name varchar(255) not null)")
+	exec("insert into test (id,name) values (1,'hello'),(2,'world')")
+	persist()
+
+	first := exec("backup table test to '" + repository + "'").Resp().(*backupResp)
+	if first.Snapshot != 1 || first.Segments == 0 || first.Copied != first.Segments {
+		t.Errorf("first backup = %+v, want snapshot 1 with every segment copied", first)
+	}
+	second := exec("backup table test to '" + repository + "'").Resp().(*backupResp)
+	if second.Snapshot != 2 || second.Segments != first.Segments || second.Copied != 0 {
+		t.Errorf("second backup = %+v, want snapshot 2 reusing every segment", second)
+	}
+
+	exec("insert into test (id,name) values (3,'again')")
+	persist()
+	if got := count(); got != 3 {
+		t.Fatalf("count = %d, want 3", got)
+	}
+	exec("restore table test from '" + repository + "'")
+	if got := count(); got != 2 {
+		t.Errorf("count after restore = %d, want 2", got)
+	}
+
+	exec("drop table test")
+	exec("restore table test from '" + repository + "#1'")
+	if got := count(); got != 2 {
+		t.Errorf("count after restore into a new index = %d, want 2", got)
+	}
+	if rs := pd.Exec("restore table missing from '" + repository + "'"); !errors.Is(rs.Error(), ErrSnapshotNotFound) {
+		t.Errorf("restore missing err = %v, want %v", rs.Error(), ErrSnapshotNotFound)
+	}
+}
+
+func TestQueryStream(t *testing.T) {
+	pd := mustNewPoled(t)
+	pd.Exec("create table test (id int(10) not null,n int) partition by hash(id) partitions 3")
+	values := make([]string, 0, 250)
+	for i := 0; i < 250; i++ {
+		values = append(values, "("+strconv.Itoa(i)+","+strconv.Itoa(i%125)+")")
+	}
+	if rs := pd.Exec("insert into test (id,n) values "+strings.Join(values, ","), WithRefresh(RefreshImmediate)); rs.Error() != nil {
+		t.Fatal(rs.Error())
+	}
+
+	var summary *SelectSummary
+	var pages []int
+	var ns []float64
+	seen := make(map[string]bool)
+	err := pd.QueryStream("select * from test order by n limit 230 offset 5", func(rs *SelectSummary) error {
+		summary = rs
+		return nil
+	}, func(hits []Hit) error {
+		pages = append(pages, len(hits))
+		for _, hit := range hits {
+			if seen[hit.ID] {
+				t.Errorf("hit %s streamed twice", hit.ID)
+			}
+			seen[hit.ID] = true
+			ns = append(ns, hit.Source["n"].(float64))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary == nil || summary.Total != 250 {
+		t.Errorf("summary = %+v, want 250 matches", summary)
+	}
+	if !reflect.DeepEqual(pages, []int{streamPageSize, streamPageSize, 30}) {
+		t.Errorf("pages = %v, want 230 hits by pages of %d", pages, streamPageSize)
+	}
+	// n holds every value twice, the offset skips 0, 0, 1, 1 and 2
+	for i, n := range ns {
+		if want := float64((i + 5) / 2); n != want {
+			t.Fatalf("hit %d n = %v, want %v", i, n, want)
+		}
+	}
+}
+
+func TestWriteByQuery(t *testing.T) {
+	pd := mustNewPoled(t)
+	exec := func(sql string, opts ...ExecOption) result {
+		rs := pd.Exec(sql, append(opts, WithRefresh(RefreshImmediate))...)
+		if rs.Error() != nil {
+			t.Fatalf("Exec(%s) err = %v", sql, rs.Error())
+		}
+		return rs
+	}
+	count := func(sql string) int64 {
+		rs, err := pd.Query(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.Hits.Total
+	}
+
+	exec("create table test (id int(10) not null,status varchar(255) not null,price int) partition by hash(id) partitions 2")
+	exec("insert into test (id,status,price) values (1,'expired',1),(2,'active',2),(3,'expired',3),(4,'active',4),(5,'expired',5)")
+
+	updated := exec("update test set status='archived' where status='expired' and price < 5", WithTask("archive")).Resp().(*ByQueryResp)
+	if updated.Matched != 2 || updated.Updated != 2 || updated.Batches != 1 {
+		t.Errorf("update by query = %+v, want 2 documents updated in a batch", updated)
+	}
+	if task, ok := pd.Task("archive"); !ok || !task.Done || task.Progress.Updated != 2 {
+		t.Errorf("Task(archive) = %+v, %v, want done with 2 updated", task, ok)
+	}
+	if got := count("select * from test where status='archived'"); got != 2 {
+		t.Errorf("archived = %d, want 2", got)
+	}
+
+	deleted := exec("delete from test where status='expired' or status='archived'").Resp().(*ByQueryResp)
+	if deleted.Deleted != 3 {
+		t.Errorf("delete by query = %+v, want 3 documents deleted", deleted)
+	}
+	if got := count("select * from test"); got != 2 {
+		t.Errorf("count = %d, want 2", got)
+	}
+	if err := pd.CancelTask("archive"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("CancelTask(archive) err = %v, want %v", err, ErrTaskNotFound)
+	}
+}
+
+func TestPartialUpdate(t *testing.T) {
+	pd := mustNewPoled(t)
+	exec := func(sql string) result {
+		return pd.Exec(sql, WithRefresh(RefreshImmediate))
+	}
+	get := func(id string) Hit {
+		rs, err := pd.Query("select * from test where id=" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rs.Hits.Hits) != 1 {
+			t.Fatalf("select id=%s = %d hits, want 1", id, len(rs.Hits.Hits))
+		}
+		return rs.Hits.Hits[0]
+	}
+
+	for _, sql := range []string{
+		"create table test (id int(10) not null,name varchar(255) not null,views int)",
+		"insert into test (id,name,views) values (1,'hello',1)",
+		"update test set views = views + 1 where id=1",
+		"update test set views = views * 10 where id=1 and _version=2",
+		"begin; insert into test set id=2,name='new',views=1; update test set views = views + 1 where id=2; commit",
+		"update test set views = 1 where id=3",
+	} {
+		if rs := exec(sql); rs.Error() != nil {
+			t.Fatalf("Exec(%s) err = %v", sql, rs.Error())
+		}
+	}
+
+	if hit := get("1"); hit.Source["name"] != "hello" || hit.Source["views"] != float64(20) || hit.Version != 3 {
+		t.Errorf("document 1 = %+v, want name kept, views 20 at version 3", hit)
+	}
+	if hit := get("2"); hit.Source["views"] != float64(2) || hit.Version != 2 {
+		t.Errorf("document 2 = %+v, want views 2 at version 2", hit)
+	}
+	if rs, _ := pd.Query("select * from test where id=3"); rs.Hits.Total != 0 {
+		t.Errorf("update of a missing document = %d hits, want none", rs.Hits.Total)
+	}
+	if rs := exec("update test set views = 0 where id=1 and _version=2"); !errors.Is(rs.Error(), sqlParser.ErrVersionConflict) {
+		t.Errorf("stale update err = %v, want %v", rs.Error(), sqlParser.ErrVersionConflict)
+	}
+	if rs := exec("delete from test where id=1 and _version=2"); !errors.Is(rs.Error(), sqlParser.ErrVersionConflict) {
+		t.Errorf("stale delete err = %v, want %v", rs.Error(), sqlParser.ErrVersionConflict)
+	}
+	if rs := exec("delete from test where id=1 and _version=3"); rs.Error() != nil {
+		t.Errorf("delete err = %v", rs.Error())
+	}
+
+	// a document is merged from its stored fields, the columns that are not
+	// stored would be lost
+	for _, sql := range []string{
+		"create table notes (id int(10) not null,title varchar(255),body text comment 'store=false')",
+		"insert into notes (id,title,body) values (1,'a','hello world')",
+	} {
+		if rs := exec(sql); rs.Error() != nil {
+			t.Fatalf("Exec(%s) err = %v", sql, rs.Error())
+		}
+	}
+	for _, sql := range []string{
+		"update notes set title = 'b' where id=1",
+		"insert into notes (id,title) values (1,'b') on duplicate key update title = values(title)",
+		"begin; update notes set title = 'b' where id=1; commit",
+	} {
+		if rs := exec(sql); !errors.Is(rs.Error(), sqlParser.ErrUnstoredColumn) {
+			t.Errorf("Exec(%s) err = %v, want %v", sql, rs.Error(), sqlParser.ErrUnstoredColumn)
+		}
+	}
+	if rs := exec("replace into notes (id,title,body) values (1,'b','hello again')"); rs.Error() != nil {
+		t.Errorf("replace err = %v", rs.Error())
+	}
+	rs, err := pd.Query("select * from notes where match(body) against('again')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Hits.Total != 1 || rs.Hits.Hits[0].Source["title"] != "b" {
+		t.Errorf("select of the replaced document = %+v, want title b found by its body", rs.Hits)
+	}
+}
+
+func TestConcurrentUpdate(t *testing.T) {
+	pd := mustNewPoled(t)
+	exec := func(sql string) result {
+		return pd.Exec(sql, WithRefresh(RefreshImmediate))
+	}
+	for _, sql := range []string{
+		"create table test (id int(10) not null,name varchar(255) not null,views int)",
+		"insert into test (id,name,views) values (1,'hello',0)",
+	} {
+		if rs := exec(sql); rs.Error() != nil {
+			t.Fatalf("Exec(%s) err = %v", sql, rs.Error())
+		}
+	}
+
+	// two writers checking the same version, only one of them may write
+	for version := 1; version <= 20; version++ {
+		sql := "update test set views = views + 1 where id=1 and _version=" + strconv.Itoa(version)
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() { errs <- exec(sql).Error() }()
+		}
+		var written, conflicts int
+		for i := 0; i < 2; i++ {
+			switch err := <-errs; {
+			case err == nil:
+				written++
+			case errors.Is(err, sqlParser.ErrVersionConflict):
+				conflicts++
+			default:
+				t.Fatalf("Exec(%s) err = %v", sql, err)
+			}
+		}
+		if written != 1 || conflicts != 1 {
+			t.Fatalf("version %d: %d written and %d conflicts, want one of each", version, written, conflicts)
+		}
+	}
+	rs, err := pd.Query("select * from test where id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hit := rs.Hits.Hits[0]; hit.Source["views"] != float64(20) || hit.Version != 21 {
+		t.Errorf("document 1 = %+v, want views 20 at version 21", hit)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	pd := mustNewPoled(t)
+	exec := func(sql string) result {
+		return pd.Exec(sql, WithRefresh(RefreshImmediate))
+	}
+	inserted := func(sql string) *InsertResp {
+		rs := exec(sql)
+		if rs.Error() != nil {
+			t.Fatalf("Exec(%s) err = %v", sql, rs.Error())
+		}
+		return rs.Resp().(*InsertResp)
+	}
+	get := func(id string) Hit {
+		rs, err := pd.Query("select * from test where id=" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rs.Hits.Hits) != 1 {
+			t.Fatalf("select id=%s = %d hits, want 1", id, len(rs.Hits.Hits))
+		}
+		return rs.Hits.Hits[0]
+	}
+
+	exec("create table test (id int(10) not null,name varchar(255) not null,views int)")
+	if rs := inserted("insert into test (id,name,views) values (1,'a',1),(2,'b',1)"); rs.Affected != 2 {
+		t.Errorf("insert = %+v, want 2 rows affected", rs)
+	}
+
+	// an insert with a duplicate row fails reporting the rows written and
+	// the failed ones
+	rs := exec("insert into test (id,name) values (2,'x'),(3,'c')")
+	if !errors.Is(rs.Error(), sqlParser.ErrDuplicateKey) || rs.Code() != http.StatusConflict {
+		t.Errorf("insert of a duplicate = %d, %v, want %d, %v", rs.Code(), rs.Error(), http.StatusConflict, sqlParser.ErrDuplicateKey)
+	}
+	if resp := rs.Resp().(*InsertResp); resp.Affected != 1 || len(resp.Failures) != 1 || resp.Failures[0].Row != 1 || resp.Failures[0].ID != "2" {
+		t.Errorf("insert of a duplicate = %+v, want row 1 failed and 1 row affected", resp)
+	}
+	if rs := exec("insert into test (id,name) values (2,'x')"); !errors.Is(rs.Error(), sqlParser.ErrDuplicateKey) || rs.Code() != http.StatusConflict {
+		t.Errorf("insert of duplicates only = %d, %v, want %d, %v", rs.Code(), rs.Error(), http.StatusConflict, sqlParser.ErrDuplicateKey)
+	}
+	if hit := get("2"); hit.Source["name"] != "b" {
+		t.Errorf("document 2 = %+v, want it kept", hit)
+	}
+
+	if rs := inserted("insert ignore into test (id,name) values (3,'z'),(4,'d')"); rs.Affected != 1 {
+		t.Errorf("insert ignore = %+v, want 1 row affected", rs)
+	}
+	if hit := get("3"); hit.Source["name"] != "c" {
+		t.Errorf("document 3 = %+v, want it kept", hit)
+	}
+
+	if rs := inserted("replace into test (id,name) values (1,'r')"); rs.Affected != 2 {
+		t.Errorf("replace = %+v, want 2 rows affected", rs)
+	}
+	if hit := get("1"); hit.Source["name"] != "r" || hit.Source["views"] != nil || hit.Version != 2 {
+		t.Errorf("document 1 = %+v, want replaced at version 2", hit)
+	}
+
+	if rs := inserted("insert into test (id,name,views) values (2,'y',5),(5,'e',5) on duplicate key update views = views + values(views)"); rs.Affected != 3 {
+		t.Errorf("insert on duplicate = %+v, want 3 rows affected", rs)
+	}
+	if hit := get("2"); hit.Source["name"] != "b" || hit.Source["views"] != float64(6) || hit.Version != 2 {
+		t.Errorf("document 2 = %+v, want views 6 at version 2", hit)
+	}
+	if hit := get("5"); hit.Source["name"] != "e" || hit.Version != 1 {
+		t.Errorf("document 5 = %+v, want it inserted", hit)
+	}
+
+	if rs := exec("begin; insert into test set id=6,name='f'; insert into test set id=1,name='dup'; commit"); !errors.Is(rs.Error(), sqlParser.ErrDuplicateKey) {
+		t.Errorf("transaction with a duplicate err = %v, want %v", rs.Error(), sqlParser.ErrDuplicateKey)
+	}
+	if rs, _ := pd.Query("select * from test where id=6"); rs.Hits.Total != 0 {
+		t.Errorf("transaction with a duplicate wrote %d documents, want none", rs.Hits.Total)
+	}
+}
+
+func TestConcurrentInsert(t *testing.T) {
+	pd := mustNewPoled(t)
+	if rs := pd.Exec("create table test (id int(10) not null,name varchar(255) not null)"); rs.Error() != nil {
+		t.Fatal(rs.Error())
+	}
+
+	// two inserts of the same id, only one of them may write it
+	for id := 1; id <= 20; id++ {
+		sql := "insert into test (id,name) values (" + strconv.Itoa(id) + ",'a')"
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() { errs <- pd.Exec(sql).Error() }()
+		}
+		var written, duplicates int
+		for i := 0; i < 2; i++ {
+			switch err := <-errs; {
+			case err == nil:
+				written++
+			case errors.Is(err, sqlParser.ErrDuplicateKey):
+				duplicates++
+			default:
+				t.Fatalf("Exec(%s) err = %v", sql, err)
+			}
+		}
+		if written != 1 || duplicates != 1 {
+			t.Fatalf("id %d: %d written and %d duplicates, want one of each", id, written, duplicates)
+		}
+	}
+}
+
+func TestGeneratedIds(t *testing.T) {
+	pd := mustNewPoled(t)
+	pd.Exec("create table test (id int(10) not null,name varchar(255) not null)")
+
+	rs := pd.Exec("insert into test (name) values ('a'),('b')", WithRefresh(RefreshImmediate))
+	if rs.Error() != nil {
+		t.Fatal(rs.Error())
+	}
+	resp := rs.Resp().(*InsertResp)
+	if resp.Affected != 2 || len(resp.Ids) != 2 || resp.Ids[0] == resp.Ids[1] {
+		t.Fatalf("insert without ids = %+v, want 2 rows affected with their generated ids", resp)
+	}
+	for i, name := range []string{"a", "b"} {
+		hits, err := pd.Query("select * from test where id='" + resp.Ids[i] + "'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits.Hits.Hits) != 1 || hits.Hits.Hits[0].Source["name"] != name {
+			t.Errorf("select id=%s = %+v, want the row %s", resp.Ids[i], hits.Hits.Hits, name)
+		}
+	}
+
+	if resp := pd.Exec("insert into test (id,name) values (1,'c')").Resp().(*InsertResp); len(resp.Ids) != 0 {
+		t.Errorf("insert with ids = %+v, want no generated ids", resp)
+	}
+}
+
+func TestAlter(t *testing.T) {
+	pd := mustNewPoled(t)
+	exec := func(sql string) error {
+		return pd.Exec(sql, WithRefresh(RefreshImmediate)).Error()
+	}
+	if err := exec("create table test (id int(10) not null,name varchar(255),views int,body text) comment='analyzer=en'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec("insert into test (id,name,views) values (1,'a',1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql  string
+		want error
+	}{
+		{"alter table test drop column views, add column views varchar(255)", ErrIncompatibleFieldType},
+		{"alter table test modify column name text comment 'analyzer=fr'", ErrIncompatibleAnalyzer},
+		{"alter table test modify column body text comment 'analyzer=fr'", nil},
+		{"alter table test drop column views, add column views int", nil},
+		{"alter table test add column title text", nil},
+	}
+	for _, tt := range tests {
+		if err := exec(tt.sql); !errors.Is(err, tt.want) {
+			t.Errorf("Exec(%s) err = %v, want %v", tt.sql, err, tt.want)
+		}
+	}
+	mapping, _ := pd.meta.Get("test")
+	if analyzer := mapping.Properties["title"].Analyzer; analyzer != "en" {
+		t.Errorf("added column analyzer = %q, want the table's en", analyzer)
+	}
+	if analyzer := mapping.Properties["body"].Analyzer; analyzer != "fr" {
+		t.Errorf("modified empty column analyzer = %q, want fr", analyzer)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	pd := mustNewPoled(t)
+	exec := func(sql string) {
+		if err := pd.Exec(sql, WithRefresh(RefreshImmediate)).Error(); err != nil {
+			t.Fatalf("Exec(%s) err = %v", sql, err)
+		}
+	}
+	exec("create table test (id int(10) not null,title varchar(255),city char(32))")
+	exec("insert into test (id,title,city) values (1,'new york','new york'),(2,'new delhi','new delhi'),(3,'york','new york')")
+
+	rs, err := pd.Query("select city, count(*) from test group by city")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[interface{}]int64)
+	for _, bucket := range rs.Buckets {
+		counts[bucket.Key["city"]] = bucket.Count
+	}
+	if want := map[interface{}]int64{"new york": 2, "new delhi": 1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("buckets = %v, want %v", counts, want)
+	}
+
+	if _, err := pd.Query("select title, count(*) from test group by title"); !errors.Is(err, sqlParser.ErrAggregation) {
+		t.Errorf("group by text err = %v, want %v", err, sqlParser.ErrAggregation)
+	}
+}
+
+func TestUnavailableShards(t *testing.T) {
+	pd := mustNewPoled(t)
+	pd.meta.AddNode("test", conf.GetGrpcAddr())
+	exec := func(sql string) error {
+		return pd.Exec(sql, WithRefresh(RefreshImmediate)).Error()
+	}
+	if err := exec("create table test (id int(10) not null,name varchar(255)) partition by hash(id) partitions 2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec("insert into test (id,name) values (1,'a'),(2,'b')"); err != nil {
+		t.Fatal(err)
+	}
+
+	pd.meta.RemoveNode("test")
+	if err := exec("insert into test (id,name) values (3,'c')"); !errors.Is(err, ErrShardUnavailable) {
+		t.Errorf("insert err = %v, want %v", err, ErrShardUnavailable)
+	}
+	if _, err := pd.Query("select * from test"); !errors.Is(err, ErrShardUnavailable) {
+		t.Errorf("select err = %v, want %v", err, ErrShardUnavailable)
+	}
+
+	pd.meta.AddNode("test", conf.GetGrpcAddr())
+	rs, err := pd.Query("select * from test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Hits.Total != 2 {
+		t.Errorf("select after the node joined again = %d hits, want 2", rs.Hits.Total)
+	}
+}
+
+func TestRaftWrites(t *testing.T) {
+	c := conf.GetConfig()
+	c.IndexUri = "mem://" + t.Name()
+	c.DataPath = t.TempDir()
+	c.RaftWrites, c.Raft.Id = true, "test"
+	t.Cleanup(func() { c.RaftWrites, c.Raft.Id = false, "" })
+	fsm := meta.NewMeta()
+	store := raft.NewInmemStore()
+	pd, err := NewPoled(c, fsm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pd.SetRaft(newTestRaftWithStore(t, fsm, store))
+	t.Cleanup(func() { _ = pd.Close() })
+	fsm.AddNode("test", conf.GetGrpcAddr())
+
+	exec := func(sql string) {
+		if rs := pd.Exec(sql, WithRefresh(RefreshImmediate)); rs.Error() != nil {
+			t.Fatalf("Exec(%s) err = %v", sql, rs.Error())
+		}
+	}
+	get := func() Hit {
+		if err := pd.Exec("refresh table test").Error(); err != nil {
+			t.Fatal(err)
+		}
+		rs, err := pd.Query("select * from test where id=1")
+		if err != nil || len(rs.Hits.Hits) != 1 {
+			t.Fatalf("select id=1 = %+v, %v, want a hit", rs, err)
+		}
+		return rs.Hits.Hits[0]
+	}
+	exec("create table test (id int(10) not null,name varchar(255) not null,views int) partition by hash(id) partitions 2")
+	exec("insert into test (id,name,views) values (1,'a',1),(2,'b',1)")
+	exec("update test set views = views + 1 where id=1")
+
+	// raft applies the log again after a restart, nothing is read from the
+	// stored documents so the entries already applied change nothing
+	first, _ := store.FirstIndex()
+	last, _ := store.LastIndex()
+	for i := first; i <= last; i++ {
+		entry, data := &raft.Log{}, &meta.RaftLogData{}
+		if err := store.GetLog(i, entry); err != nil || entry.Type != raft.LogCommand {
+			continue
+		}
+		if err := json.Unmarshal(entry.Data, data); err != nil || len(data.Writes) == 0 {
+			continue
+		}
+		if err, ok := fsm.Apply(entry).(error); ok {
+			t.Errorf("Apply(%d) err = %v", i, err)
+		}
+	}
+	if hit := get(); hit.Source["views"] != float64(2) || hit.Version != 2 {
+		t.Errorf("document 1 after the log is applied again = %+v, want views 2 at version 2", hit)
+	}
+
+	exec("update test set views = views + 1 where id=1 and _version=2")
+	if hit := get(); hit.Source["views"] != float64(3) || hit.Version != 3 {
+		t.Errorf("document 1 = %+v, want views 3 at version 3", hit)
+	}
+
+	// a node that can not apply a write stops rather than going on without it
+	data, err := meta.NewWriteCmd("test", []meta.ShardWrite{{Shard: 0, Node: "test", Deletes: []string{"1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, meta.ErrWriteNotApplied) {
+				t.Errorf("Apply of a write not applied panics with %v, want %v", err, meta.ErrWriteNotApplied)
+			}
+		}()
+		meta.NewMeta().Apply(&raft.Log{Index: last + 10, Type: raft.LogCommand, Data: data})
+	}()
+}
+
+// testNode is another node of the cluster of leader, it shares its index_uri
+// and runs the writes the leader routes to it.
+type testNode struct {
+	pb.UnimplementedPoleServer
+	leader, pd *Poled
+}
+
+// startTestNode registers a node hosting shards as id on leader.
+func startTestNode(t *testing.T, leader *Poled, id string) *Poled {
+	c := *conf.GetConfig()
+	c.DataPath = t.TempDir()
+	fsm := meta.NewMeta()
+	pd, err := NewPoled(&c, fsm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pd.SetRaft(newTestRaft(t, fsm))
+	t.Cleanup(func() { _ = pd.Close() })
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterPoleServer(server, &testNode{leader: leader, pd: pd})
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+	leader.meta.AddNode(id, lis.Addr().String())
+	return pd
+}
+
+func (n *testNode) Exec(ctx context.Context, req *pb.ExecRequest) (*pb.ExecResponse, error) {
+	// the mappings are replicated by raft in a cluster
+	stmt, err := sqlParser.Parse(req.Sql)
+	if err != nil {
+		return nil, err
+	}
+	if mapping, ok := n.leader.meta.Get(stmt.TableName); ok && !n.pd.meta.Exists(stmt.TableName) {
+		cmd, err := meta.NewAddLogDataCmd(stmt.TableName, mapping)
+		if err != nil {
+			return nil, err
+		}
+		if err := n.pd.raft.Apply(cmd, time.Second).Error(); err != nil {
+			return nil, err
+		}
+	}
+	refresh, err := ParseRefreshPolicy(req.Refresh)
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]int, 0, len(req.Shards))
+	for _, shard := range req.Shards {
+		shards = append(shards, int(shard))
+	}
+	rs := n.pd.Exec(req.Sql, WithShards(shards...), WithRefresh(refresh))
+	if err := rs.Error(); err != nil {
+		return nil, err
+	}
+	if report, ok := rs.(fmt.Stringer); ok {
+		return &pb.ExecResponse{Message: report.String()}, nil
+	}
+	return &pb.ExecResponse{Message: "success"}, nil
+}
+
+func TestLeaderReadsRemoteShards(t *testing.T) {
+	pd := mustNewPoled(t)
+	pd.meta.AddNode("test", conf.GetGrpcAddr())
+	other := startTestNode(t, pd, "other")
+	exec := func(sql string) {
+		if rs := pd.Exec(sql, WithRefresh(RefreshImmediate)); rs.Error() != nil {
+			t.Fatalf("Exec(%s) err = %v", sql, rs.Error())
+		}
+	}
+	count := func(sql string) int64 {
+		rs, err := pd.Query(sql, WithConsistency(ReadConsistencyLeader, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.Hits.Total
+	}
+
+	exec("create table test (id int(10) not null,name varchar(255) not null,views int) partition by hash(id) partitions 2")
+	mapping, _ := pd.meta.Get("test")
+	if mapping.ShardNode(0) == mapping.ShardNode(1) {
+		t.Fatalf("shards hosted by %v, want one per node", mapping.Nodes)
+	}
+	exec("insert into test (id,name,views) values (1,'a',1),(2,'b',1),(3,'c',1),(4,'d',1)")
+	if got := count("select * from test"); got != 4 {
+		t.Errorf("select after insert = %d hits, want 4", got)
+	}
+
+	// the duplicates found by both nodes fail the insert
+	rs := pd.Exec("insert into test (id,name) values (1,'x'),(2,'x'),(3,'x'),(4,'x'),(5,'e'),(6,'f')", WithRefresh(RefreshImmediate))
+	if !errors.Is(rs.Error(), sqlParser.ErrDuplicateKey) || rs.Code() != http.StatusConflict {
+		t.Errorf("insert of duplicates = %d, %v, want %d, %v", rs.Code(), rs.Error(), http.StatusConflict, sqlParser.ErrDuplicateKey)
+	}
+	if resp, ok := rs.Resp().(*InsertResp); !ok || resp.Affected != 2 || len(resp.Failures) != 4 || resp.Failures[3].Row != 4 {
+		t.Errorf("insert of duplicates = %+v, want rows 1 to 4 failed and 2 rows affected", rs.Resp())
+	}
+
+	// the reader of the remote shard is open, the next writes refresh it
+	exec("update test set views = 2")
+	if got := count("select * from test where views = 2"); got != 6 {
+		t.Errorf("select after update = %d hits, want 6", got)
+	}
+	exec("delete from test where id in (1,2,3,4,5,6)")
+	if got := count("select * from test"); got != 0 {
+		t.Errorf("select after delete = %d hits, want 0", got)
+	}
+	if _, ok := other.writers.Lookup(mapping.ShardName("test", 0)); !ok {
+		if _, ok := other.writers.Lookup(mapping.ShardName("test", 1)); !ok {
+			t.Error("other node wrote no shard")
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package poled
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"pole/internal/pb"
+	"pole/internal/poled/index"
+	"pole/internal/poled/meta"
+	sqlParser "pole/internal/poled/sql"
+	poleRaft "pole/internal/raft"
+	"pole/internal/util/log"
+)
+
+const (
+	writeApplyTimeout = 5 * time.Second
+	// loadPollInterval is how often a node loading documents for the leader
+	// checks whether it caught up with the raft log.
+	loadPollInterval = 5 * time.Millisecond
+)
+
+var ErrLoadTimeout = errors.New("raft log not applied in time to load the documents")
+
+// logWrite resolves the write on the leader and appends the documents it puts
+// and the ids it deletes to the raft log, it is acknowledged once a quorum
+// has them and the leader applied them. The shards written are locked from
+// reading their documents until the entry is applied, the next write reads
+// what this one wrote.
+func (p *Poled) logWrite(stmt *sqlParser.SqlVistor, options *execOptions) result {
+	idx := stmt.TableName
+	lg := log.WithField("module", "log_write").WithField("index", idx)
+	mapping, exists := p.meta.Get(idx)
+	if !exists {
+		lg.Error(ErrIndexNotFound)
+		return newGeneralResult(ErrIndexNotFound)
+	}
+
+	routed, err := stmt.Writes(mapping, nil)
+	if err != nil {
+		lg.Error(err)
+		return newGeneralResult(err)
+	}
+	shards := sqlParser.ShardWrites(mapping, routed)
+	wanted := func(shard int) bool {
+		_, ok := shards[shard]
+		return ok
+	}
+	if err := p.checkAvailable(mapping, wanted); err != nil {
+		lg.Error(err)
+		return newGeneralResult(err)
+	}
+	names := make([]string, 0, len(shards))
+	for shard := range shards {
+		names = append(names, mapping.ShardName(idx, shard))
+	}
+	defer p.shardLocks.lock(names)()
+
+	stored, err := p.newLoggedDocuments(idx, mapping, routed)
+	if err != nil {
+		lg.Error(err)
+		return newGeneralResult(err)
+	}
+	defer stored.close()
+	writes, err := stmt.Writes(mapping, stored.load)
+	if err != nil {
+		lg.Error(err)
+		return newGeneralResult(err)
+	}
+
+	inserted := newInsertResult(len(writes))
+	logged := newShardWrites(mapping, sqlParser.ShardWrites(mapping, writes), p.shardNode, inserted)
+	if len(logged) > 0 {
+		cmd, err := meta.NewWriteCmd(idx, logged)
+		if err != nil {
+			return newGeneralResult(err)
+		}
+		af := p.raft.Apply(cmd, writeApplyTimeout)
+		if err := af.Error(); err != nil {
+			lg.Error(err)
+			return newGeneralResult(err)
+		}
+		if err, ok := af.Response().(error); ok {
+			return newGeneralResult(err)
+		}
+	}
+
+	// the shards hosted by other nodes are read from the index_uri once
+	// their nodes apply the entry, they are refreshed on the next refresh
+	var hosted []int
+	for _, w := range logged {
+		if p.isLocalNode(w.Node) {
+			hosted = append(hosted, w.Shard)
+		} else {
+			p.readers.MarkDirty(mapping.ShardName(idx, w.Shard))
+		}
+	}
+	if err := p.refreshShards(p.readers, idx, mapping, hosted, options.refresh); err != nil {
+		return newGeneralResult(err)
+	}
+	if stmt.ActionType == sqlParser.StmtTypeInsert {
+		inserted.sort()
+		return inserted
+	}
+	return newGeneralResult(nil)
+}
+
+// newShardWrites is what the raft log keeps of the writes of each shard, in
+// shard order. The rows which failed are only counted in inserted.
+func newShardWrites(mapping meta.Mapping, merged map[int][]sqlParser.Write, node func(meta.Mapping, int) string, inserted *insertResult) []meta.ShardWrite {
+	shards := make([]int, 0, len(merged))
+	for shard := range merged {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+	rs := make([]meta.ShardWrite, 0, len(shards))
+	for _, shard := range shards {
+		inserted.add(merged[shard])
+		if !written(merged[shard]) {
+			continue
+		}
+		item := meta.ShardWrite{Shard: shard, Node: node(mapping, shard)}
+		for _, w := range merged[shard] {
+			switch {
+			case w.Err != nil:
+			case w.Doc == nil:
+				item.Deletes = append(item.Deletes, w.ID)
+			default:
+				item.Docs = append(item.Docs, meta.LoggedDoc{ID: w.ID, Values: w.Values})
+			}
+		}
+		rs = append(rs, item)
+	}
+	return rs
+}
+
+// shardNode returns the node writing the shard, the leader writes the shards
+// without one.
+func (p *Poled) shardNode(mapping meta.Mapping, shard int) string {
+	if node := mapping.ShardNode(shard); node != "" {
+		return node
+	}
+	return p.conf.Raft.Id
+}
+
+// applyWrite applies the writes of the raft log entry at applied to the
+// shards hosted here and the copies kept here, every node does so they are
+// not forwarded. A shard which already has the entry, raft applying the log
+// again after a restart, skips it.
+func (p *Poled) applyWrite(applied uint64, idx string, writes []meta.ShardWrite) error {
+	defer atomic.StoreUint64(&p.applied, applied)
+	// the index was dropped after the write was logged
+	mapping, exists := p.meta.Get(idx)
+	if !exists {
+		return nil
+	}
+	for _, w := range writes {
+		if p.isLocalNode(w.Node) {
+			if err := p.applyShardWrite(p.writers, p.readers, idx, mapping, applied, w); err != nil {
+				return err
+			}
+		}
+		if p.keepsReplica(mapping, w.Shard) {
+			if err := p.applyShardWrite(p.replicaWriters, p.replicaReaders, idx, mapping, applied, w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Poled) applyShardWrite(writers *index.Writers, readers *index.Readers, idx string, mapping meta.Mapping, applied uint64, w meta.ShardWrite) error {
+	name := mapping.ShardName(idx, w.Shard)
+	writer, exists := writers.Get(name)
+	if !exists {
+		return ErrWriterNotFound
+	}
+	if writer.Applied() >= applied {
+		return nil
+	}
+	record := &translogRecord{Deletes: w.Deletes}
+	for _, doc := range w.Docs {
+		record.Docs = append(record.Docs, translogDoc{ID: doc.ID, Values: doc.Values})
+	}
+	batch, err := newRecordBatch(mapping, record)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := writer.LogApplied(applied, data, batch); err != nil {
+		return err
+	}
+	readers.MarkDirty(name)
+	return nil
+}
+
+// loggedDocuments reads the documents a logged write merges into, from the
+// writers of the shards hosted here and from the nodes hosting the others.
+type loggedDocuments struct {
+	*storedDocuments
+	remote map[string]map[string]interface{}
+}
+
+func (p *Poled) newLoggedDocuments(idx string, mapping meta.Mapping, writes []sqlParser.Write) (*loggedDocuments, error) {
+	local := func(shard int) bool {
+		return p.isLocalNode(p.shardNode(mapping, shard))
+	}
+	rs := &loggedDocuments{
+		storedDocuments: newStoredDocuments(p.writers, idx, mapping, local),
+		remote:          make(map[string]map[string]interface{}),
+	}
+	ids := make(map[string][]string)
+	for _, w := range writes {
+		if shard := mapping.Shard(w.ID); !local(shard) {
+			node := p.shardNode(mapping, shard)
+			ids[node] = append(ids[node], w.ID)
+		}
+	}
+	applied := atomic.LoadUint64(&p.applied)
+	for node, items := range ids {
+		addr, ok := p.meta.NodeAddr(node)
+		if !ok {
+			rs.close()
+			return nil, fmt.Errorf("%w: node %s", ErrShardUnavailable, node)
+		}
+		docs, err := p.loadByRpc(addr, idx, items, applied)
+		if err != nil {
+			rs.close()
+			return nil, err
+		}
+		for id, doc := range docs {
+			rs.remote[id] = doc
+		}
+	}
+	return rs, nil
+}
+
+func (d *loggedDocuments) load(id string) (map[string]interface{}, error) {
+	if doc, ok := d.remote[id]; ok {
+		return doc, nil
+	}
+	return d.storedDocuments.load(id)
+}
+
+// Load returns the stored documents of the shards hosted here by id, once
+// the writes logged up to applied are applied here so they hold every write
+// the leader resolved before.
+func (p *Poled) Load(idx string, ids []string, applied uint64) (map[string]map[string]interface{}, error) {
+	deadline := time.Now().Add(writeApplyTimeout)
+	for atomic.LoadUint64(&p.applied) < applied {
+		if time.Now().After(deadline) {
+			return nil, ErrLoadTimeout
+		}
+		time.Sleep(loadPollInterval)
+	}
+	mapping, exists := p.meta.Get(idx)
+	if !exists {
+		return nil, ErrIndexNotFound
+	}
+	local := func(shard int) bool {
+		return p.isLocalNode(p.shardNode(mapping, shard))
+	}
+	stored := newStoredDocuments(p.writers, idx, mapping, local)
+	defer stored.close()
+	rs := make(map[string]map[string]interface{}, len(ids))
+	for _, id := range ids {
+		doc, err := stored.load(id)
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			rs[id] = doc
+		}
+	}
+	return rs, nil
+}
+
+// loadByRpc loads the stored documents from the node hosting them.
+func (p *Poled) loadByRpc(addr, idx string, ids []string, applied uint64) (map[string]map[string]interface{}, error) {
+	client, err := poleRaft.GetClientConn(addr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := pb.NewPoleClient(client).Load(context.Background(), &pb.LoadRequest{Index: idx, Ids: ids, AppliedIndex: applied})
+	if err != nil {
+		log.WithField("module", "loadByRpc").WithField("grpcAddr", addr).Error(err)
+		return nil, err
+	}
+	rs := make(map[string]map[string]interface{})
+	return rs, json.Unmarshal(resp.Documents, &rs)
+}
@@ -0,0 +1,114 @@
+package poled
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"pole/internal/poled/index"
+	"pole/internal/poled/meta"
+	sqlParser "pole/internal/poled/sql"
+	"pole/internal/util/log"
+)
+
+type RefreshPolicy string
+
+const (
+	// RefreshNone leaves the write to the periodic refresh.
+	RefreshNone RefreshPolicy = ""
+	// RefreshImmediate refreshes the written shards before replying.
+	RefreshImmediate RefreshPolicy = "true"
+	// RefreshWaitFor replies once the periodic refresh made the write
+	// visible.
+	RefreshWaitFor RefreshPolicy = "wait_for"
+)
+
+var ErrRefreshPolicy = errors.New("refresh must be true, false or wait_for")
+
+func ParseRefreshPolicy(refresh string) (RefreshPolicy, error) {
+	switch strings.ToLower(refresh) {
+	case "", "false":
+		return RefreshNone, nil
+	case "true":
+		return RefreshImmediate, nil
+	case string(RefreshWaitFor):
+		return RefreshWaitFor, nil
+	}
+	return "", fmt.Errorf("%w:%s", ErrRefreshPolicy, refresh)
+}
+
+// WithRefresh sets when a write replies relative to the refresh making it
+// visible.
+func WithRefresh(refresh RefreshPolicy) ExecOption {
+	return func(op *execOptions) {
+		op.refresh = refresh
+	}
+}
+
+// markDirty schedules the refresh of every shard of the index after a write.
+func (p *Poled) markDirty(idx string) {
+	mapping, _ := p.meta.Get(idx)
+	for _, name := range mapping.ShardNames(idx) {
+		p.readers.MarkDirty(name)
+		p.replicaReaders.MarkDirty(name)
+	}
+}
+
+// refreshShards applies the refresh policy to the shards written here.
+func (p *Poled) refreshShards(readers *index.Readers, idx string, mapping meta.Mapping, shards []int, refresh RefreshPolicy) error {
+	for _, shard := range shards {
+		name := mapping.ShardName(idx, shard)
+		switch refresh {
+		case RefreshImmediate:
+			if err := readers.Refresh(name); err != nil {
+				return err
+			}
+		case RefreshWaitFor:
+			<-readers.WaitFor(name)
+		default:
+			readers.MarkDirty(name)
+		}
+	}
+	return nil
+}
+
+// execRefresh refreshes the readers of the tables here and on the other
+// nodes, REFRESH TABLE without table refreshes every index.
+func (p *Poled) execRefresh(stmt *sqlParser.SqlVistor, options *execOptions) result {
+	lg := log.WithField("module", "refresh")
+	tables := stmt.RefreshTables
+	if len(tables) == 0 {
+		for idx := range p.meta.All() {
+			tables = append(tables, idx)
+		}
+	}
+	for _, idx := range tables {
+		mapping, exists := p.meta.Get(idx)
+		if !exists {
+			return newGeneralResult(fmt.Errorf("%w:%s", ErrIndexNotFound, idx))
+		}
+		for _, name := range mapping.ShardNames(idx) {
+			for _, readers := range []*index.Readers{p.readers, p.replicaReaders} {
+				if err := readers.Refresh(name); err != nil {
+					lg.Error(err)
+					return newGeneralResult(err)
+				}
+			}
+		}
+	}
+
+	// the shards option marks a refresh sent by another node
+	if len(options.shards) > 0 {
+		return newGeneralResult(nil)
+	}
+	for _, id := range p.meta.NodeIds() {
+		if p.isLocalNode(id) {
+			continue
+		}
+		addr, _ := p.meta.NodeAddr(id)
+		if rs := p.execByRpc(addr, newExecRequest(stmt.Sql, []int{0}, false)); rs.Error() != nil {
+			return rs
+		}
+	}
+	return newGeneralResult(nil)
+}
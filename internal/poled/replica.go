@@ -0,0 +1,211 @@
+package poled
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pole/internal/conf"
+	"pole/internal/pb"
+	"pole/internal/poled/index"
+	"pole/internal/poled/meta"
+	sqlParser "pole/internal/poled/sql"
+	poleRaft "pole/internal/raft"
+	"pole/internal/util/log"
+)
+
+type ReadConsistency string
+
+const (
+	// ReadConsistencyLeader selects on the leader. Every acknowledged write
+	// to a shard it hosts is visible, as is a write acknowledged with a
+	// refresh to a shard hosted by another node without raft_writes, the
+	// leader reads those shards from the shared index_uri once the writes are
+	// persisted. Other writes to them are visible within the max staleness.
+	ReadConsistencyLeader ReadConsistency = "leader"
+	// ReadConsistencyBounded selects on this node with readers opened less
+	// than the max staleness ago, the copies kept here are preferred.
+	ReadConsistencyBounded ReadConsistency = "bounded"
+	// ReadConsistencyAny selects on this node with whatever reader it has.
+	ReadConsistencyAny ReadConsistency = "any"
+)
+
+// replicaDir is where the copies of shards are kept under conf.DataPath.
+const replicaDir = "replicas"
+
+var (
+	ErrReadConsistency = errors.New("read consistency must be leader, bounded or any")
+	ErrReplicaFailed   = errors.New("replica write failed")
+)
+
+func ParseReadConsistency(consistency string) (ReadConsistency, error) {
+	switch rs := ReadConsistency(strings.ToLower(consistency)); rs {
+	case "":
+		return ReadConsistencyAny, nil
+	case ReadConsistencyLeader, ReadConsistencyBounded, ReadConsistencyAny:
+		return rs, nil
+	}
+	return "", fmt.Errorf("%w:%s", ErrReadConsistency, consistency)
+}
+
+// WithConsistency sets the read consistency of a select, maxStaleness bounds
+// bounded selects and defaults to conf.MaxStaleness.
+func WithConsistency(consistency ReadConsistency, maxStaleness time.Duration) ExecOption {
+	return func(op *execOptions) {
+		op.consistency = consistency
+		op.maxStaleness = maxStaleness
+	}
+}
+
+// WithReplica applies a write to the copies of the shards kept here instead
+// of the shards themselves.
+func WithReplica() ExecOption {
+	return func(op *execOptions) {
+		op.replica = true
+	}
+}
+
+// replicaUri is the local directory holding the copies of shards, so they
+// are read without going to the index storage.
+func replicaUri(dataPath string) string {
+	path, err := filepath.Abs(filepath.Join(dataPath, replicaDir))
+	if err != nil {
+		path = filepath.Join(dataPath, replicaDir)
+	}
+	return "file://" + filepath.ToSlash(path)
+}
+
+func newExecRequest(sql string, shards []int, replica bool) *pb.ExecRequest {
+	rs := &pb.ExecRequest{Sql: sql, Replica: replica}
+	for _, shard := range shards {
+		rs.Shards = append(rs.Shards, int32(shard))
+	}
+	return rs
+}
+
+func (p *Poled) isLocalNode(id string) bool {
+	addr, ok := p.meta.NodeAddr(id)
+	return ok && addr == conf.GetGrpcAddr()
+}
+
+// keepsReplica reports whether this node keeps a copy of the shard.
+func (p *Poled) keepsReplica(mapping meta.Mapping, shard int) bool {
+	for _, id := range mapping.ShardReplicas(shard) {
+		if p.isLocalNode(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// replicaHosts groups the shards by the grpc address of the nodes keeping
+// their copies, the copies kept here are grouped under "".
+func (p *Poled) replicaHosts(mapping meta.Mapping, shards []int) map[string][]int {
+	rs := make(map[string][]int)
+	for _, shard := range shards {
+		for _, id := range mapping.ShardReplicas(shard) {
+			addr, ok := p.meta.NodeAddr(id)
+			if !ok {
+				continue
+			}
+			if addr == conf.GetGrpcAddr() {
+				addr = ""
+			}
+			rs[addr] = append(rs[addr], shard)
+		}
+	}
+	return rs
+}
+
+// replicate applies the statement to the copies of the shards, it is called
+// once the shards are written so a copy is never ahead of its shard.
+func (p *Poled) replicate(stmt *sqlParser.SqlVistor, mapping meta.Mapping, shards []int, refresh RefreshPolicy) error {
+	for addr, hosted := range p.replicaHosts(mapping, shards) {
+		var rs result
+		if addr == "" {
+			rs = p.execShards(stmt, &execOptions{shards: hosted, replica: true, refresh: refresh})
+		} else {
+			req := newExecRequest(stmt.Sql, hosted, true)
+			req.Refresh = string(refresh)
+			rs = p.execByRpc(addr, req)
+		}
+		if err := rs.Error(); err != nil {
+			return fmt.Errorf("%w:%s", ErrReplicaFailed, err)
+		}
+	}
+	return nil
+}
+
+// shardReaders returns a reader of every shard, read from the copy kept here
+// unless the select is run on the leader. The shards hosted by other nodes
+// are read from the index_uri the nodes share, their readers are reopened
+// once older than the max staleness. The readers must be released.
+func (p *Poled) shardReaders(idx string, mapping meta.Mapping, options *execOptions) ([]*index.Reader, error) {
+	staleness := time.Duration(0)
+	if options.consistency == ReadConsistencyBounded {
+		staleness = options.maxStaleness
+		if staleness <= 0 {
+			staleness = conf.GetMaxStaleness()
+		}
+	}
+
+	// a copy kept here is still read when the shard is unavailable
+	replica := func(shard int) bool {
+		return options.consistency != ReadConsistencyLeader && p.keepsReplica(mapping, shard)
+	}
+	primary := func(shard int) bool {
+		return !replica(shard)
+	}
+	if err := p.checkAvailable(mapping, primary); err != nil {
+		return nil, err
+	}
+
+	rs := make([]*index.Reader, 0, mapping.ShardCount())
+	for shard, name := range mapping.ShardNames(idx) {
+		readers := p.readers
+		if replica(shard) {
+			readers = p.replicaReaders
+		}
+		within := staleness
+		if _, local := p.shardHost(mapping, shard); !local && !replica(shard) && within <= 0 {
+			within = conf.GetMaxStaleness()
+		}
+		reader, exists := readers.GetWithin(name, within)
+		if !exists {
+			releaseReaders(rs)
+			return nil, ErrReaderNotFound
+		}
+		rs = append(rs, reader)
+	}
+	return rs, nil
+}
+
+func releaseReaders(readers []*index.Reader) {
+	for _, reader := range readers {
+		reader.Release()
+	}
+}
+
+// queryByRpc runs the select on another node.
+func (p *Poled) queryByRpc(addr, sql string) result {
+	lg := log.WithField("module", "queryByRpc").WithField("grpcAddr", addr)
+
+	client, err := poleRaft.GetClientConn(addr)
+	if err != nil {
+		return newGeneralResult(err)
+	}
+
+	resp, err := pb.NewPoleClient(client).Query(context.Background(), &pb.QueryRequest{Sql: sql})
+	if err != nil {
+		lg.Error("failed to query, err: ", err)
+		return newGeneralResult(err)
+	}
+	rs, err := newSelectRespFromPb(resp)
+	if err != nil {
+		return newGeneralResult(err)
+	}
+	return rs
+}
@@ -1,13 +1,20 @@
 package poled
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"pole/internal/pb"
 	mt "pole/internal/poled/meta"
 	"pole/internal/poled/sql"
+	"time"
 
 	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/numeric"
 	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/highlight"
 )
 
 type result interface {
@@ -17,13 +24,20 @@ type result interface {
 }
 
 var (
-	ErrIndexExist         = errors.New("index already exists")
-	ErrIndexNotFound      = errors.New("index not found")
-	ErrWriterNotFound     = errors.New("writer not found")
-	ErrWriterCreateFailed = errors.New("writer creation failed") 
-	ErrReaderNotFound     = errors.New("reader not found")
-	ErrSyntaxNotSupported = errors.New("syntax not supported")
-	ErrBatchFailed        = errors.New("batch failed")
+	ErrIndexExist            = errors.New("index already exists")
+	ErrIndexNotFound         = errors.New("index not found")
+	ErrWriterNotFound        = errors.New("writer not found")
+	ErrWriterCreateFailed    = errors.New("writer creation failed")
+	ErrReaderNotFound        = errors.New("reader not found")
+	ErrSyntaxNotSupported    = errors.New("syntax not supported")
+	ErrBatchFailed           = errors.New("batch failed")
+	ErrNotSelectStmt         = errors.New("not a select statement")
+	ErrFieldExist            = errors.New("field already exists")
+	ErrDropIdentifier        = errors.New("id field can not be dropped")
+	ErrIncompatibleFieldType = errors.New("incompatible field type change on populated field")
+	ErrIncompatibleAnalyzer  = errors.New("incompatible analyzer change on populated field")
+	ErrShardNotHosted        = errors.New("shard is not hosted by this node")
+	ErrShardUnavailable      = errors.New("shard is unavailable, its node left the cluster")
 )
 
 type generalResp struct {
@@ -61,54 +75,267 @@ func (r *generalResult) Code() int {
 	return http.StatusOK
 }
 
-type selectResp struct {
-	Took     int64 `json:"took"`
-	TimedOut bool  `json:"timed_out"`
-	Hits     Hits  `json:"hits"`
+// rpcResult is the reply of another node carrying a report, it is returned
+// as it is.
+type rpcResult struct {
+	message json.RawMessage
 }
 
-func newSelectResult(iter search.DocumentMatchIterator, meta mt.Mapping, cols []sql.Col, selectAll bool) *selectResp {
+func (r *rpcResult) Error() error {
+	return nil
+}
 
-	hitItems := make([]Hit, 0, iter.Aggregations().Count())
-	colsMap := cols2Map(cols)
-	next, err := iter.Next()
-	for err == nil && next != nil {
-		hit := Hit{
-			Source: make(map[string]interface{}),
-		}
-		_ = next.VisitStoredFields(func(field string, value []byte) bool {
-			if field == "_id" {
-				hit.ID = string(value)
-				return true
-			}
+func (r *rpcResult) Resp() interface{} {
+	return r.message
+}
 
-			v, ok := parseValue(field, value, meta, colsMap, selectAll)
-			if ok {
-				hit.Source[field] = v
-			}
+func (r *rpcResult) Code() int {
+	return http.StatusOK
+}
 
-			return true
-		})
-		hitItems = append(hitItems, hit)
+func (r *rpcResult) String() string {
+	return string(r.message)
+}
 
-		next, err = iter.Next()
+// multiResult holds the results of several statements in their order, each
+// write of a transaction has the result of the transaction.
+type multiResult struct {
+	results []result
+}
+
+func (r *multiResult) add(stmt *sql.SqlVistor, rs result) {
+	n := 1
+	if stmt.ActionType == sql.StmtTypeTransaction {
+		n = len(stmt.Stmts)
+	}
+	for i := 0; i < n; i++ {
+		r.results = append(r.results, rs)
+	}
+}
+
+func (r *multiResult) Error() error {
+	for _, rs := range r.results {
+		if err := rs.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *multiResult) Resp() interface{} {
+	rs := make([]interface{}, 0, len(r.results))
+	for _, result := range r.results {
+		rs = append(rs, result.Resp())
+	}
+	return rs
+}
+
+func (r *multiResult) Code() int {
+	if r.Error() != nil {
+		return http.StatusInternalServerError
 	}
+	return http.StatusOK
+}
 
-	hits := Hits{
-		Total:    int64(iter.Aggregations().Count()),
-		MaxScore: iter.Aggregations().Metric("max_score"),
-		Hits:     hitItems,
+type selectResp struct {
+	Took         int64                  `json:"took"`
+	TimedOut     bool                   `json:"timed_out"`
+	Hits         Hits                   `json:"hits"`
+	Aggregations map[string]interface{} `json:"aggregations,omitempty"`
+	Buckets      []Bucket               `json:"buckets,omitempty"`
+}
+
+func newSelectResult(iter search.DocumentMatchIterator, meta mt.Mapping, stmt *sql.SqlVistor) *selectResp {
+	rs := newSelectSummary(iter, meta, stmt)
+	rs.Hits.Hits = make([]Hit, 0, iter.Aggregations().Count())
+	builder := newHitBuilder(meta, stmt)
+	next, err := iter.Next()
+	for err == nil && next != nil {
+		rs.Hits.Hits = append(rs.Hits.Hits, builder.hit(next))
+		next, err = iter.Next()
 	}
+	return rs
+}
 
+// newSelectSummary reads the totals and the aggregations of a select, its
+// hits are read by the caller.
+func newSelectSummary(iter search.DocumentMatchIterator, meta mt.Mapping, stmt *sql.SqlVistor) *selectResp {
 	rs := &selectResp{
 		Took:     iter.Aggregations().Duration().Milliseconds(),
 		TimedOut: false,
-		Hits:     hits,
+		Hits: Hits{
+			Total:    int64(iter.Aggregations().Count()),
+			MaxScore: iter.Aggregations().Metric("max_score"),
+		},
+	}
+	if len(stmt.GroupBy) > 0 {
+		rs.Buckets = newBuckets(iter.Aggregations(), meta, stmt.Aggregations, stmt.GroupBy)
+	} else if len(stmt.Aggregations) > 0 {
+		rs.Aggregations = bucketMetrics(iter.Aggregations(), stmt.Aggregations)
+	}
+	return rs
+}
+
+// SelectSummary is what a streamed select sends before its hits.
+type SelectSummary struct {
+	Took         int64
+	TimedOut     bool
+	Total        int64
+	MaxScore     float64
+	Aggregations map[string]interface{}
+	Buckets      []Bucket
+}
+
+func (r *selectResp) summary() *SelectSummary {
+	return &SelectSummary{
+		Took:         r.Took,
+		TimedOut:     r.TimedOut,
+		Total:        r.Hits.Total,
+		MaxScore:     r.Hits.MaxScore,
+		Aggregations: r.Aggregations,
+		Buckets:      r.Buckets,
 	}
+}
+
+// hitBuilder reads the hits of a select.
+type hitBuilder struct {
+	meta         mt.Mapping
+	stmt         *sql.SqlVistor
+	cols         map[string]sql.Col
+	highlights   map[string]sql.Highlight
+	highlighters map[string]*highlight.SimpleHighlighter
+}
 
+func newHitBuilder(meta mt.Mapping, stmt *sql.SqlVistor) *hitBuilder {
+	rs := &hitBuilder{
+		meta:         meta,
+		stmt:         stmt,
+		cols:         cols2Map(stmt.ColNames),
+		highlights:   make(map[string]sql.Highlight, len(stmt.Highlights)),
+		highlighters: make(map[string]*highlight.SimpleHighlighter, len(stmt.Highlights)),
+	}
+	for _, h := range stmt.Highlights {
+		rs.highlights[h.Column] = h
+		rs.highlighters[h.Column] = h.Highlighter()
+	}
 	return rs
 }
 
+func (b *hitBuilder) hit(next *search.DocumentMatch) Hit {
+	hit := Hit{
+		Score:       next.Score,
+		Explanation: next.Explanation,
+		Source:      make(map[string]interface{}),
+	}
+	_ = next.VisitStoredFields(func(field string, value []byte) bool {
+		if field == "_id" {
+			hit.ID = string(value)
+			return true
+		}
+		if field == mt.VersionField {
+			version, _ := bluge.DecodeNumericFloat64(value)
+			hit.Version = int64(version)
+			return true
+		}
+
+		if highlighter, ok := b.highlighters[field]; ok && len(next.Locations[field]) > 0 {
+			fragments := highlighter.BestFragments(next.Locations[field], value, b.highlights[field].Fragments)
+			if len(fragments) > 0 {
+				if hit.Highlight == nil {
+					hit.Highlight = make(map[string][]string)
+				}
+				hit.Highlight[field] = fragments
+			}
+		}
+
+		v, ok := parseValue(field, value, b.meta, b.cols, b.stmt.SelectAll)
+		if ok {
+			hit.Source[field] = v
+		}
+
+		return true
+	})
+	return hit
+}
+
+// Bucket is a row of a GROUP BY select, Key holds the value of every group by
+// column and Metrics the aggregate functions computed for the row.
+type Bucket struct {
+	Key     map[string]interface{} `json:"key"`
+	Count   int64                  `json:"doc_count"`
+	Metrics map[string]interface{} `json:"metrics,omitempty"`
+}
+
+// newBuckets flattens the nested group by aggregations into one bucket per
+// combination of group by values.
+func newBuckets(root *search.Bucket, meta mt.Mapping, aggs []sql.Aggregation, groupBy []sql.GroupBy) []Bucket {
+	var rs []Bucket
+	var walk func(bucket *search.Bucket, level int, key map[string]interface{})
+	walk = func(bucket *search.Bucket, level int, key map[string]interface{}) {
+		if level == len(groupBy) {
+			rs = append(rs, Bucket{
+				Key:     key,
+				Count:   int64(bucket.Count()),
+				Metrics: bucketMetrics(bucket, aggs),
+			})
+			return
+		}
+		group := groupBy[level]
+		for _, sub := range bucket.Buckets(group.Column) {
+			if sub.Count() == 0 {
+				continue
+			}
+			subKey := make(map[string]interface{}, len(key)+1)
+			for k, v := range key {
+				subKey[k] = v
+			}
+			subKey[group.Column] = bucketKey(sub.Name(), group, meta)
+			walk(sub, level+1, subKey)
+		}
+	}
+	walk(root, 0, map[string]interface{}{})
+	return rs
+}
+
+// bucketMetrics reads the aggregate functions of a bucket, metrics without
+// value such as the average of no document are nil.
+func bucketMetrics(bucket *search.Bucket, aggs []sql.Aggregation) map[string]interface{} {
+	if len(aggs) == 0 {
+		return nil
+	}
+	rs := make(map[string]interface{}, len(aggs))
+	for _, agg := range aggs {
+		v := bucket.Metric(agg.Name)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			rs[agg.Name] = nil
+			continue
+		}
+		rs[agg.Name] = v
+	}
+	return rs
+}
+
+// bucketKey decodes the term a terms bucket is named after.
+func bucketKey(name string, group sql.GroupBy, meta mt.Mapping) interface{} {
+	if len(group.Ranges) > 0 {
+		return name
+	}
+	switch meta.Properties[group.Column].Type {
+	case mt.FieldTypeNumeric, mt.FieldTypeDatetime:
+		i64, err := numeric.PrefixCoded(name).Int64()
+		if err != nil {
+			return name
+		}
+		if meta.Properties[group.Column].Type == mt.FieldTypeDatetime {
+			return time.Unix(0, i64).UTC().Format(time.RFC3339Nano)
+		}
+		return numeric.Int64ToFloat64(i64)
+	case mt.FieldTypeBoolean:
+		return name == mt.BooleanTrue
+	}
+	return name
+}
+
 type Hits struct {
 	Total    int64   `json:"total"`
 	MaxScore float64 `json:"max_score"`
@@ -116,8 +343,90 @@ type Hits struct {
 }
 
 type Hit struct {
-	ID     string                 `json:"_id"`
-	Source map[string]interface{} `json:"_source"`
+	ID          string                 `json:"_id"`
+	Score       float64                `json:"_score"`
+	Version     int64                  `json:"_version,omitempty"`
+	Explanation *search.Explanation    `json:"_explanation,omitempty"`
+	Source      map[string]interface{} `json:"_source"`
+	Highlight   map[string][]string    `json:"highlight,omitempty"`
+}
+
+// newSelectRespFromPb rebuilds the result of a select run on another node.
+func newSelectRespFromPb(resp *pb.QueryResponse) (*selectResp, error) {
+	rs := &selectResp{
+		Took:     resp.Took,
+		TimedOut: resp.TimedOut,
+		Hits: Hits{
+			Total:    resp.Hits.GetTotal(),
+			MaxScore: resp.Hits.GetMaxScore(),
+			Hits:     make([]Hit, 0, len(resp.Hits.GetHits())),
+		},
+		Buckets: newBucketsFromPb(resp.Buckets),
+	}
+	for _, item := range resp.Hits.GetHits() {
+		hit, err := newHitFromPb(item)
+		if err != nil {
+			return nil, err
+		}
+		rs.Hits.Hits = append(rs.Hits.Hits, hit)
+	}
+
+	if resp.Aggregations != nil {
+		rs.Aggregations = resp.Aggregations.AsMap()
+	}
+	return rs, nil
+}
+
+// newSelectSummaryFromPb rebuilds the summary of a select streamed by
+// another node.
+func newSelectSummaryFromPb(summary *pb.QuerySummary) *SelectSummary {
+	rs := &SelectSummary{
+		Took:     summary.Took,
+		TimedOut: summary.TimedOut,
+		Total:    summary.Total,
+		MaxScore: summary.MaxScore,
+		Buckets:  newBucketsFromPb(summary.Buckets),
+	}
+	if summary.Aggregations != nil {
+		rs.Aggregations = summary.Aggregations.AsMap()
+	}
+	return rs
+}
+
+func newHitFromPb(item *pb.Hit) (Hit, error) {
+	hit := Hit{ID: item.Id, Score: item.Score, Version: item.Version, Source: item.Source.AsMap()}
+	if item.Explanation != nil {
+		data, err := item.Explanation.MarshalJSON()
+		if err != nil {
+			return hit, err
+		}
+		hit.Explanation = &search.Explanation{}
+		if err := json.Unmarshal(data, hit.Explanation); err != nil {
+			return hit, err
+		}
+	}
+	for field, value := range item.Highlight.AsMap() {
+		fragments, _ := value.([]interface{})
+		if hit.Highlight == nil {
+			hit.Highlight = make(map[string][]string)
+		}
+		for _, fragment := range fragments {
+			hit.Highlight[field] = append(hit.Highlight[field], fmt.Sprint(fragment))
+		}
+	}
+	return hit, nil
+}
+
+func newBucketsFromPb(buckets []*pb.Bucket) []Bucket {
+	var rs []Bucket
+	for _, bucket := range buckets {
+		rs = append(rs, Bucket{
+			Key:     bucket.Key.AsMap(),
+			Count:   bucket.DocCount,
+			Metrics: bucket.Metrics.AsMap(),
+		})
+	}
+	return rs
 }
 
 func (r *selectResp) Error() error {
@@ -154,8 +463,32 @@ func parseValue(field string, value []byte, meta mt.Mapping, cols map[string]sql
 	case mt.FieldTypeNumeric:
 		v, _ := bluge.DecodeNumericFloat64(value)
 		return v, true
-	case mt.FieldTypeText:
+	case mt.FieldTypeText, mt.FieldTypeKeyword:
 		return string(value), true
+	case mt.FieldTypeBoolean:
+		return string(value) == mt.BooleanTrue, true
+	case mt.FieldTypeDatetime:
+		v, err := bluge.DecodeDateTime(value)
+		if err != nil {
+			return nil, false
+		}
+		return v.Format(time.RFC3339Nano), true
+	case mt.FieldTypeGeoPoint:
+		lon, lat, err := bluge.DecodeGeoLonLat(value)
+		if err != nil {
+			return nil, false
+		}
+		return map[string]interface{}{"lon": lon, "lat": lat}, true
+	case mt.FieldTypeVector:
+		v, err := mt.DecodeVector(value)
+		if err != nil {
+			return nil, false
+		}
+		rs := make([]interface{}, len(v))
+		for i := range v {
+			rs[i] = float64(v[i])
+		}
+		return rs, true
 	}
 	return nil, false
 }
@@ -0,0 +1,191 @@
+package poled
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"pole/internal/conf"
+	"pole/internal/poled/meta"
+	sqlParser "pole/internal/poled/sql"
+)
+
+type execOptions struct {
+	shards       []int
+	replica      bool
+	refresh      RefreshPolicy
+	consistency  ReadConsistency
+	maxStaleness time.Duration
+	// task names an update or delete by query.
+	task string
+}
+
+type ExecOption func(op *execOptions)
+
+// WithShards restricts a write to the given shards of the index, the leader
+// uses it to hand the documents of a shard over to the node hosting it.
+func WithShards(shards ...int) ExecOption {
+	return func(op *execOptions) {
+		op.shards = shards
+	}
+}
+
+func newExecOptions(opts []ExecOption) *execOptions {
+	rs := &execOptions{consistency: ReadConsistencyAny}
+	for _, op := range opts {
+		op(rs)
+	}
+	return rs
+}
+
+func containsShard(shards []int, shard int) bool {
+	for _, item := range shards {
+		if item == shard {
+			return true
+		}
+	}
+	return false
+}
+
+func allShards(mapping meta.Mapping) []int {
+	rs := make([]int, mapping.ShardCount())
+	for shard := range rs {
+		rs[shard] = shard
+	}
+	return rs
+}
+
+// shardHost returns the grpc address of the node hosting the shard and
+// whether it is this node, it is called on the leader which hosts the shards
+// without a registered node.
+func (p *Poled) shardHost(mapping meta.Mapping, shard int) (string, bool) {
+	addr, ok := p.meta.NodeAddr(mapping.ShardNode(shard))
+	if !ok || addr == conf.GetGrpcAddr() {
+		return "", true
+	}
+	return addr, false
+}
+
+// checkAvailable fails when one of the wanted shards is hosted by a node
+// which left the cluster, they are only written and read once it joins again
+// or they are restored.
+func (p *Poled) checkAvailable(mapping meta.Mapping, wanted func(shard int) bool) error {
+	for _, shard := range p.meta.UnavailableShards(mapping) {
+		if wanted(shard) {
+			return fmt.Errorf("%w: shard %d of node %s", ErrShardUnavailable, shard, mapping.ShardNode(shard))
+		}
+	}
+	return nil
+}
+
+// remoteShards groups the shards hosted by other nodes by their grpc address.
+func (p *Poled) remoteShards(mapping meta.Mapping) map[string][]int {
+	rs := make(map[string][]int)
+	for shard := 0; shard < mapping.ShardCount(); shard++ {
+		if addr, local := p.shardHost(mapping, shard); !local {
+			rs[addr] = append(rs[addr], shard)
+		}
+	}
+	return rs
+}
+
+// execShards runs a statement routed to the shards hosted here, or to the
+// copies kept here with the replica option.
+func (p *Poled) execShards(stmt *sqlParser.SqlVistor, options *execOptions) result {
+	idx := stmt.TableName
+	writers := p.writers
+	if options.replica {
+		writers = p.replicaWriters
+	}
+	switch stmt.ActionType {
+	case sqlParser.StmtTypeCreate:
+		// the mapping may not be applied here yet, the shard count is read
+		// from the statement
+		count, err := stmt.ShardCount()
+		if err != nil {
+			return newGeneralResult(err)
+		}
+		mapping := meta.Mapping{Shards: count}
+		for _, shard := range options.shards {
+			if _, ok := writers.Get(mapping.ShardName(idx, shard)); !ok {
+				return newGeneralResult(ErrWriterCreateFailed)
+			}
+		}
+		return newGeneralResult(nil)
+	case sqlParser.StmtTypeDrop:
+		mapping, exists := p.meta.Get(idx)
+		if !exists {
+			return newGeneralResult(ErrIndexNotFound)
+		}
+		p.releaseShards(idx, mapping, options.shards)
+		return newGeneralResult(nil)
+	case sqlParser.StmtTypeInsert, sqlParser.StmtTypeUpdate, sqlParser.StmtTypeDelete, sqlParser.StmtTypeTransaction:
+		return p.execWrite(stmt, options)
+	case sqlParser.StmtTypeRefresh:
+		return p.execRefresh(stmt, options)
+	case sqlParser.StmtTypeRestore:
+		return p.execRestore(stmt, options)
+	}
+	return newGeneralResult(ErrSyntaxNotSupported)
+}
+
+// releaseShards drops the readers of the index and closes the writers of the
+// shards and of their copies, of all shards when shards is nil.
+func (p *Poled) releaseShards(idx string, mapping meta.Mapping, shards []int) {
+	for shard, name := range mapping.ShardNames(idx) {
+		p.readers.Delete(name)
+		p.replicaReaders.Delete(name)
+		if shards == nil || containsShard(shards, shard) {
+			_ = p.writers.Drop(name)
+			_ = p.replicaWriters.Drop(name)
+		}
+	}
+}
+
+// shardLocks locks the shards by name, a write holds the locks of the shards
+// it writes from reading their documents to writing the merged ones.
+type shardLocks struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newShardLocks() *shardLocks {
+	return &shardLocks{locks: make(map[string]*sync.Mutex)}
+}
+
+// writeLockName is the lock of the shard name, the copy of a shard kept as a
+// replica has its own.
+func writeLockName(name string, replica bool) string {
+	if replica {
+		return "replica/" + name
+	}
+	return name
+}
+
+// lock locks the shards in order, so two writes never wait for each other,
+// and returns the unlock.
+func (l *shardLocks) lock(names []string) func() {
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+	held := make([]*sync.Mutex, 0, len(names))
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+		l.Lock()
+		lock, ok := l.locks[name]
+		if !ok {
+			lock = &sync.Mutex{}
+			l.locks[name] = lock
+		}
+		l.Unlock()
+		lock.Lock()
+		held = append(held, lock)
+	}
+	return func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Unlock()
+		}
+	}
+}
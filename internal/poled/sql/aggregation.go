@@ -0,0 +1,253 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"pole/internal/poled/meta"
+
+	"github.com/blugelabs/bluge/numeric"
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/aggregations"
+	"github.com/pingcap/tidb/parser/ast"
+)
+
+const (
+	AggFuncCount         = "count"
+	AggFuncCountDistinct = "count_distinct"
+	AggFuncSum           = "sum"
+	AggFuncAvg           = "avg"
+	AggFuncMin           = "min"
+	AggFuncMax           = "max"
+)
+
+// rangeFunc is the GROUP BY pseudo function bucketing a numeric column by
+// boundaries, ranges(price, 100, 500) gives the buckets *-100, 100-500 and 500-*.
+const rangeFunc = "ranges"
+
+var ErrAggregation = errors.New("invalid aggregation")
+
+// reservedAggregations are the standard aggregations of every search request.
+var reservedAggregations = map[string]bool{"count": true, "max_score": true, "duration": true}
+
+// Aggregation is an aggregate function of the select field list, Name is the
+// alias or the function as written such as "avg(price)".
+type Aggregation struct {
+	Name   string
+	Func   string
+	Column string
+}
+
+// GroupBy is a GROUP BY item, a column bucketed by its terms or by numeric
+// ranges when Ranges is set.
+type GroupBy struct {
+	Column string
+	Ranges []float64
+}
+
+func newAggregation(field *ast.SelectField, node *ast.AggregateFuncExpr) Aggregation {
+	rs := Aggregation{Func: strings.ToLower(node.F)}
+	if len(node.Args) == 1 {
+		if column, ok := node.Args[0].(*ast.ColumnNameExpr); ok {
+			rs.Column = columnName(column.Name)
+		}
+	}
+	if rs.Func == AggFuncCount && node.Distinct {
+		rs.Func = AggFuncCountDistinct
+	}
+
+	rs.Name = field.AsName.O
+	if rs.Name == "" {
+		column := rs.Column
+		if column == "" {
+			column = "*"
+		}
+		if node.Distinct {
+			column = "distinct " + column
+		}
+		rs.Name = fmt.Sprintf("%s(%s)", strings.ToLower(node.F), column)
+	}
+	return rs
+}
+
+// newGroupBy converts a GROUP BY item, unsupported items give an empty
+// GroupBy rejected when the request is built.
+func newGroupBy(item *ast.ByItem) GroupBy {
+	switch expr := item.Expr.(type) {
+	case *ast.ColumnNameExpr:
+		return GroupBy{Column: columnName(expr.Name)}
+	case *ast.FuncCallExpr:
+		if expr.FnName.L != rangeFunc || len(expr.Args) < 2 {
+			return GroupBy{}
+		}
+		column, ok := expr.Args[0].(*ast.ColumnNameExpr)
+		if !ok {
+			return GroupBy{}
+		}
+		rs := GroupBy{Column: columnName(column.Name)}
+		for _, arg := range expr.Args[1:] {
+			v, ok := literalValue(arg)
+			if !ok {
+				return GroupBy{}
+			}
+			f, err := strconv.ParseFloat(fmt.Sprintf("%v", v), 64)
+			if err != nil || (len(rs.Ranges) > 0 && f <= rs.Ranges[len(rs.Ranges)-1]) {
+				return GroupBy{}
+			}
+			rs.Ranges = append(rs.Ranges, f)
+		}
+		return rs
+	}
+	return GroupBy{}
+}
+
+var aggFuncs = map[string]bool{
+	AggFuncCount:         true,
+	AggFuncCountDistinct: true,
+	AggFuncSum:           true,
+	AggFuncAvg:           true,
+	AggFuncMin:           true,
+	AggFuncMax:           true,
+}
+
+// build converts the function, columns missing from the mapping are read as
+// they are the same way the where clause falls back for them.
+func (a Aggregation) build(m meta.Mapping) (search.Aggregation, error) {
+	if !aggFuncs[a.Func] {
+		return nil, fmt.Errorf("%w: %s", ErrSyntaxNotSupported, a.Name)
+	}
+	if a.Func == AggFuncCount {
+		return aggregations.CountMatches(), nil
+	}
+	if a.Column == "" {
+		return nil, fmt.Errorf("%w: %s requires a column", ErrAggregation, a.Name)
+	}
+	if a.Func == AggFuncCountDistinct {
+		return aggregations.Cardinality(termsSource(a.Column, m)), nil
+	}
+
+	if options, ok := m.Properties[a.Column]; ok && options.Type != meta.FieldTypeNumeric {
+		return nil, fmt.Errorf("%w: %s requires a numeric column", ErrAggregation, a.Name)
+	}
+	src := search.Field(a.Column)
+	switch a.Func {
+	case AggFuncSum:
+		return aggregations.Sum(src), nil
+	case AggFuncAvg:
+		return aggregations.Avg(src), nil
+	case AggFuncMin:
+		return aggregations.Min(src), nil
+	default:
+		return aggregations.Max(src), nil
+	}
+}
+
+func (g GroupBy) build(m meta.Mapping, size int, sub map[string]search.Aggregation) (search.Aggregation, error) {
+	if g.Column == "" {
+		return nil, fmt.Errorf("%w: group by only supports columns and %s(column, bound...)", ErrSyntaxNotSupported, rangeFunc)
+	}
+	options, ok := m.Properties[g.Column]
+
+	if len(g.Ranges) > 0 {
+		if ok && options.Type != meta.FieldTypeNumeric {
+			return nil, fmt.Errorf("%w: %s requires a numeric column", ErrAggregation, rangeFunc)
+		}
+		rs := aggregations.Ranges(search.Field(g.Column))
+		for i := 0; i <= len(g.Ranges); i++ {
+			low, high := math.Inf(-1), math.Inf(1)
+			if i > 0 {
+				low = g.Ranges[i-1]
+			}
+			if i < len(g.Ranges) {
+				high = g.Ranges[i]
+			}
+			rs.AddRange(aggregations.NamedRange(RangeName(low, high), low, high))
+		}
+		for name, agg := range sub {
+			rs.AddAggregation(name, agg)
+		}
+		return &rangeAggregation{RangeAggregation: rs, sub: sub}, nil
+	}
+
+	// text is grouped by its analyzed tokens rather than by whole values,
+	// a keyword column has to be used instead
+	switch options.Type {
+	case meta.FieldTypeText, meta.FieldTypeGeoPoint, meta.FieldTypeVector:
+		return nil, fmt.Errorf("%w: can not group by %s field %s", ErrAggregation, options.Type, g.Column)
+	}
+	rs := aggregations.NewTermsAggregation(termsSource(g.Column, m), size)
+	for name, agg := range sub {
+		rs.AddAggregation(name, agg)
+	}
+	return rs, nil
+}
+
+// rangeAggregation adds the fields of the nested aggregations that
+// aggregations.RangeAggregation leaves out, without them the doc values nested
+// aggregations read are never loaded.
+type rangeAggregation struct {
+	*aggregations.RangeAggregation
+	sub map[string]search.Aggregation
+}
+
+func (a *rangeAggregation) Fields() []string {
+	rs := a.RangeAggregation.Fields()
+	for _, agg := range a.sub {
+		rs = append(rs, agg.Fields()...)
+	}
+	return rs
+}
+
+// RangeName names the bucket [low, high) of a range group, open ends are
+// written as *.
+func RangeName(low, high float64) string {
+	bound := func(v float64) string {
+		if math.IsInf(v, 0) {
+			return "*"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return bound(low) + "-" + bound(high)
+}
+
+// termsSource reads the doc values of a column, numeric and datetime values
+// are indexed with extra shifted terms which must not become buckets.
+func termsSource(column string, m meta.Mapping) search.TextValuesSource {
+	switch m.Properties[column].Type {
+	case meta.FieldTypeNumeric, meta.FieldTypeDatetime:
+		return search.FilterText(search.Field(column), func(term []byte) bool {
+			shift, err := numeric.PrefixCoded(term).Shift()
+			return err == nil && shift == 0
+		})
+	}
+	return search.Field(column)
+}
+
+// buildAggregations returns the aggregations to add to the search request,
+// group by columns are nested in order with the metrics at the innermost level.
+func (s *SqlVistor) buildAggregations(m meta.Mapping, size int) (map[string]search.Aggregation, error) {
+	metrics := make(map[string]search.Aggregation, len(s.Aggregations))
+	for _, agg := range s.Aggregations {
+		if _, ok := metrics[agg.Name]; ok || (reservedAggregations[agg.Name] && agg.Func != AggFuncCount) {
+			return nil, fmt.Errorf("%w: duplicate name %s", ErrAggregation, agg.Name)
+		}
+		built, err := agg.build(m)
+		if err != nil {
+			return nil, err
+		}
+		metrics[agg.Name] = built
+	}
+
+	rs := metrics
+	for i := len(s.GroupBy) - 1; i >= 0; i-- {
+		built, err := s.GroupBy[i].build(m, size, rs)
+		if err != nil {
+			return nil, err
+		}
+		rs = map[string]search.Aggregation{s.GroupBy[i].Column: built}
+	}
+	return rs, nil
+}
@@ -0,0 +1,96 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+
+	"pole/internal/poled/meta"
+
+	"github.com/blugelabs/bluge"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/format"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/opcode"
+)
+
+// ByQuery reports whether an update or delete selects its documents by a
+// query rather than by their ids.
+func (s *SqlVistor) ByQuery() bool {
+	if s.ActionType != StmtTypeUpdate && s.ActionType != StmtTypeDelete {
+		return false
+	}
+	_, err := s.ids()
+	return err != nil
+}
+
+// BuildQuery builds the query of the WHERE clause, all documents match
+// without one.
+func (s *SqlVistor) BuildQuery(meta meta.Mapping) (bluge.Query, error) {
+	where := s.condition()
+	if where == nil {
+		return bluge.NewMatchAllQuery(), nil
+	}
+	visitor := NewBinaryOperationVisitor()
+	where.Accept(visitor)
+	return visitor.buildQuery(meta)
+}
+
+// Limit returns the LIMIT of the statement, 0 without one.
+func (s *SqlVistor) Limit() int {
+	return s.limit
+}
+
+// WithIds returns the statement restricted to the ids, an update or delete
+// by query is applied as these so that the raft log, the translog and the
+// copies of the shards get the same writes whatever their documents are. The
+// version condition is kept.
+func (s *SqlVistor) WithIds(ids []string) (*SqlVistor, error) {
+	in := &ast.PatternInExpr{
+		Expr: columnExpr("id"),
+		List: make([]ast.ExprNode, 0, len(ids)),
+	}
+	for _, id := range ids {
+		in.List = append(in.List, ast.NewValueExpr(id, "", ""))
+	}
+	var where ast.ExprNode = in
+	if version, ok := s.Version(); ok {
+		where = &ast.BinaryOperationExpr{Op: opcode.LogicAnd, L: in, R: &ast.BinaryOperationExpr{
+			Op: opcode.EQ,
+			L:  columnExpr(meta.VersionField),
+			R:  ast.NewValueExpr(version, "", ""),
+		}}
+	}
+
+	var node ast.Node
+	switch stmt := s.stmt.(type) {
+	case *ast.UpdateStmt:
+		rs := *stmt
+		rs.Where, rs.Order, rs.Limit = where, nil, nil
+		node = &rs
+	case *ast.DeleteStmt:
+		rs := *stmt
+		rs.Where, rs.Order, rs.Limit = where, nil, nil
+		node = &rs
+	default:
+		return nil, errDeleteCondition
+	}
+
+	sql, err := restore(node)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(sql)
+}
+
+func columnExpr(name string) *ast.ColumnNameExpr {
+	return &ast.ColumnNameExpr{Name: &ast.ColumnName{Name: model.NewCIStr(name)}}
+}
+
+// restore writes a node back as sql.
+func restore(node ast.Node) (string, error) {
+	var sb strings.Builder
+	if err := node.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &sb)); err != nil {
+		return "", fmt.Errorf("%w: %T can not be restored: %v", ErrSyntaxNotSupported, node, err)
+	}
+	return sb.String(), nil
+}
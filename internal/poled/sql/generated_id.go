@@ -0,0 +1,118 @@
+package sql
+
+import (
+	"fmt"
+
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"github.com/rs/xid"
+)
+
+// GenerateIds gives the rows of an insert without an id column, or with a
+// NULL id, an id of their own. The ids are sortable by creation time and
+// written into the sql so every node replaying it writes the same ones. It
+// returns the statement unchanged and no ids when there is nothing to
+// generate. An insert of values without a column list fails, the mapping
+// keeps no order of its columns to match the values to.
+func (s *SqlVistor) GenerateIds() (*SqlVistor, []string, error) {
+	if s.ActionType == StmtTypeTransaction {
+		return s.generateTransactionIds()
+	}
+	insert, ok := s.stmt.(*ast.InsertStmt)
+	if !ok || insert.Select != nil {
+		return s, nil, nil
+	}
+
+	rs := *insert
+	var ids []string
+	newId := func() ast.ExprNode {
+		id := xid.New().String()
+		ids = append(ids, id)
+		return ast.NewValueExpr(id, "", "")
+	}
+	switch {
+	case len(insert.Setlist) > 0:
+		rs.Setlist = make([]*ast.Assignment, 0, len(insert.Setlist)+1)
+		column := idAssignment(insert.Setlist)
+		if column < 0 {
+			rs.Setlist = append(rs.Setlist, &ast.Assignment{Column: &ast.ColumnName{Name: model.NewCIStr("id")}, Expr: newId()})
+		}
+		for i, assignment := range insert.Setlist {
+			if i == column && isNull(assignment.Expr) {
+				assignment = &ast.Assignment{Column: assignment.Column, Expr: newId()}
+			}
+			rs.Setlist = append(rs.Setlist, assignment)
+		}
+	case len(insert.Columns) > 0:
+		column := idColumn(insert.Columns)
+		if column < 0 {
+			rs.Columns = append([]*ast.ColumnName{{Name: model.NewCIStr("id")}}, insert.Columns...)
+		}
+		rs.Lists = make([][]ast.ExprNode, 0, len(insert.Lists))
+		for _, list := range insert.Lists {
+			row := append([]ast.ExprNode(nil), list...)
+			if column < 0 {
+				row = append([]ast.ExprNode{newId()}, row...)
+			} else if column < len(row) && isNull(row[column]) {
+				row[column] = newId()
+			}
+			rs.Lists = append(rs.Lists, row)
+		}
+	case len(insert.Lists) > 0:
+		return nil, nil, fmt.Errorf("%w:%s", ErrInsertColumns, s.TableName)
+	}
+	if len(ids) == 0 {
+		return s, nil, nil
+	}
+
+	sql, err := restore(&rs)
+	if err != nil {
+		return nil, nil, err
+	}
+	stmt, err := Parse(sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stmt, ids, nil
+}
+
+func (s *SqlVistor) generateTransactionIds() (*SqlVistor, []string, error) {
+	var ids []string
+	stmts := make([]*SqlVistor, 0, len(s.Stmts))
+	for _, stmt := range s.Stmts {
+		rs, generated, err := stmt.GenerateIds()
+		if err != nil {
+			return nil, nil, err
+		}
+		stmts = append(stmts, rs)
+		ids = append(ids, generated...)
+	}
+	if len(ids) == 0 {
+		return s, nil, nil
+	}
+	return newTransaction(stmts), ids, nil
+}
+
+func idColumn(columns []*ast.ColumnName) int {
+	for i, column := range columns {
+		if column.Name.L == "id" {
+			return i
+		}
+	}
+	return -1
+}
+
+func idAssignment(assignments []*ast.Assignment) int {
+	for i, assignment := range assignments {
+		if assignment.Column.Name.L == "id" {
+			return i
+		}
+	}
+	return -1
+}
+
+func isNull(expr ast.ExprNode) bool {
+	value, ok := expr.(*test_driver.ValueExpr)
+	return ok && value.GetValue() == nil
+}
@@ -0,0 +1,146 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"pole/internal/poled/meta"
+
+	"github.com/blugelabs/bluge/search/highlight"
+	"github.com/pingcap/tidb/parser/ast"
+)
+
+// highlightFunc is the select list pseudo function requesting highlighted
+// fragments of a column, highlight(body, 'fragment_size=100, pre_tag=<b>,
+// post_tag=</b>') the options being optional.
+const highlightFunc = "highlight"
+
+const (
+	HighlightFormatHTML = "html"
+	HighlightFormatANSI = "ansi"
+
+	defaultHighlightFragments = 1
+)
+
+const (
+	highlightAttrFormat       = "format"
+	highlightAttrFragmentSize = "fragment_size"
+	highlightAttrFragments    = "fragments"
+	highlightAttrPreTag       = "pre_tag"
+	highlightAttrPostTag      = "post_tag"
+)
+
+var ErrHighlight = errors.New("invalid highlight")
+
+type Highlight struct {
+	Column       string
+	Format       string
+	FragmentSize int
+	Fragments    int
+	PreTag       string
+	PostTag      string
+	err          error
+}
+
+func newHighlight(node *ast.FuncCallExpr) Highlight {
+	rs := Highlight{Format: HighlightFormatHTML, Fragments: defaultHighlightFragments}
+	if len(node.Args) == 0 || len(node.Args) > 2 {
+		rs.err = fmt.Errorf("%w: %s(column[, options])", ErrHighlight, highlightFunc)
+		return rs
+	}
+	column, ok := node.Args[0].(*ast.ColumnNameExpr)
+	if !ok {
+		rs.err = fmt.Errorf("%w: %s requires a column", ErrHighlight, highlightFunc)
+		return rs
+	}
+	rs.Column = columnName(column.Name)
+	if len(node.Args) == 2 {
+		options, ok := literalValue(node.Args[1])
+		if !ok {
+			rs.err = fmt.Errorf("%w: options must be a string", ErrHighlight)
+			return rs
+		}
+		rs.err = rs.applyOptions(fmt.Sprintf("%v", options))
+	}
+	return rs
+}
+
+func (h *Highlight) applyOptions(options string) error {
+	for _, option := range strings.Split(options, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		i := strings.Index(option, "=")
+		if i < 0 {
+			return fmt.Errorf("%w:%s", ErrHighlight, option)
+		}
+		key, value := strings.ToLower(strings.TrimSpace(option[:i])), strings.TrimSpace(option[i+1:])
+		switch key {
+		case highlightAttrFormat:
+			h.Format = strings.ToLower(value)
+		case highlightAttrFragmentSize, highlightAttrFragments:
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("%w:%s", ErrHighlight, option)
+			}
+			if key == highlightAttrFragments {
+				h.Fragments = n
+			} else {
+				h.FragmentSize = n
+			}
+		case highlightAttrPreTag:
+			h.PreTag = value
+		case highlightAttrPostTag:
+			h.PostTag = value
+		default:
+			return fmt.Errorf("%w:%s", ErrHighlight, option)
+		}
+	}
+	return nil
+}
+
+// Validate reports bad options and columns which can not be highlighted.
+func (h Highlight) Validate(m meta.Mapping) error {
+	if h.err != nil {
+		return h.err
+	}
+	switch h.Format {
+	case HighlightFormatHTML:
+	case HighlightFormatANSI:
+		if h.PreTag != "" || h.PostTag != "" {
+			return fmt.Errorf("%w: tags are only supported by the %s format", ErrHighlight, HighlightFormatHTML)
+		}
+	default:
+		return fmt.Errorf("%w: unknown format %s", ErrHighlight, h.Format)
+	}
+	if (h.PreTag == "") != (h.PostTag == "") {
+		return fmt.Errorf("%w: %s and %s must be set together", ErrHighlight, highlightAttrPreTag, highlightAttrPostTag)
+	}
+
+	options, ok := m.Properties[h.Column]
+	if !ok {
+		return fmt.Errorf("%w:%s", meta.ErrFieldNotFound, h.Column)
+	}
+	if options.Type != meta.FieldTypeText || !options.Highlighted() {
+		return fmt.Errorf("%w: %s is not a text field with highlight enabled", ErrHighlight, h.Column)
+	}
+	return nil
+}
+
+func (h Highlight) Highlighter() *highlight.SimpleHighlighter {
+	fragmenter := highlight.NewSimpleFragmenter()
+	if h.FragmentSize > 0 {
+		fragmenter = highlight.NewSimpleFragmenterSized(h.FragmentSize)
+	}
+	var formatter highlight.FragmentFormatter = highlight.NewHTMLFragmentFormatter()
+	switch {
+	case h.Format == HighlightFormatANSI:
+		formatter = highlight.NewANSIFragmentFormatter()
+	case h.PreTag != "":
+		formatter = highlight.NewHTMLFragmentFormatterTags(h.PreTag, h.PostTag)
+	}
+	return highlight.NewSimpleHighlighter(fragmenter, formatter, highlight.DefaultSeparator)
+}
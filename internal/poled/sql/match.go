@@ -0,0 +1,217 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"pole/internal/poled/meta"
+
+	"github.com/blugelabs/bluge"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+var (
+	ErrMatchAgainstValue = errors.New("against must be a string value")
+	ErrMatchEmpty        = errors.New("against must not be empty")
+)
+
+const (
+	boostSep      = "^"
+	boostIncrease = 1.5
+	boostDecrease = 0.5
+	defaultFuzzy  = 1
+	maxFuzziness  = 2
+)
+
+type matchField struct {
+	name  string
+	boost float64
+}
+
+type matchClause struct {
+	text      string
+	phrase    bool
+	prefix    bool
+	fuzziness int
+	boost     float64
+}
+
+// buildMatchQuery compiles MATCH(col1, col2^2) AGAINST('text' [modifier]) into
+// bluge queries. Every clause is evaluated as a disjunction across the listed
+// columns, each column keeping its own boost.
+func buildMatchQuery(expr *ast.MatchAgainst, m meta.Mapping) (bluge.Query, error) {
+	value, ok := expr.Against.(*test_driver.ValueExpr)
+	if !ok {
+		return nil, ErrMatchAgainstValue
+	}
+	text, ok := value.GetValue().(string)
+	if !ok {
+		return nil, ErrMatchAgainstValue
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, ErrMatchEmpty
+	}
+
+	fields, err := parseMatchFields(expr.ColumnNames)
+	if err != nil {
+		return nil, err
+	}
+
+	if expr.Modifier.WithQueryExpansion() {
+		return nil, fmt.Errorf("%w: with query expansion", ErrSyntaxNotSupported)
+	}
+	if expr.Modifier.IsBooleanMode() {
+		return buildBooleanModeQuery(text, fields, m)
+	}
+
+	clause := matchClause{text: text, boost: 1}
+	if isQuoted(text) {
+		clause.text = strings.Trim(text, `"`)
+		clause.phrase = true
+	}
+	return clause.query(fields, m), nil
+}
+
+func parseMatchFields(columns []*ast.ColumnName) ([]matchField, error) {
+	fields := make([]matchField, 0, len(columns))
+	for _, column := range columns {
+		name, boost := column.Name.O, 1.0
+		if i := strings.LastIndex(name, boostSep); i > 0 {
+			v, err := strconv.ParseFloat(name[i+1:], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid boost %s", ErrSyntaxNotSupported, name)
+			}
+			name, boost = name[:i], v
+		}
+		if name == "id" {
+			name = meta.IdentifierField
+		}
+		fields = append(fields, matchField{name: name, boost: boost})
+	}
+	return fields, nil
+}
+
+// buildBooleanModeQuery supports the MySQL boolean full-text operators
+// + (must), - (must not), > and < (boost), "phrase" and trailing * (prefix),
+// plus a trailing ~N for fuzzy matching.
+func buildBooleanModeQuery(text string, fields []matchField, m meta.Mapping) (bluge.Query, error) {
+	query := bluge.NewBooleanQuery()
+	clauses := 0
+	for _, token := range splitBooleanTerms(text) {
+		must, mustNot, clause := parseBooleanTerm(token)
+		if clause.text == "" {
+			continue
+		}
+
+		q := clause.query(fields, m)
+		switch {
+		case must:
+			query.AddMust(q)
+		case mustNot:
+			query.AddMustNot(q)
+		default:
+			query.AddShould(q)
+		}
+		clauses++
+	}
+	if clauses == 0 {
+		return nil, ErrMatchEmpty
+	}
+	return query, nil
+}
+
+func parseBooleanTerm(token string) (must, mustNot bool, clause matchClause) {
+	clause.boost = 1
+	for ; len(token) > 0 && strings.ContainsRune("+-><", rune(token[0])); token = token[1:] {
+		switch token[0] {
+		case '+':
+			must = true
+		case '-':
+			mustNot = true
+		case '>':
+			clause.boost *= boostIncrease
+		case '<':
+			clause.boost *= boostDecrease
+		}
+	}
+
+	if i := strings.LastIndex(token, "~"); i > 0 && !isQuoted(token) {
+		clause.fuzziness = defaultFuzzy
+		if n, err := strconv.Atoi(token[i+1:]); err == nil {
+			clause.fuzziness = n
+		}
+		if clause.fuzziness > maxFuzziness {
+			clause.fuzziness = maxFuzziness
+		}
+		token = token[:i]
+	}
+
+	switch {
+	case isQuoted(token):
+		clause.phrase = true
+		token = strings.Trim(token, `"`)
+	case strings.HasSuffix(token, "*"):
+		clause.prefix = true
+		token = strings.TrimRight(token, "*")
+	}
+	clause.text = token
+	return must, mustNot, clause
+}
+
+func (c matchClause) query(fields []matchField, m meta.Mapping) bluge.Query {
+	queries := make([]bluge.Query, 0, len(fields))
+	for _, field := range fields {
+		boost := c.boost * field.boost
+		analyzer, _ := m.Analyzer(field.name)
+		switch {
+		case c.phrase:
+			queries = append(queries, bluge.NewMatchPhraseQuery(c.text).SetField(field.name).SetBoost(boost).SetAnalyzer(analyzer))
+		case c.prefix:
+			queries = append(queries, bluge.NewPrefixQuery(strings.ToLower(c.text)).SetField(field.name).SetBoost(boost))
+		default:
+			q := bluge.NewMatchQuery(c.text).SetField(field.name).SetBoost(boost).SetAnalyzer(analyzer)
+			if c.fuzziness > 0 {
+				q.SetFuzziness(c.fuzziness)
+			}
+			queries = append(queries, q)
+		}
+	}
+	if len(queries) == 1 {
+		return queries[0]
+	}
+	return bluge.NewBooleanQuery().AddShould(queries...)
+}
+
+// splitBooleanTerms splits on whitespace while keeping quoted phrases intact.
+func splitBooleanTerms(text string) []string {
+	var terms []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				terms = append(terms, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		terms = append(terms, current.String())
+	}
+	return terms
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`)
+}
@@ -3,14 +3,17 @@ package sql
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 
 	"pole/internal/poled/meta"
 
 	"github.com/blugelabs/bluge"
-	"github.com/blugelabs/bluge/index"
+	"github.com/blugelabs/bluge/search"
 	"github.com/pingcap/tidb/parser"
 	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tidb/parser/opcode"
 	"github.com/pingcap/tidb/parser/test_driver"
 	"github.com/pingcap/tidb/parser/types"
@@ -25,10 +28,31 @@ const (
 	StmtTypeDrop   stmtType = "drop"
 	StmtTypeUpdate stmtType = "update"
 	StmtTypeSelect stmtType = "select"
+	StmtTypeAlter  stmtType = "alter"
+	// StmtTypeRefresh is REFRESH TABLE, it makes the writes visible.
+	StmtTypeRefresh stmtType = "refresh"
+	// StmtTypeBackup is BACKUP TABLE ... TO 'uri', it snapshots the tables
+	// into a repository.
+	StmtTypeBackup stmtType = "backup"
+	// StmtTypeRestore is RESTORE TABLE ... FROM 'uri'.
+	StmtTypeRestore stmtType = "restore"
+	// StmtTypeTransaction is the writes between BEGIN and COMMIT.
+	StmtTypeTransaction stmtType = "transaction"
+)
+
+type alterType string
+
+const (
+	AlterTypeAddColumn    alterType = "add_column"
+	AlterTypeDropColumn   alterType = "drop_column"
+	AlterTypeModifyColumn alterType = "modify_column"
 )
 
 var (
-	errDeleteCondition = errors.New("update operation's condition must be pattern 'id=xxx'")
+	errDeleteCondition = errors.New("condition must be pattern 'id=xxx' or 'id in (xxx, ...)'")
+	errAlterSpec       = errors.New("alter table only supports add, drop and modify column")
+	ErrPartition       = errors.New("partition must be by hash(id) or key(id)")
+	ErrInsertColumns   = errors.New("insert must list its columns")
 )
 
 const (
@@ -36,181 +60,337 @@ const (
 	defaultLimit  = 10
 )
 
-var parserOnce = sync.Once{}
-var sqlParser *parser.Parser
+// parsers holds the parsers not in use, a parser parses one sql at a time.
+var parsers = sync.Pool{
+	New: func() interface{} {
+		return parser.New()
+	},
+}
 
 func getParser() *parser.Parser {
-	parserOnce.Do(func() {
-		sqlParser = parser.New()
-	})
-	return sqlParser
+	return parsers.Get().(*parser.Parser)
+}
+
+func putParser(p *parser.Parser) {
+	parsers.Put(p)
 }
 
+// Parse parses the first statement of the sql.
 func Parse(sql string) (*SqlVistor, error) {
-	p := getParser()
-	nodes, _, err := p.Parse(sql, "", "")
+	stmts, err := ParseAll(sql)
 	if err != nil {
 		return nil, err
 	}
-
-	return extract(&nodes[0]), nil
+	if len(stmts) == 0 {
+		return nil, ErrNoStatement
+	}
+	return stmts[0], nil
 }
 
 type Col struct {
-	Name string
-	Typ  types.EvalType
+	Name    string
+	Typ     types.EvalType
+	Tp      byte
+	Flen    int
+	Comment string
+	NotNull bool
+	Default interface{}
+}
+
+type AlterSpec struct {
+	Type      alterType
+	Col       Col
+	IfExists  bool
+	IfMissing bool
 }
 
 type SqlVistor struct {
+	// Sql is the text of the statement.
+	Sql           string
 	ActionType    stmtType
+	AlterSpecs    []AlterSpec
 	ColNames      []Col
 	rows          []interface{}
 	where         ast.Node
 	SelectAll     bool
 	TableName     string
+	TableComment  string
 	offset, limit int
 	orderBy       []string
+	Aggregations  []Aggregation
+	GroupBy       []GroupBy
+	Highlights    []Highlight
+	Explain       bool
+	partition     *ast.PartitionOptions
+	// RefreshTables are the tables of REFRESH TABLE, all when empty.
+	RefreshTables []string
+	// BackupTables are the tables of BACKUP and RESTORE, all when empty, and
+	// Repository the uri of their snapshot repository.
+	BackupTables []string
+	Repository   string
+	// Stmts are the writes of a transaction.
+	Stmts []*SqlVistor
+	// Assignments are the SET columns of an update.
+	Assignments []Assignment
+	// Replace is set by REPLACE INTO and Ignore by INSERT IGNORE,
+	// OnDuplicate are the assignments of ON DUPLICATE KEY UPDATE.
+	Replace     bool
+	Ignore      bool
+	OnDuplicate []Assignment
+	stmt        ast.StmtNode
 }
 
-func (s *SqlVistor) docs(metas meta.Mapping) []*bluge.Document {
-	columnCount := len(s.ColNames)
-	var docs []*bluge.Document
-	for i := 0; i < len(s.rows)/len(s.ColNames); i++ {
-		var id string
-		var fields []bluge.Field
-		offset := columnCount * i
-		for j := 0; j < columnCount; j++ {
-			name := s.ColNames[j].Name
-			value := s.rows[offset+j]
-			if name == "id" {
-				id = fmt.Sprintf("%v", value)
-				continue
-			}
-			field, err := metas.MakeField(name, value)
-			if field == nil || err != nil {
-				continue
-			}
-			fields = append(fields, field)
+// inserts builds the documents of the rows of an insert at their first
+// version, their values are kept for the writes after them.
+func (s *SqlVistor) inserts(metas meta.Mapping) ([]Write, error) {
+	if len(s.ColNames) == 0 {
+		return nil, fmt.Errorf("%w:%s", ErrInsertColumns, s.TableName)
+	}
+	var rs []Write
+	for offset := 0; offset+len(s.ColNames) <= len(s.rows); offset += len(s.ColNames) {
+		row := offset/len(s.ColNames) + 1
+		doc, err := s.rowDocument(metas, row, 1)
+		if err != nil {
+			return nil, err
 		}
-		if s.ActionType == StmtTypeUpdate {
-			id, _ = s.getId()
+		values := map[string]interface{}{meta.VersionField: int64(1)}
+		for j, column := range s.ColNames {
+			if value := s.rows[offset+j]; column.Name != "id" && value != nil {
+				values[column.Name] = value
+			}
 		}
+		rs = append(rs, Write{ID: string(doc.ID().Term()), Values: values, Doc: doc, Row: row, Affected: 1})
+	}
+	return rs, nil
+}
 
-		doc := bluge.NewDocument(id)
-		for _, field := range fields {
-			doc.AddField(field)
-		}
-		docs = append(docs, doc)
+// rowDocument builds the document of a row of an insert, counted from 1, at
+// the version.
+func (s *SqlVistor) rowDocument(metas meta.Mapping, row int, version int64) (*bluge.Document, error) {
+	id, fields, present := s.rowFields(metas, (row-1)*len(s.ColNames))
+	defaults, err := metas.MakeDefaultFields(present)
+	if err != nil {
+		return nil, err
 	}
-	return docs
+	fields = append(fields, defaults...)
+	fields = append(fields, metas.MakeVersionField(version))
+	return newDocument(id, fields), nil
 }
 
-func (s *SqlVistor) BuildInsertBatch(meta meta.Mapping) (*index.Batch, error) {
-	if s.ActionType != StmtTypeInsert {
-		return nil, errors.New("not insert operation")
+// rowFields makes the fields of the row starting at offset, present holds
+// the columns given a value.
+func (s *SqlVistor) rowFields(metas meta.Mapping, offset int) (string, []bluge.Field, map[string]struct{}) {
+	var id string
+	var fields []bluge.Field
+	present := make(map[string]struct{}, len(s.ColNames))
+	for j, column := range s.ColNames {
+		name := column.Name
+		value := s.rows[offset+j]
+		if name == "id" {
+			id = fmt.Sprintf("%v", value)
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		field, err := metas.MakeField(name, value)
+		if field == nil || err != nil {
+			continue
+		}
+		present[name] = struct{}{}
+		fields = append(fields, field)
 	}
-	batch := index.NewBatch()
-	docs := s.docs(meta)
-	for _, doc := range docs {
-		batch.Update(doc.ID(), doc)
+	return id, fields, present
+}
+
+func newDocument(id string, fields []bluge.Field) *bluge.Document {
+	doc := bluge.NewDocument(id)
+	for _, field := range fields {
+		doc.AddField(field)
 	}
-	return batch, nil
+	return doc
 }
 
-func (s *SqlVistor) BuildUpdateBatch(meta meta.Mapping) (*index.Batch, error) {
-	if s.ActionType != StmtTypeUpdate {
-		return nil, errors.New("not update operation")
+// ShardCount returns the number of shards given by
+// PARTITION BY HASH(id) PARTITIONS n, an index has one shard without it.
+func (s *SqlVistor) ShardCount() (int, error) {
+	if s.partition == nil {
+		return 1, nil
+	}
+	partition := s.partition
+	if partition.Sub != nil {
+		return 0, fmt.Errorf("%w: subpartitions are not supported", ErrPartition)
+	}
+	switch partition.Tp {
+	case model.PartitionTypeHash:
+		column, ok := partition.Expr.(*ast.ColumnNameExpr)
+		if !ok || !isIdentifier(column.Name) {
+			return 0, ErrPartition
+		}
+	case model.PartitionTypeKey:
+		if len(partition.ColumnNames) != 1 || !isIdentifier(partition.ColumnNames[0]) {
+			return 0, ErrPartition
+		}
+	default:
+		return 0, ErrPartition
 	}
-	batch := index.NewBatch()
 
-	docs := s.docs(meta)
-	for _, doc := range docs {
-		batch.Update(doc.ID(), doc)
+	shards := int(partition.Num)
+	if shards == 0 {
+		shards = len(partition.Definitions)
+	}
+	if shards < 1 || shards > meta.MaxShards {
+		return 0, fmt.Errorf("%w:%d", meta.ErrInvalidShards, shards)
 	}
-	return batch, nil
+	return shards, nil
 }
 
-func (s *SqlVistor) BuildDeleteBatch(meta meta.Mapping) (*index.Batch, error) {
-	if s.ActionType != StmtTypeDelete {
-		return nil, errors.New("not delete operation")
-	}
-	batch := index.NewBatch()
-	id, err := s.getId()
+func isIdentifier(column *ast.ColumnName) bool {
+	return columnName(column) == meta.IdentifierField
+}
+
+func (s *SqlVistor) BuildRequest(meta meta.Mapping) (bluge.SearchRequest, error) {
+	offset, limit := s.getPageInfo()
+	req, err := s.buildSearch(meta, limit)
 	if err != nil {
 		return nil, err
 	}
-	batch.Delete(bluge.Identifier(id))
+	return req.SetFrom(offset), nil
+}
 
-	return batch, nil
+// BuildPage builds the request of a page of size hits of a streamed select,
+// the first page starts at the offset and the next ones after the sort
+// values of the last hit read. The hits are ordered by _id after the order by
+// columns so no hit is skipped or read twice between pages.
+func (s *SqlVistor) BuildPage(mapping meta.Mapping, size int, after [][]byte) (bluge.SearchRequest, error) {
+	req, err := s.buildSearch(mapping, size)
+	if err != nil {
+		return nil, err
+	}
+	req.SortByCustom(append(req.SortOrder().Copy(), search.SortBy(search.Field(meta.IdentifierField))))
+	if after != nil {
+		return req.After(after), nil
+	}
+	offset, _ := s.getPageInfo()
+	return req.SetFrom(offset), nil
 }
 
-func (s *SqlVistor) BuildRequest(meta meta.Mapping) (bluge.SearchRequest, error) {
+func (s *SqlVistor) buildSearch(meta meta.Mapping, size int) (*bluge.TopNSearch, error) {
+	query, err := s.BuildQuery(meta)
+	if err != nil {
+		return nil, err
+	}
 
-	var query bluge.Query
-	if s.where == nil {
-		query = bluge.NewMatchAllQuery()
-	} else {
-		visitor := NewBinaryOperationVisitor()
-		s.where.Accept(visitor)
-		var err error
-		query, err = visitor.buildQuery(meta)
-		if err != nil {
+	for _, h := range s.Highlights {
+		if err := h.Validate(meta); err != nil {
 			return nil, err
 		}
 	}
 
-	offset, limit := s.getPageInfo()
-
-	req := bluge.NewTopNSearch(limit, query).WithStandardAggregations().
-		IncludeLocations().
-		SetFrom(offset).
-		SortBy(s.orderBy).
-		ExplainScores()
-	return req, nil
-}
-
-func (s *SqlVistor) getId() (string, error) {
-	if s.where == nil {
-		return "", errDeleteCondition
-	}
-	where, ok := s.where.(*ast.BinaryOperationExpr)
-	if !ok {
-		return "", errDeleteCondition
+	req := bluge.NewTopNSearch(size, query).WithStandardAggregations().
+		IncludeLocations()
+	if len(s.orderBy) > 0 {
+		order, err := sortOrder(s.orderBy)
+		if err != nil {
+			return nil, err
+		}
+		req.SortByCustom(order)
 	}
-	if where.Op != opcode.EQ {
-		return "", errDeleteCondition
+	if s.Explain {
+		req.ExplainScores()
 	}
 
-	columnName, ok := where.L.(*ast.ColumnNameExpr)
-	if !ok {
-		return "", errDeleteCondition
+	_, limit := s.getPageInfo()
+	aggs, err := s.buildAggregations(meta, limit)
+	if err != nil {
+		return nil, err
 	}
+	for name, agg := range aggs {
+		req.AddAggregation(name, agg)
+	}
+	return req, nil
+}
 
-	if columnName.Name.Name.O != "id" {
-		return "", errDeleteCondition
+// sortOrder converts the order by columns, a leading - meaning descending.
+// _score is handled here as search.ParseSearchSortString always sorts it
+// descending.
+func sortOrder(orderBy []string) (search.SortOrder, error) {
+	rs := make(search.SortOrder, 0, len(orderBy))
+	for _, item := range orderBy {
+		column := strings.TrimPrefix(item, "-")
+		switch column {
+		case "":
+			return nil, fmt.Errorf("%w: order by only supports columns", ErrSyntaxNotSupported)
+		case meta.ScoreField:
+			sort := search.SortBy(search.DocumentScore())
+			if column != item {
+				sort.Desc()
+			}
+			rs = append(rs, sort)
+		default:
+			rs = append(rs, search.ParseSearchSortString(item))
+		}
 	}
+	return rs, nil
+}
 
-	value, ok := where.R.(*test_driver.ValueExpr)
-	if !ok {
-		return "", errDeleteCondition
+// ids returns the ids of WHERE id = v or WHERE id IN (v, ...), other
+// conditions are run by query.
+func (s *SqlVistor) ids() ([]string, error) {
+	switch where := s.condition().(type) {
+	case *ast.BinaryOperationExpr:
+		if where.Op != opcode.EQ || !isIdColumn(where.L) {
+			return nil, errDeleteCondition
+		}
+		value, ok := where.R.(*test_driver.ValueExpr)
+		if !ok {
+			return nil, errDeleteCondition
+		}
+		return []string{fmt.Sprintf("%v", value.GetValue())}, nil
+	case *ast.PatternInExpr:
+		if where.Not || where.Sel != nil || !isIdColumn(where.Expr) {
+			return nil, errDeleteCondition
+		}
+		rs := make([]string, 0, len(where.List))
+		for _, item := range where.List {
+			value, ok := item.(*test_driver.ValueExpr)
+			if !ok {
+				return nil, errDeleteCondition
+			}
+			rs = append(rs, fmt.Sprintf("%v", value.GetValue()))
+		}
+		return rs, nil
 	}
+	return nil, errDeleteCondition
+}
 
-	return fmt.Sprintf("%v", value.GetValue()), nil
+func isIdColumn(expr ast.ExprNode) bool {
+	column, ok := expr.(*ast.ColumnNameExpr)
+	return ok && column.Name.Name.O == "id"
 }
 
 func (s *SqlVistor) Enter(in ast.Node) (ast.Node, bool) {
 	switch node := in.(type) {
 	case *ast.InsertStmt:
 		s.ActionType = StmtTypeInsert
+		s.Replace, s.Ignore = node.IsReplace, node.IgnoreErr
+		for _, assignment := range node.OnDuplicate {
+			s.OnDuplicate = append(s.OnDuplicate, Assignment{Column: assignment.Column.Name.O, Expr: assignment.Expr})
+		}
 	case *ast.CreateTableStmt:
 		s.ActionType = StmtTypeCreate
+		for _, option := range node.Options {
+			if option.Tp == ast.TableOptionComment {
+				s.TableComment = option.StrValue
+			}
+		}
+	case *ast.PartitionOptions:
+		s.partition = node
+		return in, true
 	case *ast.TableName:
 		s.TableName = node.Name.O
 	case *ast.ColumnDef:
-		s.ColNames = append(s.ColNames, Col{
-			Name: node.Name.Name.O,
-			Typ:  node.Tp.EvalType(),
-		})
+		s.ColNames = append(s.ColNames, newColumnDef(node))
 		return in, true
 	case *ast.ColumnName:
 		s.ColNames = append(s.ColNames, Col{
@@ -223,25 +403,80 @@ func (s *SqlVistor) Enter(in ast.Node) (ast.Node, bool) {
 		s.ActionType = StmtTypeDelete
 	case *ast.DropTableStmt:
 		s.ActionType = StmtTypeDrop
+	case *ast.AlterTableStmt:
+		s.ActionType = StmtTypeAlter
+		s.TableName = node.Table.Name.O
+		s.AlterSpecs = newAlterSpecs(node.Specs)
+		return in, true
 	case *ast.UpdateStmt:
 		s.ActionType = StmtTypeUpdate
-	case *ast.BinaryOperationExpr, *ast.PatternInExpr, *ast.PatternLikeExpr:
+	case *ast.Assignment:
+		if s.ActionType == StmtTypeUpdate {
+			s.Assignments = append(s.Assignments, Assignment{Column: node.Column.Name.O, Expr: node.Expr})
+			return in, true
+		}
+		// the columns of ON DUPLICATE KEY UPDATE are not the inserted ones
+		if insert, ok := s.stmt.(*ast.InsertStmt); ok {
+			for _, assignment := range insert.OnDuplicate {
+				if assignment == node {
+					return in, true
+				}
+			}
+		}
+	case *ast.BinaryOperationExpr, *ast.PatternInExpr, *ast.PatternLikeExpr, *ast.MatchAgainst:
 		if s.TableName != "" {
 			s.where = node
 		}
 		return in, true
+	case *ast.FlushStmt:
+		if node.Tp == ast.FlushTables {
+			s.ActionType = StmtTypeRefresh
+			for _, table := range node.Tables {
+				s.RefreshTables = append(s.RefreshTables, table.Name.O)
+			}
+		}
+		return in, true
+	case *ast.BRIEStmt:
+		s.ActionType = StmtTypeBackup
+		if node.Kind == ast.BRIEKindRestore {
+			s.ActionType = StmtTypeRestore
+		}
+		s.Repository = node.Storage
+		for _, table := range node.Tables {
+			s.BackupTables = append(s.BackupTables, table.Name.O)
+		}
+		if len(s.BackupTables) == 1 {
+			s.TableName = s.BackupTables[0]
+		}
+		return in, true
 	case *ast.SelectStmt:
 		s.ActionType = StmtTypeSelect
+	case *ast.ExplainStmt:
+		s.Explain = true
 	case *ast.FieldList:
 		for _, field := range node.Fields {
 			if field.WildCard != nil {
 				s.SelectAll = true
-				break
+				continue
 			}
-			s.ColNames = append(s.ColNames, Col{
-				Name: field.Expr.(*ast.ColumnNameExpr).Name.Name.O,
-				Typ:  types.ETInt,
-			})
+			switch expr := field.Expr.(type) {
+			case *ast.ColumnNameExpr:
+				s.ColNames = append(s.ColNames, Col{
+					Name: expr.Name.Name.O,
+					Typ:  types.ETInt,
+				})
+			case *ast.AggregateFuncExpr:
+				s.Aggregations = append(s.Aggregations, newAggregation(field, expr))
+			case *ast.FuncCallExpr:
+				if expr.FnName.L == highlightFunc {
+					s.Highlights = append(s.Highlights, newHighlight(expr))
+				}
+			}
+		}
+		return in, true
+	case *ast.GroupByClause:
+		for _, item := range node.Items {
+			s.GroupBy = append(s.GroupBy, newGroupBy(item))
 		}
 		return in, true
 	case *ast.Limit:
@@ -257,10 +492,12 @@ func (s *SqlVistor) Enter(in ast.Node) (ast.Node, bool) {
 	case *ast.OrderByClause:
 		orderBy := make([]string, 0, len(node.Items))
 		for _, item := range node.Items {
-			column := item.Expr.(*ast.ColumnNameExpr).Name.Name.O
-			if column == "id" {
-				column = meta.IdentifierField
+			expr, ok := item.Expr.(*ast.ColumnNameExpr)
+			if !ok {
+				orderBy = append(orderBy, "")
+				continue
 			}
+			column := columnName(expr.Name)
 			if item.Desc {
 				column = fmt.Sprintf("-%s", column)
 			}
@@ -276,6 +513,11 @@ func (s *SqlVistor) Leave(in ast.Node) (ast.Node, bool) {
 	return in, true
 }
 
+// Page returns the offset and the limit of a select, 10 hits without LIMIT.
+func (s *SqlVistor) Page() (int, int) {
+	return s.getPageInfo()
+}
+
 func (s *SqlVistor) getPageInfo() (int, int) {
 	if s.offset == 0 {
 		s.offset = defaultOffset
@@ -286,8 +528,93 @@ func (s *SqlVistor) getPageInfo() (int, int) {
 	return s.offset, s.limit
 }
 
+func newColumnDef(node *ast.ColumnDef) Col {
+	col := Col{
+		Name: node.Name.Name.O,
+		Typ:  node.Tp.EvalType(),
+		Tp:   node.Tp.Tp,
+		Flen: node.Tp.Flen,
+	}
+	for _, option := range node.Options {
+		switch option.Tp {
+		case ast.ColumnOptionNotNull:
+			col.NotNull = true
+		case ast.ColumnOptionNull:
+			col.NotNull = false
+		case ast.ColumnOptionComment:
+			if v, ok := literalValue(option.Expr); ok {
+				col.Comment = fmt.Sprintf("%v", v)
+			}
+		case ast.ColumnOptionDefaultValue:
+			col.Default, _ = literalValue(option.Expr)
+		}
+	}
+	return col
+}
+
+func newAlterSpecs(specs []*ast.AlterTableSpec) []AlterSpec {
+	var rs []AlterSpec
+	for _, spec := range specs {
+		switch spec.Tp {
+		case ast.AlterTableAddColumns:
+			for _, column := range spec.NewColumns {
+				rs = append(rs, AlterSpec{Type: AlterTypeAddColumn, Col: newColumnDef(column), IfMissing: spec.IfNotExists})
+			}
+		case ast.AlterTableDropColumn:
+			rs = append(rs, AlterSpec{Type: AlterTypeDropColumn, Col: Col{Name: spec.OldColumnName.Name.O}, IfExists: spec.IfExists})
+		case ast.AlterTableModifyColumn:
+			for _, column := range spec.NewColumns {
+				rs = append(rs, AlterSpec{Type: AlterTypeModifyColumn, Col: newColumnDef(column), IfExists: spec.IfExists})
+			}
+		default:
+			rs = append(rs, AlterSpec{})
+		}
+	}
+	return rs
+}
+
+// Validate reports alter specifications that are not supported.
+func (s AlterSpec) Validate() error {
+	if s.Type == "" {
+		return errAlterSpec
+	}
+	return nil
+}
+
+// literalValue evaluates constant expressions such as 'abc', 1.5 or -1 into
+// plain go values.
+func literalValue(expr ast.ExprNode) (interface{}, bool) {
+	switch node := expr.(type) {
+	case *test_driver.ValueExpr:
+		switch v := node.GetValue().(type) {
+		case *test_driver.MyDecimal:
+			f, err := strconv.ParseFloat(v.String(), 64)
+			return f, err == nil
+		default:
+			return v, true
+		}
+	case *ast.UnaryOperationExpr:
+		if node.Op != opcode.Minus {
+			return nil, false
+		}
+		v, ok := literalValue(node.V)
+		if !ok {
+			return nil, false
+		}
+		switch v := v.(type) {
+		case int64:
+			return -v, true
+		case uint64:
+			return -int64(v), true
+		case float64:
+			return -v, true
+		}
+	}
+	return nil, false
+}
+
 func extract(rootNode *ast.StmtNode) *SqlVistor {
-	v := &SqlVistor{}
+	v := &SqlVistor{Sql: strings.TrimSuffix(strings.TrimSpace((*rootNode).Text()), ";"), stmt: *rootNode}
 	(*rootNode).Accept(v)
 	return v
 }
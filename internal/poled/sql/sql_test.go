@@ -1,8 +1,14 @@
 package sql
 
 import (
+	"errors"
+	"fmt"
 	"pole/internal/poled/meta"
+	"reflect"
 	"testing"
+
+	"github.com/blugelabs/bluge"
+	"github.com/pingcap/tidb/parser/ast"
 )
 
 func TestParse(t *testing.T) {
@@ -16,6 +22,11 @@ func TestParse(t *testing.T) {
 			sql:  "create table test (id int(10) not null,Name varchar(255) not null)",
 			want: nil,
 		},
+		{
+			name: "create-options",
+			sql:  "create table test (id int(10) not null,Name varchar(255) default 'none' comment 'analyzer=chinese, store=false') comment='sortable=false'",
+			want: nil,
+		},
 		{
 			name: "insert",
 			sql:  "insert into test (id,Name) values (1,'hello'),(2,'world')",
@@ -26,6 +37,16 @@ func TestParse(t *testing.T) {
 			sql:  "insert into test set id=1,Name='hello'",
 			want: nil,
 		},
+		{
+			name: "replace",
+			sql:  "replace into test (id,Name) values (1,'hello')",
+			want: nil,
+		},
+		{
+			name: "insert-on-duplicate",
+			sql:  "insert into test (id,views) values (1,1) on duplicate key update views = views + values(views)",
+			want: nil,
+		},
 		{
 			name: "select-all",
 			sql:  "select * from test where Name='hello' group by Name order by id desc limit 0 ,10",
@@ -51,11 +72,31 @@ func TestParse(t *testing.T) {
 			sql:  "select Name,sex from test where Name='hello' and (id=1 or Name=3)",
 			want: nil,
 		},
+		{
+			name: "alter",
+			sql:  "alter table test add column age int, drop column sex, modify column Name text comment 'analyzer=en'",
+			want: nil,
+		},
 		{
 			name: "drop",
 			sql:  "drop table test",
 			want: nil,
 		},
+		{
+			name: "refresh",
+			sql:  "REFRESH TABLE test, test2",
+			want: nil,
+		},
+		{
+			name: "backup",
+			sql:  "BACKUP TABLE test TO 'file:///backups/test'",
+			want: nil,
+		},
+		{
+			name: "restore",
+			sql:  "RESTORE DATABASE * FROM 's3://backups/pole'",
+			want: nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -119,3 +160,763 @@ func TestBuildRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildMatchQuery(t *testing.T) {
+	build := func(sql string) (bluge.Query, error) {
+		rs, err := Parse(sql)
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		return buildMatchQuery(rs.stmt.(*ast.SelectStmt).Where.(*ast.MatchAgainst), meta.Mapping{})
+	}
+
+	q, err := build("select * from test where match(title, `body^3`) against('hello world')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := q.(*bluge.BooleanQuery).Shoulds()
+	if len(fields) != 2 {
+		t.Fatalf("Shoulds() = %d, want 2", len(fields))
+	}
+	for i, want := range []struct {
+		field string
+		boost float64
+	}{{"title", 1}, {"body", 3}} {
+		match := fields[i].(*bluge.MatchQuery)
+		if match.Field() != want.field || match.Boost() != want.boost || match.Match() != "hello world" || match.Fuzziness() != 0 {
+			t.Errorf("Shoulds()[%d] = %s^%v %q ~%d, want %s^%v", i, match.Field(), match.Boost(), match.Match(), match.Fuzziness(), want.field, want.boost)
+		}
+	}
+
+	q, err = build("select * from test where match(title) against('\"hello world\"' in natural language mode)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if phrase, ok := q.(*bluge.MatchPhraseQuery); !ok || phrase.Phrase() != "hello world" || phrase.Field() != "title" {
+		t.Errorf("phrase query = %#v, want match phrase on title", q)
+	}
+
+	q, err = build("select * from test where match(`title^2`) against('+hello -world >\"full text\" sear* fuzy~2 typo~5' in boolean mode)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	boolean := q.(*bluge.BooleanQuery)
+	if len(boolean.Musts()) != 1 || len(boolean.MustNots()) != 1 || len(boolean.Shoulds()) != 4 {
+		t.Fatalf("must %d, must not %d, should %d, want 1, 1, 4", len(boolean.Musts()), len(boolean.MustNots()), len(boolean.Shoulds()))
+	}
+	if must := boolean.Musts()[0].(*bluge.MatchQuery); must.Match() != "hello" || must.Boost() != 2 {
+		t.Errorf("must = %q^%v, want hello^2", must.Match(), must.Boost())
+	}
+	if mustNot := boolean.MustNots()[0].(*bluge.MatchQuery); mustNot.Match() != "world" {
+		t.Errorf("must not = %q, want world", mustNot.Match())
+	}
+	should := boolean.Shoulds()
+	if phrase := should[0].(*bluge.MatchPhraseQuery); phrase.Phrase() != "full text" || phrase.Boost() != 2*boostIncrease {
+		t.Errorf("phrase = %q^%v, want full text^%v", phrase.Phrase(), phrase.Boost(), 2*boostIncrease)
+	}
+	if prefix := should[1].(*bluge.PrefixQuery); prefix.Prefix() != "sear" || prefix.Field() != "title" {
+		t.Errorf("prefix = %s:%q, want title:sear", prefix.Field(), prefix.Prefix())
+	}
+	if fuzzy := should[2].(*bluge.MatchQuery); fuzzy.Match() != "fuzy" || fuzzy.Fuzziness() != 2 {
+		t.Errorf("fuzzy = %q~%d, want fuzy~2", fuzzy.Match(), fuzzy.Fuzziness())
+	}
+	if fuzzy := should[3].(*bluge.MatchQuery); fuzzy.Match() != "typo" || fuzzy.Fuzziness() != maxFuzziness {
+		t.Errorf("fuzzy = %q~%d, want typo~%d", fuzzy.Match(), fuzzy.Fuzziness(), maxFuzziness)
+	}
+
+	for sql, want := range map[string]error{
+		"select * from test where match(title) against('helo' with query expansion)": ErrSyntaxNotSupported,
+		"select * from test where match(title) against('+ -' in boolean mode)":       ErrMatchEmpty,
+		"select * from test where match(`title^x`) against('hello')":                 ErrSyntaxNotSupported,
+	} {
+		if _, err := build(sql); !errors.Is(err, want) {
+			t.Errorf("%s: error = %v, want %v", sql, err, want)
+		}
+	}
+}
+
+func TestBuildTypedRequest(t *testing.T) {
+	mapping := meta.Mapping{Properties: map[string]meta.FiledOptions{
+		"tag":      {Type: meta.FieldTypeKeyword},
+		"online":   {Type: meta.FieldTypeBoolean},
+		"created":  {Type: meta.FieldTypeDatetime},
+		"location": {Type: meta.FieldTypeGeoPoint},
+		"embed":    {Type: meta.FieldTypeVector},
+	}}
+	tests := []struct {
+		name string
+		want error
+		sql  string
+	}{
+		{
+			name: "keyword-eq",
+			sql:  "select * from test where tag = 'Go Lang'",
+		},
+		{
+			name: "boolean-eq",
+			sql:  "select * from test where online = true and tag in ('a', 'b')",
+		},
+		{
+			name: "boolean-invalid",
+			sql:  "select * from test where online = 'maybe'",
+			want: meta.ErrInvalidBoolean,
+		},
+		{
+			name: "datetime-range",
+			sql:  "select * from test where created >= '2021-01-01' and created < '2021-02-01 12:00:00'",
+		},
+		{
+			name: "datetime-invalid",
+			sql:  "select * from test where created > 'yesterday'",
+			want: meta.ErrInvalidDatetime,
+		},
+		{
+			name: "geo-distance",
+			sql:  "select * from test where geo_distance(location, 116.4, 39.9) < '5km'",
+		},
+		{
+			name: "geo-distance-meters",
+			sql:  "select * from test where geo_distance(location, 116.4, 39.9) <= 500",
+		},
+		{
+			name: "geo-distance-gt",
+			sql:  "select * from test where geo_distance(location, 116.4, 39.9) > 500",
+			want: ErrSyntaxNotSupported,
+		},
+		{
+			name: "vector-eq",
+			sql:  "select * from test where embed = '[1, 2]'",
+			want: ErrSyntaxNotSupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := Parse(tt.sql)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			_, err = rs.BuildRequest(mapping)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("BuildRequest() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnDef(t *testing.T) {
+	rs, err := Parse("create table test (id int(10) not null, price decimal(10,2) default -1.5, name varchar(255) null default 'none' comment 'analyzer=en') comment='store=false'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.TableComment != "store=false" {
+		t.Errorf("TableComment = %s, want store=false", rs.TableComment)
+	}
+	want := []Col{
+		{Name: "id", NotNull: true},
+		{Name: "price", Default: -1.5},
+		{Name: "name", Default: "none", Comment: "analyzer=en"},
+	}
+	for i, col := range rs.ColNames {
+		if col.Name != want[i].Name || col.NotNull != want[i].NotNull || col.Default != want[i].Default || col.Comment != want[i].Comment {
+			t.Errorf("ColNames[%d] = %+v, want %+v", i, col, want[i])
+		}
+	}
+}
+
+func TestAlterSpecs(t *testing.T) {
+	rs, err := Parse("alter table test add column age int, drop column if exists sex, modify column Name text comment 'analyzer=en', rename to test2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.ActionType != StmtTypeAlter || rs.TableName != "test" {
+		t.Fatalf("Parse() = %s %s, want alter test", rs.ActionType, rs.TableName)
+	}
+	want := []AlterSpec{
+		{Type: AlterTypeAddColumn, Col: Col{Name: "age"}},
+		{Type: AlterTypeDropColumn, Col: Col{Name: "sex"}, IfExists: true},
+		{Type: AlterTypeModifyColumn, Col: Col{Name: "Name", Comment: "analyzer=en"}},
+		{},
+	}
+	if len(rs.AlterSpecs) != len(want) {
+		t.Fatalf("AlterSpecs = %+v, want %+v", rs.AlterSpecs, want)
+	}
+	for i, spec := range rs.AlterSpecs {
+		if spec.Type != want[i].Type || spec.Col.Name != want[i].Col.Name || spec.Col.Comment != want[i].Col.Comment || spec.IfExists != want[i].IfExists {
+			t.Errorf("AlterSpecs[%d] = %+v, want %+v", i, spec, want[i])
+		}
+	}
+	if err := rs.AlterSpecs[3].Validate(); err == nil {
+		t.Error("Validate() of rename should fail")
+	}
+}
+
+func TestAggregations(t *testing.T) {
+	rs, err := Parse("select category, count(*), avg(price) as avg_price, count(distinct brand) from test where price > 1 group by category, ranges(price, 10, 100)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAggs := []Aggregation{
+		{Name: "count(*)", Func: AggFuncCount},
+		{Name: "avg_price", Func: AggFuncAvg, Column: "price"},
+		{Name: "count(distinct brand)", Func: AggFuncCountDistinct, Column: "brand"},
+	}
+	if len(rs.Aggregations) != len(wantAggs) {
+		t.Fatalf("Aggregations = %+v, want %+v", rs.Aggregations, wantAggs)
+	}
+	for i, agg := range rs.Aggregations {
+		if agg != wantAggs[i] {
+			t.Errorf("Aggregations[%d] = %+v, want %+v", i, agg, wantAggs[i])
+		}
+	}
+	if len(rs.GroupBy) != 2 || rs.GroupBy[0].Column != "category" || rs.GroupBy[1].Column != "price" || len(rs.GroupBy[1].Ranges) != 2 {
+		t.Errorf("GroupBy = %+v", rs.GroupBy)
+	}
+	if len(rs.ColNames) != 1 || rs.ColNames[0].Name != "category" {
+		t.Errorf("ColNames = %+v", rs.ColNames)
+	}
+
+	mapping := meta.Mapping{Properties: map[string]meta.FiledOptions{
+		"category": {Type: meta.FieldTypeKeyword},
+		"brand":    {Type: meta.FieldTypeText},
+		"price":    {Type: meta.FieldTypeNumeric},
+		"location": {Type: meta.FieldTypeGeoPoint},
+	}}
+	tests := []struct {
+		name string
+		want error
+		sql  string
+	}{
+		{
+			name: "group-by",
+			sql:  "select category, count(*), avg(price) as avg_price from test group by category",
+		},
+		{
+			name: "metrics",
+			sql:  "select min(price), max(price), sum(price) from test where category = 'a'",
+		},
+		{
+			name: "ranges",
+			sql:  "select count(*) from test group by ranges(price, 10, 100), category",
+		},
+		{
+			name: "ranges-unordered",
+			sql:  "select count(*) from test group by ranges(price, 100, 10)",
+			want: ErrSyntaxNotSupported,
+		},
+		{
+			name: "sum-text",
+			sql:  "select sum(brand) from test",
+			want: ErrAggregation,
+		},
+		{
+			name: "group-by-text",
+			sql:  "select count(*) from test group by brand",
+			want: ErrAggregation,
+		},
+		{
+			name: "group-by-geopoint",
+			sql:  "select count(*) from test group by location",
+			want: ErrAggregation,
+		},
+		{
+			name: "group-by-unmapped",
+			sql:  "select count(*), sum(weight) from test group by color",
+		},
+		{
+			name: "duplicate-name",
+			sql:  "select min(price) as p, max(price) as p from test",
+			want: ErrAggregation,
+		},
+		{
+			name: "not-supported",
+			sql:  "select group_concat(category) from test",
+			want: ErrSyntaxNotSupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := Parse(tt.sql)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			_, err = rs.BuildRequest(mapping)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("BuildRequest() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlights(t *testing.T) {
+	text, _ := meta.NewFiledOptions(meta.FieldTypeText, "", "")
+	noHighlight, _ := meta.NewFiledOptions(meta.FieldTypeText, "", "highlight=false")
+	mapping := meta.Mapping{Properties: map[string]meta.FiledOptions{
+		"body":  text,
+		"title": noHighlight,
+		"price": {Type: meta.FieldTypeNumeric},
+		// created before field options were kept in the mapping
+		"legacy": {Type: meta.FieldTypeText},
+	}}
+	tests := []struct {
+		name string
+		want error
+		sql  string
+	}{
+		{
+			name: "default",
+			sql:  "select *, highlight(body) from test where body = 'fox'",
+		},
+		{
+			name: "options",
+			sql:  "select highlight(body, 'fragment_size=50, fragments=3, pre_tag=<b>, post_tag=</b>') from test",
+		},
+		{
+			name: "ansi",
+			sql:  "select highlight(body, 'format=ansi') from test",
+		},
+		{
+			name: "ansi-tags",
+			sql:  "select highlight(body, 'format=ansi, pre_tag=<b>, post_tag=</b>') from test",
+			want: ErrHighlight,
+		},
+		{
+			name: "missing-post-tag",
+			sql:  "select highlight(body, 'pre_tag=<b>') from test",
+			want: ErrHighlight,
+		},
+		{
+			name: "bad-fragment-size",
+			sql:  "select highlight(body, 'fragment_size=0') from test",
+			want: ErrHighlight,
+		},
+		{
+			name: "unknown-option",
+			sql:  "select highlight(body, 'color=red') from test",
+			want: ErrHighlight,
+		},
+		{
+			name: "without-option",
+			sql:  "select highlight(legacy) from test where legacy = 'fox'",
+		},
+		{
+			name: "highlight-disabled",
+			sql:  "select highlight(title) from test",
+			want: ErrHighlight,
+		},
+		{
+			name: "numeric",
+			sql:  "select highlight(price) from test",
+			want: ErrHighlight,
+		},
+		{
+			name: "unknown-field",
+			sql:  "select highlight(summary) from test",
+			want: meta.ErrFieldNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := Parse(tt.sql)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			_, err = rs.BuildRequest(mapping)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("BuildRequest() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		explain bool
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "score-asc",
+			sql:  "select * from test order by _score, id desc",
+			want: []string{"_score", "-_id"},
+		},
+		{
+			name:    "explain-score-desc",
+			sql:     "explain select * from test where title = 'hello' order by _score desc",
+			explain: true,
+			want:    []string{"-_score"},
+		},
+		{
+			name:    "expression",
+			sql:     "select * from test order by price * 2",
+			want:    []string{""},
+			wantErr: ErrSyntaxNotSupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := Parse(tt.sql)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if rs.Explain != tt.explain || rs.ActionType != StmtTypeSelect {
+				t.Errorf("Explain = %v, ActionType = %s", rs.Explain, rs.ActionType)
+			}
+			if fmt.Sprint(rs.orderBy) != fmt.Sprint(tt.want) {
+				t.Errorf("orderBy = %v, want %v", rs.orderBy, tt.want)
+			}
+			order, err := sortOrder(rs.orderBy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("sortOrder() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && len(order) != len(tt.want) {
+				t.Errorf("sortOrder() = %v, want %d sorts", order, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestShardCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		want    int
+		wantErr error
+	}{
+		{name: "none", sql: "create table test (id int, name text)", want: 1},
+		{name: "hash", sql: "create table test (id int, name text) partition by hash(id) partitions 4", want: 4},
+		{name: "key", sql: "create table test (id int, name text) partition by key(id) partitions 3", want: 3},
+		{name: "column", sql: "create table test (id int, name text) partition by hash(name) partitions 4", wantErr: ErrPartition},
+		{name: "range", sql: "create table test (id int) partition by range(id) (partition p0 values less than (10))", wantErr: ErrPartition},
+		{name: "too-many", sql: "create table test (id int) partition by hash(id) partitions 2048", wantErr: meta.ErrInvalidShards},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := Parse(tt.sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rs.ColNames) > 2 {
+				t.Errorf("ColNames = %+v, the partition column must not be added", rs.ColNames)
+			}
+			got, err := rs.ShardCount()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ShardCount() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ShardCount() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildBatches(t *testing.T) {
+	mapping := meta.Mapping{Shards: 4, Properties: map[string]meta.FiledOptions{
+		"name": {Type: meta.FieldTypeText},
+	}}
+	rs, err := Parse("insert into test (id,name) values (1,'a'),(2,'b'),(3,'c'),(4,'d'),(5,'e'),(6,'f'),(7,'g'),(8,'h')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches, err := rs.BuildBatches(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) < 2 {
+		t.Errorf("BuildBatches() = %d batches, want the documents spread over the shards", len(batches))
+	}
+	for shard := range batches {
+		if shard < 0 || shard >= mapping.Shards {
+			t.Errorf("BuildBatches() shard %d out of range", shard)
+		}
+	}
+
+	rs, err = Parse("delete from test where id=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches, err = rs.BuildBatches(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := batches[mapping.Shard("3")]; !ok || len(batches) != 1 {
+		t.Errorf("BuildBatches() of delete = %v, want shard %d", batches, mapping.Shard("3"))
+	}
+
+	// the last write of a document in a transaction wins
+	rs, err = Parse("begin; insert into test set id=3,name='a'; update test set name='b' where id=3; commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches, err = rs.BuildBatches(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 {
+		t.Errorf("BuildBatches() of transaction = %d batches, want 1", len(batches))
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		want    []stmtType
+		wantErr error
+	}{
+		{
+			name: "statements",
+			sql:  "insert into test set id=1; refresh table test; select * from test",
+			want: []stmtType{StmtTypeInsert, StmtTypeRefresh, StmtTypeSelect},
+		},
+		{
+			name: "transaction",
+			sql:  "begin; insert into test set id=1; delete from test where id=2; commit; select * from test",
+			want: []stmtType{StmtTypeTransaction, StmtTypeSelect},
+		},
+		{
+			name: "rollback",
+			sql:  "begin; insert into test set id=1; rollback",
+			want: nil,
+		},
+		{
+			name:    "select in transaction",
+			sql:     "begin; select * from test; commit",
+			wantErr: ErrTransaction,
+		},
+		{
+			name:    "by query in transaction",
+			sql:     "begin; delete from test where status='x'; commit",
+			wantErr: ErrTransaction,
+		},
+		{
+			name:    "without commit",
+			sql:     "begin; insert into test set id=1",
+			wantErr: ErrTransaction,
+		},
+		{
+			name:    "commit without begin",
+			sql:     "insert into test set id=1; commit",
+			wantErr: ErrTransaction,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ParseAll(tt.sql)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseAll() error = %v, want %v", err, tt.wantErr)
+			}
+			var got []stmtType
+			for _, stmt := range rs {
+				got = append(got, stmt.ActionType)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAll() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	rs, err := ParseAll("begin; insert into a set id=1; insert into b set id=1; update a set name='x' where id=1; commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := rs[0].Split()
+	if len(parts) != 2 || parts[0].TableName != "a" || len(parts[0].Stmts) != 2 || parts[1].TableName != "b" {
+		t.Fatalf("Split() = %v, want a transaction per table", parts)
+	}
+	again, err := Parse(parts[0].Sql)
+	if err != nil || again.ActionType != StmtTypeTransaction || len(again.Stmts) != 2 {
+		t.Errorf("Parse(%q) = %v, %v, want the transaction back", parts[0].Sql, again, err)
+	}
+
+	// only a statement starting with REFRESH is one, the literals are kept
+	mapping := meta.Mapping{Properties: map[string]meta.FiledOptions{
+		"name": {Type: meta.FieldTypeText},
+	}}
+	for _, tt := range []struct {
+		sql  string
+		want []stmtType
+	}{
+		{sql: "insert into test (id,name) values (1,'a; refresh me')", want: []stmtType{StmtTypeInsert}},
+		{sql: "insert into test (id,name) values (1,'a; refresh me'); refresh table test", want: []stmtType{StmtTypeInsert, StmtTypeRefresh}},
+		{sql: "/* ; refresh */ insert into test (id,name) values (1,'a; refresh me'); -- ; refresh x\n refresh table test", want: []stmtType{StmtTypeInsert, StmtTypeRefresh}},
+	} {
+		rs, err := ParseAll(tt.sql)
+		if err != nil {
+			t.Fatalf("ParseAll(%s) err = %v", tt.sql, err)
+		}
+		var got []stmtType
+		for _, stmt := range rs {
+			got = append(got, stmt.ActionType)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("ParseAll(%s) = %v, want %v", tt.sql, got, tt.want)
+		}
+		writes, err := rs[0].Writes(mapping, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(writes) != 1 || writes[0].Values["name"] != "a; refresh me" {
+			t.Errorf("ParseAll(%s) writes %+v, want name 'a; refresh me'", tt.sql, writes)
+		}
+	}
+}
+
+func TestWithIds(t *testing.T) {
+	rs, err := Parse("update test set name='a', price=1.5 where status='x' and _version=3 limit 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rs.ByQuery() || rs.Limit() != 5 {
+		t.Fatalf("ByQuery() = %v, Limit() = %d, want an update by query limited to 5", rs.ByQuery(), rs.Limit())
+	}
+	batch, err := rs.WithIds([]string{"1", "a'b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := batch.ids()
+	if err != nil || batch.ByQuery() || !reflect.DeepEqual(ids, []string{"1", "a'b"}) {
+		t.Errorf("WithIds() = %s, ids %v, %v", batch.Sql, ids, err)
+	}
+	if version, ok := batch.Version(); !ok || version != 3 || len(batch.Assignments) != len(rs.Assignments) {
+		t.Errorf("WithIds() = %s, want the assignments and version of %s", batch.Sql, rs.Sql)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	stored := map[string]interface{}{"name": "a", "views": float64(1), meta.VersionField: float64(7)}
+	tests := []struct {
+		name    string
+		sql     string
+		want    map[string]interface{}
+		wantErr error
+	}{
+		{
+			name: "expression",
+			sql:  "update test set views = views + 1, name = 'b' where id=1",
+			want: map[string]interface{}{"name": "b", "views": float64(2), meta.VersionField: int64(8)},
+		},
+		{
+			name: "left to right",
+			sql:  "update test set views = views * 10, score = (views + 5) / 5 where id=1 and _version=7",
+			want: map[string]interface{}{"name": "a", "views": float64(10), "score": float64(3), meta.VersionField: int64(8)},
+		},
+		{
+			name: "null",
+			sql:  "update test set views = missing - 1 where id=1",
+			want: map[string]interface{}{"name": "a", "views": nil, meta.VersionField: int64(8)},
+		},
+		{
+			name:    "version conflict",
+			sql:     "update test set views = 0 where id=1 and _version=6",
+			wantErr: ErrVersionConflict,
+		},
+		{
+			name:    "id",
+			sql:     "update test set id = 2 where id=1",
+			wantErr: ErrUpdateColumn,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := Parse(tt.sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := rs.merge("1", stored)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("merge() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnDuplicate(t *testing.T) {
+	rs, err := Parse("insert ignore into test (id,views) values (1,1),(2,3) on duplicate key update views = views + values(views), name='x'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rs.Ignore || rs.Replace || len(rs.OnDuplicate) != 2 || rs.OnDuplicate[0].Column != "views" {
+		t.Fatalf("Parse() = %+v, want insert ignore with 2 assignments on duplicate", rs)
+	}
+	if len(rs.ColNames) != 2 || len(rs.rows) != 4 {
+		t.Errorf("Parse() columns %v rows %v, want the inserted ones only", rs.ColNames, rs.rows)
+	}
+}
+
+func TestGenerateIds(t *testing.T) {
+	mapping := meta.Mapping{Shards: 1, Properties: map[string]meta.FiledOptions{
+		"name": {Type: meta.FieldTypeText},
+	}}
+	tests := []struct {
+		sql string
+		// ids are the ids of the written rows, "" where one is generated
+		ids []string
+	}{
+		{sql: "insert into test (name) values ('a'),('b')", ids: []string{"", ""}},
+		{sql: "insert into test set name='a'", ids: []string{""}},
+		{sql: "insert into test (id,name) values (1,'a'),(null,'b')", ids: []string{"1", ""}},
+		{sql: "insert into test set id=null, name='a'", ids: []string{""}},
+		{sql: "insert into test (name) values ('a') on duplicate key update name='b'", ids: []string{""}},
+		{sql: "insert into test (id,name) values (1,'a')", ids: []string{"1"}},
+		{sql: "begin; insert into test (name) values ('a'); insert into test set id=2, name='b'; commit", ids: []string{"", "2"}},
+	}
+	for _, tt := range tests {
+		stmts, err := ParseAll(tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs, generated, err := stmts[0].GenerateIds()
+		if err != nil {
+			t.Fatalf("GenerateIds(%s) err = %v", tt.sql, err)
+		}
+		writes, err := rs.Writes(mapping, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, w := range writes {
+			ids = append(ids, w.ID)
+		}
+		want := make([]string, 0, len(tt.ids))
+		next := 0
+		for _, id := range tt.ids {
+			if id == "" && next < len(generated) {
+				id, next = generated[next], next+1
+			}
+			want = append(want, id)
+		}
+		if next != len(generated) || !reflect.DeepEqual(ids, want) {
+			t.Errorf("GenerateIds(%s) = %s, ids %v, want %v", tt.sql, rs.Sql, ids, want)
+		}
+		if rs.ActionType != stmts[0].ActionType || rs.OnDuplicate == nil != (stmts[0].OnDuplicate == nil) {
+			t.Errorf("GenerateIds(%s) = %s, want the statement kept", tt.sql, rs.Sql)
+		}
+	}
+
+	// the values of an insert without a column list can not be matched to
+	// the columns
+	noColumns, _ := Parse("insert into test values ('a'),('b')")
+	if _, _, err := noColumns.GenerateIds(); !errors.Is(err, ErrInsertColumns) {
+		t.Errorf("GenerateIds() without a column list err = %v, want %v", err, ErrInsertColumns)
+	}
+	if _, err := noColumns.Writes(mapping, nil); !errors.Is(err, ErrInsertColumns) {
+		t.Errorf("Writes() without a column list err = %v, want %v", err, ErrInsertColumns)
+	}
+
+	first, _ := Parse("insert into test (name) values ('a'),('b')")
+	_, a, _ := first.GenerateIds()
+	_, b, _ := first.GenerateIds()
+	if a[0] == a[1] || a[1] >= b[0] {
+		t.Errorf("GenerateIds() = %v then %v, want unique ids in creation order", a, b)
+	}
+}
@@ -0,0 +1,490 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"pole/internal/poled/meta"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/index"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+)
+
+var (
+	ErrTransaction = errors.New("invalid transaction")
+	ErrNoStatement = errors.New("no statement")
+)
+
+const refreshKeyword = "refresh"
+
+// ParseAll parses every statement of the sql, the INSERT, UPDATE and DELETE
+// between BEGIN and COMMIT are returned as one transaction and the ones
+// between BEGIN and ROLLBACK are dropped.
+func ParseAll(sql string) ([]*SqlVistor, error) {
+	p := getParser()
+	defer putParser(p)
+	nodes, err := parseNodes(p, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []*SqlVistor
+	var tx *SqlVistor
+	for i := range nodes {
+		switch nodes[i].(type) {
+		case *ast.BeginStmt:
+			if tx != nil {
+				return nil, fmt.Errorf("%w: BEGIN in a transaction", ErrTransaction)
+			}
+			tx = &SqlVistor{ActionType: StmtTypeTransaction}
+			continue
+		case *ast.CommitStmt:
+			if tx == nil {
+				return nil, fmt.Errorf("%w: COMMIT without BEGIN", ErrTransaction)
+			}
+			if len(tx.Stmts) > 0 {
+				rs = append(rs, newTransaction(tx.Stmts))
+			}
+			tx = nil
+			continue
+		case *ast.RollbackStmt:
+			if tx == nil {
+				return nil, fmt.Errorf("%w: ROLLBACK without BEGIN", ErrTransaction)
+			}
+			tx = nil
+			continue
+		}
+
+		stmt := extract(&nodes[i])
+		if tx == nil {
+			rs = append(rs, stmt)
+			continue
+		}
+		switch {
+		case stmt.ByQuery():
+			return nil, fmt.Errorf("%w: %s by query in a transaction", ErrTransaction, stmt.ActionType)
+		case stmt.ActionType == StmtTypeInsert, stmt.ActionType == StmtTypeUpdate, stmt.ActionType == StmtTypeDelete:
+			tx.Stmts = append(tx.Stmts, stmt)
+		default:
+			return nil, fmt.Errorf("%w: %s in a transaction", ErrTransaction, stmt.ActionType)
+		}
+	}
+	if tx != nil {
+		return nil, fmt.Errorf("%w: BEGIN without COMMIT", ErrTransaction)
+	}
+	return rs, nil
+}
+
+// parseNodes parses the statements of the sql. REFRESH is not mysql, the
+// statements starting with it are parsed one by one and REFRESH TABLE is read
+// as FLUSH TABLE. p reuses the slice it returns, it is held until the nodes
+// are read.
+func parseNodes(p *parser.Parser, sql string) ([]ast.StmtNode, error) {
+	stmts := splitStatements(sql)
+	refresh := false
+	for _, stmt := range stmts {
+		refresh = refresh || isRefresh(stmt)
+	}
+	if !refresh {
+		nodes, _, err := p.Parse(sql, "", "")
+		return nodes, err
+	}
+
+	var rs []ast.StmtNode
+	for _, stmt := range stmts {
+		if isRefresh(stmt) {
+			stmt = strings.TrimSpace(stmt)
+			stmt = "flush" + stmt[len(refreshKeyword):]
+		}
+		nodes, _, err := p.Parse(stmt, "", "")
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, nodes...)
+	}
+	return rs, nil
+}
+
+// isRefresh reports whether the statement starts with the REFRESH keyword.
+func isRefresh(stmt string) bool {
+	fields := strings.Fields(stmt)
+	return len(fields) > 1 && strings.EqualFold(fields[0], refreshKeyword)
+}
+
+// splitStatements splits the sql at the semicolons outside of quotes and
+// comments, the comments are dropped.
+func splitStatements(sql string) []string {
+	var rs []string
+	var stmt strings.Builder
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for ; end < len(sql); end++ {
+				if sql[end] == '\\' && c != '`' {
+					end++
+					continue
+				}
+				if sql[end] == c {
+					// a doubled quote is a quote of the literal
+					if end+1 < len(sql) && sql[end+1] == c {
+						end++
+						continue
+					}
+					break
+				}
+			}
+			if end >= len(sql) {
+				end = len(sql) - 1
+			}
+			stmt.WriteString(sql[i : end+1])
+			i = end
+		case c == '#' || c == '-' && strings.HasPrefix(sql[i:], "-- "):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				i = len(sql)
+				continue
+			}
+			stmt.WriteByte(' ')
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+				continue
+			}
+			stmt.WriteByte(' ')
+			i += end + 3
+		case c == ';':
+			if text := strings.TrimSpace(stmt.String()); text != "" {
+				rs = append(rs, text)
+			}
+			stmt.Reset()
+		default:
+			stmt.WriteByte(c)
+		}
+	}
+	if text := strings.TrimSpace(stmt.String()); text != "" {
+		rs = append(rs, text)
+	}
+	return rs
+}
+
+// newTransaction groups the writes, its Sql parses back to the same
+// transaction so it is routed, logged and replayed like a statement.
+func newTransaction(stmts []*SqlVistor) *SqlVistor {
+	texts := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		texts = append(texts, stmt.Sql)
+	}
+	rs := &SqlVistor{
+		Sql:        "BEGIN; " + strings.Join(texts, "; ") + "; COMMIT",
+		ActionType: StmtTypeTransaction,
+		Stmts:      stmts,
+	}
+	if tables := rs.Tables(); len(tables) == 1 {
+		rs.TableName = tables[0]
+	}
+	return rs
+}
+
+// Tables lists the tables written by a transaction in their first write
+// order.
+func (s *SqlVistor) Tables() []string {
+	var rs []string
+	seen := make(map[string]bool)
+	for _, stmt := range s.Stmts {
+		if !seen[stmt.TableName] {
+			seen[stmt.TableName] = true
+			rs = append(rs, stmt.TableName)
+		}
+	}
+	return rs
+}
+
+// Split splits a transaction into a transaction per table, the writes of a
+// table are applied in one batch per shard.
+func (s *SqlVistor) Split() []*SqlVistor {
+	tables := s.Tables()
+	if len(tables) <= 1 {
+		return []*SqlVistor{s}
+	}
+	rs := make([]*SqlVistor, 0, len(tables))
+	for _, table := range tables {
+		var stmts []*SqlVistor
+		for _, stmt := range s.Stmts {
+			if stmt.TableName == table {
+				stmts = append(stmts, stmt)
+			}
+		}
+		rs = append(rs, newTransaction(stmts))
+	}
+	return rs
+}
+
+// Write puts a document or, without one, deletes the document of the id.
+// Values are the columns of the document put.
+type Write struct {
+	ID     string
+	Values map[string]interface{}
+	Doc    *bluge.Document
+	// Row is the row of an insert counted from 1, Err is why it was not
+	// written and Affected the rows it counts for as in MySQL, 2 when it
+	// replaced or updated a duplicate.
+	Row      int
+	Err      error
+	Affected int
+}
+
+// writeState holds the documents written so far by a statement, the writes
+// of a transaction see the ones before them.
+type writeState struct {
+	meta meta.Mapping
+	load Loader
+	// docs are nil once deleted
+	docs map[string]map[string]interface{}
+	// transaction fails as a whole on a duplicate row
+	transaction bool
+}
+
+// stored returns the document of the id, nil when there is none or no
+// loader to read it.
+func (w *writeState) stored(id string) (map[string]interface{}, bool, error) {
+	if values, ok := w.docs[id]; ok {
+		return values, true, nil
+	}
+	if w.load == nil {
+		return nil, false, nil
+	}
+	values, err := w.load(id)
+	return values, true, err
+}
+
+func (s *SqlVistor) writes(state *writeState) ([]Write, error) {
+	var rs []Write
+	switch s.ActionType {
+	case StmtTypeInsert:
+		inserts, err := s.inserts(state.meta)
+		if err != nil {
+			return nil, err
+		}
+		for _, insert := range inserts {
+			insert, err := s.resolveInsert(state, insert)
+			if err != nil {
+				return nil, err
+			}
+			if insert.Err == nil && insert.Affected == 0 {
+				continue
+			}
+			if insert.Err == nil {
+				state.docs[insert.ID] = insert.Values
+			}
+			rs = append(rs, insert)
+		}
+	case StmtTypeUpdate:
+		ids, err := s.ids()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			stored, loaded, err := state.stored(id)
+			if err != nil {
+				return nil, err
+			}
+			if !loaded {
+				rs = append(rs, s.assignmentsOnly(state.meta, id))
+				continue
+			}
+			// updating a missing document changes nothing, as in MySQL
+			if stored == nil {
+				continue
+			}
+			values, err := s.merge(id, stored)
+			if err != nil {
+				return nil, err
+			}
+			doc, err := state.meta.MakeDocument(id, values)
+			if err != nil {
+				return nil, err
+			}
+			state.docs[id] = values
+			rs = append(rs, Write{ID: id, Values: values, Doc: doc})
+		}
+	case StmtTypeDelete:
+		ids, err := s.ids()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if _, ok := s.Version(); ok {
+				stored, loaded, err := state.stored(id)
+				if err != nil {
+					return nil, err
+				}
+				if loaded && stored == nil {
+					continue
+				}
+				if loaded {
+					if err := s.checkVersion(id, stored); err != nil {
+						return nil, err
+					}
+				}
+			}
+			state.docs[id] = nil
+			rs = append(rs, Write{ID: id})
+		}
+	case StmtTypeTransaction:
+		state.transaction = true
+		for _, stmt := range s.Stmts {
+			writes, err := stmt.writes(state)
+			if err != nil {
+				return nil, err
+			}
+			rs = append(rs, writes...)
+		}
+	default:
+		return nil, fmt.Errorf("not write operation: %s", s.ActionType)
+	}
+	return rs, nil
+}
+
+// resolveInsert decides what becomes of a row of an insert whose id is
+// already stored: REPLACE puts the row at the next version, ON DUPLICATE KEY
+// UPDATE merges into the stored document, IGNORE skips it and a plain INSERT
+// fails the row.
+func (s *SqlVistor) resolveInsert(state *writeState, insert Write) (Write, error) {
+	stored, _, err := state.stored(insert.ID)
+	if err != nil || stored == nil {
+		return insert, err
+	}
+	switch {
+	case s.Replace:
+		version := storedVersion(stored) + 1
+		doc, err := s.rowDocument(state.meta, insert.Row, version)
+		if err != nil {
+			return insert, err
+		}
+		insert.Values[meta.VersionField] = version
+		insert.Doc, insert.Affected = doc, 2
+	case len(s.OnDuplicate) > 0:
+		values, err := mergeAssignments(s.OnDuplicate, stored, insert.Values)
+		if err != nil {
+			return insert, err
+		}
+		doc, err := state.meta.MakeDocument(insert.ID, values)
+		if err != nil {
+			return insert, err
+		}
+		insert.Values, insert.Doc, insert.Affected = values, doc, 2
+	case s.Ignore:
+		insert.Affected = 0
+	default:
+		err := fmt.Errorf("%w:%s", ErrDuplicateKey, insert.ID)
+		if state.transaction {
+			return insert, err
+		}
+		insert.Doc, insert.Affected, insert.Err = nil, 0, err
+	}
+	return insert, nil
+}
+
+// assignmentsOnly builds the document of an update without its stored
+// document, it holds the SET columns only as updates did before they were
+// merged. It is used to check and route the update and to replay it from
+// an old translog.
+func (s *SqlVistor) assignmentsOnly(metas meta.Mapping, id string) Write {
+	values := make(map[string]interface{}, len(s.Assignments))
+	doc := bluge.NewDocument(id)
+	for _, assignment := range s.Assignments {
+		value, err := eval(assignment.Expr, values, nil)
+		if err != nil || value == nil {
+			continue
+		}
+		field, err := metas.MakeField(assignment.Column, value)
+		if field == nil || err != nil {
+			continue
+		}
+		values[assignment.Column] = value
+		doc.AddField(field)
+	}
+	return Write{ID: id, Values: values, Doc: doc}
+}
+
+// Writes returns the writes of an insert, update, delete or transaction,
+// the last one of each document only as bluge would keep every version of
+// a document put twice in a batch, and the failed rows of an insert. Updates
+// merge into the documents read by load, without it they hold their SET
+// columns only and inserts are taken as new. A write merging into the
+// stored documents fails when the mapping has columns that are not stored.
+func (s *SqlVistor) Writes(meta meta.Mapping, load Loader) ([]Write, error) {
+	if err := s.checkStored(meta); err != nil {
+		return nil, err
+	}
+	return s.lastWrites(meta, load)
+}
+
+// lastWrites returns the writes keeping the last one of each document.
+func (s *SqlVistor) lastWrites(meta meta.Mapping, load Loader) ([]Write, error) {
+	writes, err := s.writes(&writeState{meta: meta, load: load, docs: make(map[string]map[string]interface{})})
+	if err != nil {
+		return nil, err
+	}
+	last := make(map[string]int, len(writes))
+	for i, w := range writes {
+		if w.Err == nil {
+			last[w.ID] = i
+		}
+	}
+	rs := writes[:0]
+	for i, w := range writes {
+		if w.Err != nil || last[w.ID] == i {
+			rs = append(rs, w)
+		}
+	}
+	return rs, nil
+}
+
+// ShardWrites splits the writes by the shard of their documents.
+func ShardWrites(meta meta.Mapping, writes []Write) map[int][]Write {
+	rs := make(map[int][]Write)
+	for _, w := range writes {
+		shard := meta.Shard(w.ID)
+		rs[shard] = append(rs[shard], w)
+	}
+	return rs
+}
+
+func NewBatch(writes []Write) *index.Batch {
+	rs := index.NewBatch()
+	for _, w := range writes {
+		if w.Err != nil {
+			continue
+		}
+		if w.Doc == nil {
+			rs.Delete(bluge.Identifier(w.ID))
+		} else {
+			rs.Update(w.Doc.ID(), w.Doc)
+		}
+	}
+	return rs
+}
+
+// BuildBatches builds the batches of an insert, update, delete or
+// transaction split by the shard of the documents, updates hold their SET
+// columns only. It replays the statements of old translogs, which updates
+// did not merge.
+func (s *SqlVistor) BuildBatches(meta meta.Mapping) (map[int]*index.Batch, error) {
+	writes, err := s.lastWrites(meta, nil)
+	if err != nil {
+		return nil, err
+	}
+	rs := make(map[int]*index.Batch)
+	for shard, writes := range ShardWrites(meta, writes) {
+		rs[shard] = NewBatch(writes)
+	}
+	return rs, nil
+}
@@ -0,0 +1,258 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"pole/internal/poled/meta"
+
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/opcode"
+)
+
+var (
+	ErrVersionConflict = errors.New("version conflict")
+	ErrUpdateColumn    = errors.New("column can not be updated")
+	ErrDuplicateKey    = errors.New("duplicate entry")
+	ErrUnstoredColumn  = errors.New("column is not stored")
+)
+
+// Assignment is a column = expression of UPDATE ... SET.
+type Assignment struct {
+	Column string
+	Expr   ast.ExprNode
+}
+
+// Loader reads the stored values of a document, nil when there is none.
+type Loader func(id string) (map[string]interface{}, error)
+
+// Version returns the version a write is conditioned on by
+// WHERE ... AND _version = n.
+func (s *SqlVistor) Version() (int64, bool) {
+	_, version, ok := splitVersion(s.where)
+	return version, ok
+}
+
+// condition returns the WHERE clause without its _version condition.
+func (s *SqlVistor) condition() ast.Node {
+	rs, _, _ := splitVersion(s.where)
+	return rs
+}
+
+func splitVersion(where ast.Node) (ast.Node, int64, bool) {
+	if version, ok := versionCondition(where); ok {
+		return nil, version, true
+	}
+	and, ok := where.(*ast.BinaryOperationExpr)
+	if !ok || and.Op != opcode.LogicAnd {
+		return where, 0, false
+	}
+	if version, ok := versionCondition(and.R); ok {
+		return and.L, version, true
+	}
+	if version, ok := versionCondition(and.L); ok {
+		return and.R, version, true
+	}
+	return where, 0, false
+}
+
+func versionCondition(node ast.Node) (int64, bool) {
+	expr, ok := node.(*ast.BinaryOperationExpr)
+	if !ok || expr.Op != opcode.EQ {
+		return 0, false
+	}
+	column, ok := expr.L.(*ast.ColumnNameExpr)
+	if !ok || column.Name.Name.O != meta.VersionField {
+		return 0, false
+	}
+	value, ok := literalValue(expr.R)
+	if !ok {
+		return 0, false
+	}
+	version, err := meta.ParseNumeric(value)
+	if err != nil {
+		return 0, false
+	}
+	return int64(version), true
+}
+
+// ReadsDocuments reports whether the writes depend on the stored documents,
+// an insert checks for a duplicate, an update merges into them and a write
+// conditioned on a version checks it.
+func (s *SqlVistor) ReadsDocuments() bool {
+	switch s.ActionType {
+	case StmtTypeInsert, StmtTypeUpdate:
+		return true
+	case StmtTypeDelete:
+		_, ok := s.Version()
+		return ok
+	case StmtTypeTransaction:
+		for _, stmt := range s.Stmts {
+			if stmt.ReadsDocuments() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// merges reports whether the writes merge into the stored documents, an
+// update and an insert on duplicate key update do.
+func (s *SqlVistor) merges() bool {
+	switch s.ActionType {
+	case StmtTypeUpdate:
+		return true
+	case StmtTypeInsert:
+		return len(s.OnDuplicate) > 0
+	case StmtTypeTransaction:
+		for _, stmt := range s.Stmts {
+			if stmt.merges() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkStored fails a write merging into the stored documents of a mapping
+// with columns that are not stored, the merged documents would lose them.
+func (s *SqlVistor) checkStored(metas meta.Mapping) error {
+	if !s.merges() {
+		return nil
+	}
+	if unstored := metas.UnstoredFields(); len(unstored) > 0 {
+		return fmt.Errorf("%w:%s", ErrUnstoredColumn, strings.Join(unstored, ","))
+	}
+	return nil
+}
+
+// checkVersion fails unless the stored document has the version the write
+// is conditioned on.
+func (s *SqlVistor) checkVersion(id string, stored map[string]interface{}) error {
+	want, ok := s.Version()
+	if !ok {
+		return nil
+	}
+	if got := storedVersion(stored); got != want {
+		return fmt.Errorf("%w:%s is at version %d, not %d", ErrVersionConflict, id, got, want)
+	}
+	return nil
+}
+
+// storedVersion is the version of a document, the documents written before
+// versions were kept are at the first one.
+func storedVersion(stored map[string]interface{}) int64 {
+	version, err := meta.ParseNumeric(stored[meta.VersionField])
+	if err != nil || version < 1 {
+		return 1
+	}
+	return int64(version)
+}
+
+// merge applies the assignments to the stored values of a document and
+// moves it to the next version.
+func (s *SqlVistor) merge(id string, stored map[string]interface{}) (map[string]interface{}, error) {
+	if err := s.checkVersion(id, stored); err != nil {
+		return nil, err
+	}
+	return mergeAssignments(s.Assignments, stored, nil)
+}
+
+// mergeAssignments applies the assignments to the stored values, row holds
+// the values of the row an insert found a duplicate of. As in MySQL the
+// assignments are evaluated left to right, each one seeing the columns set
+// before it.
+func mergeAssignments(assignments []Assignment, stored, row map[string]interface{}) (map[string]interface{}, error) {
+	rs := make(map[string]interface{}, len(stored)+len(assignments))
+	for name, value := range stored {
+		rs[name] = value
+	}
+	for _, assignment := range assignments {
+		if assignment.Column == "id" || assignment.Column == meta.VersionField {
+			return nil, fmt.Errorf("%w:%s", ErrUpdateColumn, assignment.Column)
+		}
+		value, err := eval(assignment.Expr, rs, row)
+		if err != nil {
+			return nil, err
+		}
+		rs[assignment.Column] = value
+	}
+	rs[meta.VersionField] = storedVersion(stored) + 1
+	return rs, nil
+}
+
+// eval evaluates a SET expression over the values of a document, VALUES(col)
+// reads the row of an insert. NULL operands give NULL as in MySQL.
+func eval(expr ast.ExprNode, doc, row map[string]interface{}) (interface{}, error) {
+	if value, ok := literalValue(expr); ok {
+		return value, nil
+	}
+	switch node := expr.(type) {
+	case *ast.ColumnNameExpr:
+		return doc[node.Name.Name.O], nil
+	case *ast.ValuesExpr:
+		return row[node.Column.Name.Name.O], nil
+	case *ast.ParenthesesExpr:
+		return eval(node.Expr, doc, row)
+	case *ast.UnaryOperationExpr:
+		if node.Op != opcode.Minus {
+			break
+		}
+		value, err := evalNumeric(node.V, doc, row)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		return -*value, nil
+	case *ast.BinaryOperationExpr:
+		l, err := evalNumeric(node.L, doc, row)
+		if err != nil {
+			return nil, err
+		}
+		r, err := evalNumeric(node.R, doc, row)
+		if err != nil || l == nil || r == nil {
+			return nil, err
+		}
+		switch node.Op {
+		case opcode.Plus:
+			return *l + *r, nil
+		case opcode.Minus:
+			return *l - *r, nil
+		case opcode.Mul:
+			return *l * *r, nil
+		case opcode.Div:
+			if *r == 0 {
+				return nil, nil
+			}
+			return *l / *r, nil
+		case opcode.IntDiv:
+			if *r == 0 {
+				return nil, nil
+			}
+			return math.Trunc(*l / *r), nil
+		case opcode.Mod:
+			if *r == 0 {
+				return nil, nil
+			}
+			return math.Mod(*l, *r), nil
+		}
+	}
+	text, err := restore(expr)
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: %s in SET", ErrSyntaxNotSupported, text)
+}
+
+func evalNumeric(expr ast.ExprNode, doc, row map[string]interface{}) (*float64, error) {
+	value, err := eval(expr, doc, row)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	rs, err := meta.ParseNumeric(value)
+	if err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"time"
 
 	"pole/internal/poled/meta"
 
@@ -25,6 +26,8 @@ var (
 
 var wildCardReg = regexp.MustCompile(`%`)
 
+const geoDistanceFunc = "geo_distance"
+
 type WhereVisitor struct {
 	prefixQueryNodes *list.List
 }
@@ -39,6 +42,9 @@ func (s *WhereVisitor) Enter(in ast.Node) (ast.Node, bool) {
 	switch node := in.(type) {
 	case *ast.ParenthesesExpr, *ast.ColumnNameExpr:
 		break
+	case *ast.MatchAgainst, *ast.FuncCallExpr:
+		s.prefixQueryNodes.PushBack(node)
+		return in, true
 	default:
 		s.prefixQueryNodes.PushBack(node)
 	}
@@ -64,6 +70,12 @@ func (s *WhereVisitor) buildQuery(meta meta.Mapping) (bluge.Query, error) {
 			calList.Remove(node1)
 			calList.Remove(node2)
 			calList.PushBack(query)
+		case *ast.MatchAgainst:
+			query, err := buildMatchQuery(node, meta)
+			if err != nil {
+				return nil, err
+			}
+			calList.PushBack(query)
 		default:
 			calList.PushBack(node)
 		}
@@ -88,7 +100,11 @@ func (s *WhereVisitor) buildSingleQuery(node1, node2 interface{}, expr interface
 				return nil, ErrSyntaxNotSupported
 			}
 
-			queries = append(queries, makeEqQuery(column.Name, value.GetValue(), meta))
+			q, err := makeEqQuery(column.Name, value.GetValue(), meta)
+			if err != nil {
+				return nil, err
+			}
+			queries = append(queries, q)
 		}
 		if expr.Not {
 			query = bluge.NewBooleanQuery().AddMustNot(queries...)
@@ -117,17 +133,20 @@ func (s *WhereVisitor) buildSingleQuery(node1, node2 interface{}, expr interface
 			if !ok {
 				return nil, ErrEqRightMustBeValue
 			}
-			query = makeEqQuery(column, value.GetValue(), meta)
+			return makeEqQuery(column, value.GetValue(), meta)
 		case opcode.GE, opcode.GT, opcode.LE, opcode.LT:
-			column, ok := node1.(*ast.ColumnName)
-			if !ok {
-				return nil, ErrEqLeftMustBeColumn
-			}
 			value, ok := node2.(*test_driver.ValueExpr)
 			if !ok {
 				return nil, ErrEqRightMustBeValue
 			}
-			query = makeRangeQuery(column, value.GetValue(), meta, expr.Op)
+			if fn, ok := node1.(*ast.FuncCallExpr); ok && fn.FnName.L == geoDistanceFunc {
+				return makeGeoDistanceQuery(fn, value.GetValue(), meta, expr.Op)
+			}
+			column, ok := node1.(*ast.ColumnName)
+			if !ok {
+				return nil, ErrEqLeftMustBeColumn
+			}
+			return makeRangeQuery(column, value.GetValue(), meta, expr.Op)
 		case opcode.LogicAnd:
 			query1, ok := node1.(bluge.Query)
 			if !ok {
@@ -164,38 +183,125 @@ func columnName(column *ast.ColumnName) string {
 	return rs
 }
 
-func makeEqQuery(column *ast.ColumnName, value interface{}, m meta.Mapping) bluge.Query {
+func makeEqQuery(column *ast.ColumnName, value interface{}, m meta.Mapping) (bluge.Query, error) {
 	colName := columnName(column)
 	if colName == meta.IdentifierField {
-		return bluge.NewMatchQuery(fmt.Sprintf("%v", value)).SetField(colName)
+		return bluge.NewMatchQuery(fmt.Sprintf("%v", value)).SetField(colName), nil
 	}
 
 	filedOption := m.Properties[colName]
 	switch filedOption.Type {
 	case meta.FieldTypeNumeric:
 		v, _ := strconv.ParseFloat(fmt.Sprintf("%v", value), 64)
-		return bluge.NewNumericRangeInclusiveQuery(v, v, true, true).SetField(colName)
+		return bluge.NewNumericRangeInclusiveQuery(v, v, true, true).SetField(colName), nil
+	case meta.FieldTypeKeyword:
+		return bluge.NewTermQuery(fmt.Sprintf("%v", value)).SetField(colName), nil
+	case meta.FieldTypeBoolean:
+		v, err := meta.ParseBoolean(value)
+		if err != nil {
+			return nil, err
+		}
+		return bluge.NewTermQuery(meta.BooleanTerm(v)).SetField(colName), nil
+	case meta.FieldTypeDatetime:
+		v, err := meta.ParseDatetime(value)
+		if err != nil {
+			return nil, err
+		}
+		return bluge.NewDateRangeInclusiveQuery(v, v, true, true).SetField(colName), nil
+	case meta.FieldTypeGeoPoint, meta.FieldTypeVector:
+		return nil, fmt.Errorf("%w: equality on %s field %s", ErrSyntaxNotSupported, filedOption.Type, colName)
 	case meta.FieldTypeText:
-		return bluge.NewMatchQuery(fmt.Sprintf("%v", value)).SetField(colName)
+		query := bluge.NewMatchQuery(fmt.Sprintf("%v", value)).SetField(colName)
+		if analyzer, err := m.Analyzer(colName); err == nil {
+			query.SetAnalyzer(analyzer)
+		}
+		return query, nil
 	}
-	return nil
+	return unmappedQuery(), nil
 }
 
-func makeRangeQuery(column *ast.ColumnName, value interface{}, m meta.Mapping, opCode opcode.Op) bluge.Query {
+// unmappedQuery is used for a column missing from the mapping. No document
+// holds such a column, so nothing matches it.
+func unmappedQuery() bluge.Query {
+	return bluge.NewMatchNoneQuery()
+}
+
+func makeRangeQuery(column *ast.ColumnName, value interface{}, m meta.Mapping, opCode opcode.Op) (bluge.Query, error) {
 	colName := columnName(column)
 	if colName == meta.IdentifierField {
-		return bluge.NewMatchQuery(fmt.Sprintf("%v", value)).SetField(colName)
+		return bluge.NewMatchQuery(fmt.Sprintf("%v", value)).SetField(colName), nil
 	}
 
 	filedOption := m.Properties[colName]
 	switch filedOption.Type {
 	case meta.FieldTypeNumeric:
 		v, _ := strconv.ParseFloat(fmt.Sprintf("%v", value), 64)
-		return makeNumericRangeQuery(colName, v, opCode)
-	case meta.FieldTypeText:
-		return makeTermRangeQuery(colName, fmt.Sprintf("%v", value), opCode)
+		return makeNumericRangeQuery(colName, v, opCode), nil
+	case meta.FieldTypeDatetime:
+		v, err := meta.ParseDatetime(value)
+		if err != nil {
+			return nil, err
+		}
+		return makeDateRangeQuery(colName, v, opCode), nil
+	case meta.FieldTypeBoolean, meta.FieldTypeGeoPoint, meta.FieldTypeVector:
+		return nil, fmt.Errorf("%w: range on %s field %s", ErrSyntaxNotSupported, filedOption.Type, colName)
+	case meta.FieldTypeText, meta.FieldTypeKeyword:
+		return makeTermRangeQuery(colName, fmt.Sprintf("%v", value), opCode), nil
+	}
+	return unmappedQuery(), nil
+}
+
+func makeDateRangeQuery(field string, value time.Time, opCode opcode.Op) bluge.Query {
+	var query *bluge.DateRangeQuery
+	switch opCode {
+	case opcode.GT:
+		query = bluge.NewDateRangeInclusiveQuery(value, time.Time{}, false, false)
+	case opcode.GE:
+		query = bluge.NewDateRangeInclusiveQuery(value, time.Time{}, true, false)
+	case opcode.LT:
+		query = bluge.NewDateRangeInclusiveQuery(time.Time{}, value, false, false)
+	case opcode.LE:
+		query = bluge.NewDateRangeInclusiveQuery(time.Time{}, value, false, true)
+	}
+	return query.SetField(field)
+}
+
+// makeGeoDistanceQuery handles geo_distance(column, lon, lat) < distance, the
+// distance being meters or a string with unit such as '10km'.
+func makeGeoDistanceQuery(fn *ast.FuncCallExpr, value interface{}, m meta.Mapping, opCode opcode.Op) (bluge.Query, error) {
+	if opCode != opcode.LT && opCode != opcode.LE {
+		return nil, fmt.Errorf("%w: %s only supports < and <=", ErrSyntaxNotSupported, geoDistanceFunc)
+	}
+	if len(fn.Args) != 3 {
+		return nil, fmt.Errorf("%w: %s(column, lon, lat)", ErrSyntaxNotSupported, geoDistanceFunc)
+	}
+	column, ok := fn.Args[0].(*ast.ColumnNameExpr)
+	if !ok {
+		return nil, ErrEqLeftMustBeColumn
+	}
+	colName := columnName(column.Name)
+	if m.Properties[colName].Type != meta.FieldTypeGeoPoint {
+		return nil, fmt.Errorf("%w: %s is not a geopoint field", ErrSyntaxNotSupported, colName)
+	}
+
+	var point [2]float64
+	for i, arg := range fn.Args[1:] {
+		v, ok := literalValue(arg)
+		if !ok {
+			return nil, ErrEqRightMustBeValue
+		}
+		f, err := strconv.ParseFloat(fmt.Sprintf("%v", v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrEqRightMustBeValue, v)
+		}
+		point[i] = f
+	}
+
+	distance := fmt.Sprintf("%v", value)
+	if _, err := strconv.ParseFloat(distance, 64); err == nil {
+		distance += "m"
 	}
-	return nil
+	return bluge.NewGeoDistanceQuery(point[0], point[1], distance).SetField(colName), nil
 }
 
 func makeTermRangeQuery(field string, value string, opCode opcode.Op) bluge.Query {
@@ -0,0 +1,141 @@
+package poled
+
+import (
+	"context"
+	"io"
+
+	"pole/internal/pb"
+	sqlParser "pole/internal/poled/sql"
+	poleRaft "pole/internal/raft"
+	"pole/internal/util/log"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/search"
+)
+
+// streamPageSize is the number of hits read and sent at once by a streamed
+// select.
+const streamPageSize = 100
+
+// QueryStream runs a select page by page, summary is called first with the
+// totals and aggregations then page with the hits of each page as it is
+// read. Only a page of hits is held at once.
+func (p *Poled) QueryStream(sql string, summary func(*SelectSummary) error, page func([]Hit) error, opts ...ExecOption) error {
+	stmt, err := sqlParser.Parse(sql)
+	if err != nil {
+		return err
+	}
+	if stmt.ActionType != sqlParser.StmtTypeSelect {
+		return ErrNotSelectStmt
+	}
+	options := newExecOptions(opts)
+	if options.consistency == ReadConsistencyLeader && !p.isLearder() {
+		return p.streamByRpc(p.meta.Leader(), sql, summary, page)
+	}
+
+	idx := stmt.TableName
+	mapping, exists := p.meta.Get(idx)
+	if !exists {
+		return ErrIndexNotFound
+	}
+	shardReaders, err := p.shardReaders(idx, mapping, options)
+	if err != nil {
+		return err
+	}
+	defer releaseReaders(shardReaders)
+	readers := make([]*bluge.Reader, 0, len(shardReaders))
+	for _, reader := range shardReaders {
+		readers = append(readers, reader.Reader)
+	}
+
+	_, limit := stmt.Page()
+	builder := newHitBuilder(mapping, stmt)
+	hits := make([]Hit, 0, streamPageSize)
+	var after [][]byte
+	for sent := 0; ; {
+		size := limit - sent
+		if size > streamPageSize {
+			size = streamPageSize
+		}
+		req, err := stmt.BuildPage(mapping, size, after)
+		if err != nil {
+			return err
+		}
+		var iter search.DocumentMatchIterator
+		if len(readers) == 1 {
+			iter, err = readers[0].Search(context.Background(), req)
+		} else {
+			iter, err = bluge.MultiSearch(context.Background(), req, readers...)
+		}
+		if err != nil {
+			return err
+		}
+		if after == nil {
+			if err := summary(newSelectSummary(iter, mapping, stmt).summary()); err != nil {
+				return err
+			}
+		}
+
+		hits = hits[:0]
+		next, err := iter.Next()
+		for err == nil && next != nil {
+			hits = append(hits, builder.hit(next))
+			after = next.SortValue
+			next, err = iter.Next()
+		}
+		if err != nil {
+			return err
+		}
+		if len(hits) > 0 {
+			if err := page(hits); err != nil {
+				return err
+			}
+		}
+		if sent += len(hits); len(hits) < size || sent >= limit {
+			return nil
+		}
+	}
+}
+
+// streamByRpc relays the select streamed by another node.
+func (p *Poled) streamByRpc(addr, sql string, summary func(*SelectSummary) error, page func([]Hit) error) error {
+	client, err := poleRaft.GetClientConn(addr)
+	if err != nil {
+		return err
+	}
+	stream, err := pb.NewPoleClient(client).QueryStream(context.Background(), &pb.QueryRequest{Sql: sql})
+	if err != nil {
+		log.WithField("module", "streamByRpc").WithField("grpcAddr", addr).Error(err)
+		return err
+	}
+	hits := make([]Hit, 0, streamPageSize)
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if item := resp.GetSummary(); item != nil {
+			if err := summary(newSelectSummaryFromPb(item)); err != nil {
+				return err
+			}
+			continue
+		}
+		hit, err := newHitFromPb(resp.GetHit())
+		if err != nil {
+			return err
+		}
+		if hits = append(hits, hit); len(hits) == streamPageSize {
+			if err := page(hits); err != nil {
+				return err
+			}
+			hits = hits[:0]
+		}
+	}
+	if len(hits) == 0 {
+		return nil
+	}
+	return page(hits)
+}
@@ -0,0 +1,93 @@
+package poled
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"pole/internal/poled/meta"
+	sqlParser "pole/internal/poled/sql"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/index"
+)
+
+// translogDir is where the translogs of the writers are kept under
+// conf.DataPath.
+const translogDir = "translog"
+
+var ErrTranslogRecord = errors.New("invalid translog record")
+
+// translogRecord is what the translog keeps of a batch, the statement that
+// built it or the documents put and the ids deleted.
+type translogRecord struct {
+	Sql     string        `json:"sql,omitempty"`
+	Docs    []translogDoc `json:"docs,omitempty"`
+	Deletes []string      `json:"deletes,omitempty"`
+}
+
+type translogDoc struct {
+	ID     string                 `json:"id"`
+	Values map[string]interface{} `json:"values"`
+}
+
+func translogPath(dataPath, kind string) string {
+	return filepath.Join(dataPath, translogDir, kind)
+}
+
+func newSqlRecord(sql string) ([]byte, error) {
+	return json.Marshal(&translogRecord{Sql: sql})
+}
+
+// replayTranslog builds the batch of a record again for the shard named
+// name, the mapping is read from the meta restored by raft.
+func (p *Poled) replayTranslog(name string, data []byte) (*index.Batch, error) {
+	record := &translogRecord{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(record); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrTranslogRecord, err)
+	}
+	idx, shard := meta.ParseShardName(name)
+	mapping, exists := p.meta.Get(idx)
+	if !exists {
+		return nil, fmt.Errorf("%w:%s", ErrIndexNotFound, idx)
+	}
+
+	if record.Sql != "" {
+		stmt, err := sqlParser.Parse(record.Sql)
+		if err != nil {
+			return nil, err
+		}
+		batches, err := stmt.BuildBatches(mapping)
+		if err != nil {
+			return nil, err
+		}
+		batch, ok := batches[shard]
+		if !ok {
+			return nil, fmt.Errorf("%w: no document of shard %d", ErrTranslogRecord, shard)
+		}
+		return batch, nil
+	}
+
+	return newRecordBatch(mapping, record)
+}
+
+// newRecordBatch builds the batch putting the documents of the record and
+// deleting its ids.
+func newRecordBatch(mapping meta.Mapping, record *translogRecord) (*index.Batch, error) {
+	rs := index.NewBatch()
+	for _, item := range record.Docs {
+		doc, err := mapping.MakeDocument(item.ID, item.Values)
+		if err != nil {
+			return nil, err
+		}
+		rs.Update(doc.ID(), doc)
+	}
+	for _, id := range record.Deletes {
+		rs.Delete(bluge.Identifier(id))
+	}
+	return rs, nil
+}
@@ -0,0 +1,105 @@
+package poled
+
+import (
+	"context"
+	"encoding/json"
+
+	"pole/internal/poled/index"
+	"pole/internal/poled/meta"
+	sqlParser "pole/internal/poled/sql"
+
+	"github.com/blugelabs/bluge"
+)
+
+// storedDocuments reads the documents an update merges into from the writers
+// of the shards written here, their readers see every batch applied.
+type storedDocuments struct {
+	writers *index.Writers
+	idx     string
+	mapping meta.Mapping
+	// local reports the shards written here, the others are read by the
+	// nodes the write is routed to.
+	local   func(shard int) bool
+	readers map[int]*bluge.Reader
+}
+
+func newStoredDocuments(writers *index.Writers, idx string, mapping meta.Mapping, local func(int) bool) *storedDocuments {
+	return &storedDocuments{writers: writers, idx: idx, mapping: mapping, local: local, readers: make(map[int]*bluge.Reader)}
+}
+
+func (s *storedDocuments) reader(shard int) (*bluge.Reader, error) {
+	if reader, ok := s.readers[shard]; ok {
+		return reader, nil
+	}
+	writer, exists := s.writers.Get(s.mapping.ShardName(s.idx, shard))
+	if !exists {
+		return nil, ErrWriterNotFound
+	}
+	reader, err := writer.Reader()
+	if err != nil {
+		return nil, err
+	}
+	s.readers[shard] = reader
+	return reader, nil
+}
+
+// load returns the values of the stored document, nil when there is none or
+// its shard is not written here.
+func (s *storedDocuments) load(id string) (map[string]interface{}, error) {
+	shard := s.mapping.Shard(id)
+	if !s.local(shard) {
+		return nil, nil
+	}
+	reader, err := s.reader(shard)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := reader.Search(context.Background(), bluge.NewTopNSearch(1, bluge.NewTermQuery(id).SetField(meta.IdentifierField)))
+	if err != nil {
+		return nil, err
+	}
+	next, err := iter.Next()
+	if err != nil || next == nil {
+		return nil, err
+	}
+	rs := make(map[string]interface{})
+	err = next.VisitStoredFields(func(field string, value []byte) bool {
+		if field == meta.VersionField {
+			version, _ := bluge.DecodeNumericFloat64(value)
+			rs[field] = version
+			return true
+		}
+		if v, ok := parseValue(field, value, s.mapping, nil, true); ok {
+			rs[field] = v
+		}
+		return true
+	})
+	return rs, err
+}
+
+func (s *storedDocuments) close() {
+	for _, reader := range s.readers {
+		_ = reader.Close()
+	}
+}
+
+// newWriteRecord is what the translog keeps of the writes of a shard, the
+// statement unless the writes depend on the stored documents. Those are
+// kept as the documents written, the stored ones are not read on replay.
+func newWriteRecord(stmt *sqlParser.SqlVistor, writes []sqlParser.Write) ([]byte, error) {
+	if !stmt.ReadsDocuments() {
+		return newSqlRecord(stmt.Sql)
+	}
+	record := &translogRecord{}
+	for _, w := range writes {
+		if w.Err != nil {
+			continue
+		}
+		if w.Doc == nil {
+			record.Deletes = append(record.Deletes, w.ID)
+			continue
+		}
+		record.Docs = append(record.Docs, translogDoc{ID: w.ID, Values: w.Values})
+	}
+	return json.Marshal(record)
+}
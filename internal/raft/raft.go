@@ -77,7 +77,7 @@ func NewRaft(ctx context.Context, myID, myAddress, raftDir, join string, bootstr
 		}
 		cc := pb.NewNodeClient(client)
 
-		if _, err := cc.Join(ctx, &pb.JoinRequest{Id: myID, BindAddress: myAddress}); err != nil {
+		if _, err := cc.Join(ctx, &pb.JoinRequest{Id: myID, BindAddress: myAddress, GrpcAddress: conf.GetGrpcAddr()}); err != nil {
 			lg.Error("raft.Raft.Join: ", err)
 		}
 	}
@@ -101,6 +101,8 @@ func (n *raftNode) process(ctx context.Context) {
 			if isLearder {
 				cmd, _ := meta.NewBecomeLeaderCmd(conf.GetGrpcAddr())
 				n.Raft.Apply(cmd, 1*time.Second)
+				cmd, _ = meta.NewNodeJoinCmd(n.id, conf.GetGrpcAddr())
+				n.Raft.Apply(cmd, 1*time.Second)
 				lg.Info("become leader")
 			}
 		}
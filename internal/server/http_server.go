@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"time"
 
 	"pole/internal/poled"
 
@@ -38,14 +39,25 @@ func NewHttpServer(address string, poled *poled.Poled) (*HttpServer, error) {
 	router.GET("/_sql", s.exec)
 	router.POST("/_sql", s.exec)
 
+	router.POST("/_bulk", s.bulk)
+
 	router.GET("/_mapping", s.mapping)
+	router.GET("/_cache", s.cache)
+	router.GET("/_tasks", s.tasks)
+	router.GET("/_tasks/:id", s.task)
+	router.POST("/_tasks/:id/_cancel", s.cancelTask)
 	pprof.Register(router)
 	s.router = router
 	return s, nil
 }
 
 type SqlReq struct {
-	Query string `form:"query" binding:"required"`
+	Query        string `form:"query" binding:"required"`
+	Consistency  string `form:"consistency"`
+	MaxStaleness string `form:"max_staleness"`
+	Refresh      string `form:"refresh"`
+	// Task names an update or delete by query.
+	Task string `form:"task"`
 }
 
 type BadRequestResp struct {
@@ -63,7 +75,60 @@ func (s *HttpServer) exec(ctx *gin.Context) {
 		return
 	}
 
-	rs := s.poled.Exec(param.Query)
+	consistency, err := poled.ParseReadConsistency(param.Consistency)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, &BadRequestResp{Error: err.Error()})
+		return
+	}
+	var maxStaleness time.Duration
+	if param.MaxStaleness != "" {
+		if maxStaleness, err = time.ParseDuration(param.MaxStaleness); err != nil {
+			ctx.JSON(http.StatusBadRequest, &BadRequestResp{Error: err.Error()})
+			return
+		}
+	}
+
+	refresh, err := poled.ParseRefreshPolicy(param.Refresh)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, &BadRequestResp{Error: err.Error()})
+		return
+	}
+
+	rs := s.poled.Exec(param.Query, poled.WithConsistency(consistency, maxStaleness), poled.WithRefresh(refresh), poled.WithTask(param.Task))
+	ctx.JSON(rs.Code(), rs.Resp())
+}
+
+type BulkReq struct {
+	Index     string `form:"index" binding:"required"`
+	Format    string `form:"format"`
+	BatchSize int    `form:"batch_size"`
+	Refresh   string `form:"refresh"`
+}
+
+// bulk indexes the request body, the format defaults to csv for text/csv
+// bodies and to ndjson otherwise.
+func (s *HttpServer) bulk(ctx *gin.Context) {
+	param := &BulkReq{}
+	if err := ctx.ShouldBindQuery(param); err != nil {
+		ctx.JSON(http.StatusBadRequest, &BadRequestResp{Error: ErrBadRequest.Error()})
+		return
+	}
+	format := poled.BulkFormat(param.Format)
+	if format == "" && ctx.ContentType() == "text/csv" {
+		format = poled.BulkFormatCSV
+	}
+
+	refresh, err := poled.ParseRefreshPolicy(param.Refresh)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, &BadRequestResp{Error: err.Error()})
+		return
+	}
+
+	rs, err := s.poled.Bulk(param.Index, format, ctx.Request.Body, param.BatchSize, poled.WithRefresh(refresh))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, &BadRequestResp{Error: err.Error()})
+		return
+	}
 	ctx.JSON(rs.Code(), rs.Resp())
 }
 
@@ -71,6 +136,34 @@ func (s *HttpServer) mapping(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, s.poled.Mapping())
 }
 
+func (s *HttpServer) cache(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, s.poled.CacheStats())
+}
+
+// tasks lists the updates and deletes by query run on this node, they run on
+// the leader.
+func (s *HttpServer) tasks(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, s.poled.Tasks())
+}
+
+func (s *HttpServer) task(ctx *gin.Context) {
+	task, ok := s.poled.Task(ctx.Param("id"))
+	if !ok {
+		ctx.JSON(http.StatusNotFound, &BadRequestResp{Error: poled.ErrTaskNotFound.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, task)
+}
+
+// cancelTask stops the task after its current batch.
+func (s *HttpServer) cancelTask(ctx *gin.Context) {
+	if err := s.poled.CancelTask(ctx.Param("id")); err != nil {
+		ctx.JSON(http.StatusNotFound, &BadRequestResp{Error: err.Error()})
+		return
+	}
+	s.task(ctx)
+}
+
 func (s *HttpServer) Start() error {
 	go func() {
 		_ = http.Serve(s.listener, s.router)
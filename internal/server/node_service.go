@@ -3,6 +3,8 @@ package server
 import (
 	"context"
 	"pole/internal/pb"
+	"pole/internal/poled/meta"
+	"time"
 
 	"github.com/hashicorp/raft"
 )
@@ -26,15 +28,29 @@ func (s *NodeService) Join(ctx context.Context, req *pb.JoinRequest) (*pb.JoinRe
 		return nil, err
 	}
 
+	joined := false
 	for _, server := range cf.Configuration().Servers {
 		if server.ID == raft.ServerID(req.Id) {
-			return &pb.JoinResponse{Message: "success"}, nil
+			joined = true
+			break
 		}
 	}
 
-	f := s.raft.AddVoter(raft.ServerID(req.Id), raft.ServerAddress(req.BindAddress), 0, 0)
-	if err := f.Error(); err != nil {
-		return nil, err
+	if !joined {
+		f := s.raft.AddVoter(raft.ServerID(req.Id), raft.ServerAddress(req.BindAddress), 0, 0)
+		if err := f.Error(); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.GrpcAddress != "" {
+		cmd, err := meta.NewNodeJoinCmd(req.Id, req.GrpcAddress)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.raft.Apply(cmd, time.Second).Error(); err != nil {
+			return nil, err
+		}
 	}
 	return &pb.JoinResponse{Message: "success"}, nil
 }
@@ -54,6 +70,15 @@ func (s *NodeService) Leave(ctx context.Context, req *pb.LeaveRequest) (*pb.Leav
 				return nil, err
 			}
 
+			// the shards hosted by the node are handed over to the others
+			cmd, err := meta.NewNodeLeaveCmd(req.Id)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.raft.Apply(cmd, time.Second).Error(); err != nil {
+				return nil, err
+			}
+
 			return &pb.LeaveResponse{Message: "success"}, nil
 		}
 	}
@@ -2,8 +2,13 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"pole/internal/pb"
 	"pole/internal/poled"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 type PoleService struct {
@@ -18,13 +23,144 @@ func NewPoleService(poled *poled.Poled) *PoleService {
 }
 
 func (s *PoleService) Exec(ctx context.Context, req *pb.ExecRequest) (*pb.ExecResponse, error) {
-	rs := s.poled.Exec(req.Sql)
+	opts, err := writeOptions(req.Shards, req.Replica, req.Refresh)
+	if err != nil {
+		return nil, err
+	}
+	if req.Task != "" {
+		opts = append(opts, poled.WithTask(req.Task))
+	}
+	rs := s.poled.Exec(req.Sql, opts...)
 	if err := rs.Error(); err != nil {
 		return nil, err
 	}
+	// a statement with a report like BACKUP returns it as the message
+	if report, ok := rs.(fmt.Stringer); ok {
+		return &pb.ExecResponse{Message: report.String()}, nil
+	}
 	return &pb.ExecResponse{Message: "success"}, nil
 }
 
+func (s *PoleService) Query(ctx context.Context, req *pb.QueryRequest) (*pb.QueryResponse, error) {
+	opts, err := readOptions(req)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := s.poled.Query(req.Sql, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]*pb.Hit, 0, len(rs.Hits.Hits))
+	for _, hit := range rs.Hits.Hits {
+		item, err := toPbHit(hit)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, item)
+	}
+
+	aggregations, buckets, err := toPbAggregations(rs.Aggregations, rs.Buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.QueryResponse{
+		Took:     rs.Took,
+		TimedOut: rs.TimedOut,
+		Hits: &pb.Hits{
+			Total:    rs.Hits.Total,
+			MaxScore: rs.Hits.MaxScore,
+			Hits:     hits,
+		},
+		Aggregations: aggregations,
+		Buckets:      buckets,
+	}, nil
+}
+
+// QueryStream sends the summary of the select then its hits, they are read
+// and sent a page at a time.
+func (s *PoleService) QueryStream(req *pb.QueryRequest, stream pb.Pole_QueryStreamServer) error {
+	opts, err := readOptions(req)
+	if err != nil {
+		return err
+	}
+	summary := func(rs *poled.SelectSummary) error {
+		aggregations, buckets, err := toPbAggregations(rs.Aggregations, rs.Buckets)
+		if err != nil {
+			return err
+		}
+		return stream.Send(&pb.QueryStreamResponse{Body: &pb.QueryStreamResponse_Summary{Summary: &pb.QuerySummary{
+			Took:         rs.Took,
+			TimedOut:     rs.TimedOut,
+			Total:        rs.Total,
+			MaxScore:     rs.MaxScore,
+			Aggregations: aggregations,
+			Buckets:      buckets,
+		}}})
+	}
+	page := func(hits []poled.Hit) error {
+		for _, hit := range hits {
+			item, err := toPbHit(hit)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&pb.QueryStreamResponse{Body: &pb.QueryStreamResponse_Hit{Hit: item}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.poled.QueryStream(req.Sql, summary, page, opts...)
+}
+
+func (s *PoleService) Bulk(stream pb.Pole_BulkServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	opts, err := writeOptions(req.Shards, req.Replica, req.Refresh)
+	if err != nil {
+		return err
+	}
+	reader := &bulkStreamReader{stream: stream, buf: req.Data}
+	rs, err := s.poled.Bulk(req.Index, poled.BulkFormat(req.Format), reader, int(req.BatchSize), opts...)
+	if err != nil {
+		return err
+	}
+
+	resp := &pb.BulkResponse{
+		Took:     rs.Took,
+		Indexed:  rs.Indexed,
+		Failed:   rs.Failed,
+		Failures: make([]*pb.BulkFailure, 0, len(rs.Failures)),
+	}
+	for _, failure := range rs.Failures {
+		resp.Failures = append(resp.Failures, &pb.BulkFailure{Line: failure.Line, Id: failure.ID, Error: failure.Error})
+	}
+	return stream.SendAndClose(resp)
+}
+
+// bulkStreamReader reads the data chunks of a bulk stream as one payload.
+type bulkStreamReader struct {
+	stream pb.Pole_BulkServer
+	buf    []byte
+}
+
+func (r *bulkStreamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		req, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = req.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
 func (s *PoleService) Lock(ctx context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
 	if err := s.poled.Lock(req.LockUri); err != nil {
 		return nil, err
@@ -38,3 +174,101 @@ func (s *PoleService) Unlock(ctx context.Context, req *pb.UnlockRequest) (*pb.Un
 	}
 	return &pb.UnlockResponse{Message: "success"}, nil
 }
+
+func (s *PoleService) Load(ctx context.Context, req *pb.LoadRequest) (*pb.LoadResponse, error) {
+	docs, err := s.poled.Load(req.Index, req.Ids, req.AppliedIndex)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(docs)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.LoadResponse{Documents: data}, nil
+}
+
+func toShards(shards []int32) []int {
+	rs := make([]int, 0, len(shards))
+	for _, shard := range shards {
+		rs = append(rs, int(shard))
+	}
+	return rs
+}
+
+func writeOptions(shards []int32, replica bool, refresh string) ([]poled.ExecOption, error) {
+	policy, err := poled.ParseRefreshPolicy(refresh)
+	if err != nil {
+		return nil, err
+	}
+	rs := []poled.ExecOption{poled.WithShards(toShards(shards)...), poled.WithRefresh(policy)}
+	if replica {
+		rs = append(rs, poled.WithReplica())
+	}
+	return rs, nil
+}
+
+func readOptions(req *pb.QueryRequest) ([]poled.ExecOption, error) {
+	consistency, err := poled.ParseReadConsistency(req.Consistency)
+	if err != nil {
+		return nil, err
+	}
+	maxStaleness := time.Duration(req.MaxStalenessMs) * time.Millisecond
+	return []poled.ExecOption{poled.WithConsistency(consistency, maxStaleness)}, nil
+}
+
+func toPbHit(hit poled.Hit) (*pb.Hit, error) {
+	source, err := structpb.NewStruct(hit.Source)
+	if err != nil {
+		return nil, err
+	}
+	rs := &pb.Hit{Id: hit.ID, Source: source, Score: hit.Score, Version: hit.Version}
+	if hit.Explanation != nil {
+		explanation, err := json.Marshal(hit.Explanation)
+		if err != nil {
+			return nil, err
+		}
+		rs.Explanation = &structpb.Struct{}
+		if err := rs.Explanation.UnmarshalJSON(explanation); err != nil {
+			return nil, err
+		}
+	}
+	if len(hit.Highlight) > 0 {
+		highlight := make(map[string]interface{}, len(hit.Highlight))
+		for field, fragments := range hit.Highlight {
+			values := make([]interface{}, len(fragments))
+			for i, fragment := range fragments {
+				values[i] = fragment
+			}
+			highlight[field] = values
+		}
+		if rs.Highlight, err = structpb.NewStruct(highlight); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+func toPbAggregations(aggs map[string]interface{}, buckets []poled.Bucket) (*structpb.Struct, []*pb.Bucket, error) {
+	var aggregations *structpb.Struct
+	if aggs != nil {
+		var err error
+		aggregations, err = structpb.NewStruct(aggs)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rs := make([]*pb.Bucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		key, err := structpb.NewStruct(bucket.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		metrics, err := structpb.NewStruct(bucket.Metrics)
+		if err != nil {
+			return nil, nil, err
+		}
+		rs = append(rs, &pb.Bucket{Key: key, DocCount: bucket.Count, Metrics: metrics})
+	}
+	return aggregations, rs, nil
+}